@@ -0,0 +1,447 @@
+// Package cluster drives iptb testbeds from go. It offers the operations of
+// the iptb command to tests and tools which would otherwise shell out to it
+// and parse its output.
+//
+//	c, err := cluster.Create(cluster.Options{Type: "localipfs", Count: 4})
+//	if err != nil {
+//		return err
+//	}
+//	defer c.Destroy(ctx)
+//
+//	if err := c.InitAll(ctx); err != nil {
+//		return err
+//	}
+//
+//	if err := c.StartAll(ctx); err != nil {
+//		return err
+//	}
+//
+//	if err := c.ConnectTopology(ctx, cluster.Star(0)); err != nil {
+//		return err
+//	}
+//
+//	outs, err := c.RunAll(ctx, "ipfs", "id", "-f", "<id>")
+//
+// Plugins which are not registered with testbed.RegisterPlugin are loaded
+// from the plugins directory under the IPTB_ROOT.
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/iptb/testbed"
+	"github.com/ipfs/iptb/testbed/interfaces"
+)
+
+// Options configure the testbed built by Create
+type Options struct {
+	// Root is the IPTB_ROOT the testbed is created under. It defaults to
+	// $IPTB_ROOT, or $HOME/testbed.
+	Root string
+	// Name of the testbed, defaults to "default"
+	Name string
+	// PluginDir is where plugins are loaded from, defaults to Root/plugins
+	PluginDir string
+
+	// Type is the plugin implementing the nodes, such as localipfs
+	Type string
+	// Count is the number of nodes
+	Count int
+	// Attrs are given to every node
+	Attrs map[string]string
+	// Metadata are recorded in the manifest of the testbed
+	Metadata map[string]string
+	// Binaries are distributed across the nodes, round robin
+	Binaries []string
+	// PortBase, when set, assigns each node a fixed range of ports starting
+	// at this port, PortStride ports apart. Only nodes listening on the
+	// host, such as local nodes outside of a network namespace, take ports.
+	PortBase   int
+	PortStride int
+
+	// Force replaces an existing testbed of the same name, by default
+	// Create fails
+	Force bool
+	// LockWait is how long to wait for another process changing the
+	// testbed, by default Create fails right away
+	LockWait time.Duration
+}
+
+// Cluster is a testbed of nodes, operations on all nodes run concurrently
+type Cluster struct {
+	tb testbed.BasicTestbed
+}
+
+// Create builds the specs of a new testbed, the nodes still need to be
+// initialized with InitAll
+func Create(opts Options) (*Cluster, error) {
+	root, err := rootDir(opts.Root)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Name == "" {
+		opts.Name = "default"
+	}
+
+	if opts.PluginDir == "" {
+		opts.PluginDir = filepath.Join(root, "plugins")
+	}
+
+	if opts.Count <= 0 {
+		return nil, fmt.Errorf("testbed needs at least one node")
+	}
+
+	if err := loadPlugin(opts.Type, opts.PluginDir); err != nil {
+		return nil, err
+	}
+
+	tb := testbed.NewTestbed(filepath.Join(root, "testbeds", opts.Name))
+
+	lock, err := tb.Lock(opts.LockWait)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+
+	if _, err := os.Stat(filepath.Join(tb.Dir(), testbed.ManifestFile)); err == nil {
+		if !opts.Force {
+			return nil, fmt.Errorf("testbed %s already exists", opts.Name)
+		}
+
+		// Nodes failing to release their resources do not keep the
+		// testbed from being replaced
+		err := tb.Destroy(context.TODO())
+		if _, ok := err.(testbed.DestroyWarnings); err != nil && !ok {
+			return nil, err
+		}
+	}
+
+	specs, err := testbed.BuildSpecs(tb.Dir(), opts.Count, opts.Type, opts.Attrs)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, spec := range specs {
+		if len(opts.Binaries) != 0 {
+			spec.SetAttr("binary", opts.Binaries[i%len(opts.Binaries)])
+		}
+	}
+
+	if _, ok := opts.Attrs["privnet"]; ok {
+		if err := testbed.SetupPrivateNetwork(tb.Dir(), specs); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.PortBase != 0 {
+		pa := testbed.NewPortAllocator(opts.PortBase, false)
+		if opts.PortStride != 0 {
+			pa.Stride = opts.PortStride
+		}
+
+		if err := pa.Assign(specs); err != nil {
+			return nil, err
+		}
+	}
+
+	manifest := &testbed.Manifest{
+		Metadata: opts.Metadata,
+		Created:  testbed.NewCreationInfo(),
+		Nodes:    specs,
+	}
+
+	if err := testbed.WriteManifest(tb.Dir(), manifest); err != nil {
+		return nil, err
+	}
+
+	return &Cluster{tb: tb}, nil
+}
+
+// Open returns the existing testbed name under root, an empty root defaults
+// to $IPTB_ROOT, or $HOME/testbed
+func Open(root, name string) (*Cluster, error) {
+	root, err := rootDir(root)
+	if err != nil {
+		return nil, err
+	}
+
+	tb := testbed.NewTestbed(filepath.Join(root, "testbeds", name))
+
+	specs, err := tb.Specs()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, spec := range specs {
+		if err := loadPlugin(spec.Type, filepath.Join(root, "plugins")); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Cluster{tb: tb}, nil
+}
+
+// Testbed returns the testbed of the cluster
+func (c *Cluster) Testbed() *testbed.BasicTestbed {
+	return &c.tb
+}
+
+// Dir returns the directory of the testbed
+func (c *Cluster) Dir() string {
+	return c.tb.Dir()
+}
+
+// Nodes returns the nodes of the testbed, in order
+func (c *Cluster) Nodes() ([]testbedi.Core, error) {
+	return c.tb.Nodes()
+}
+
+// InitAll initializes every node
+func (c *Cluster) InitAll(ctx context.Context, args ...string) error {
+	specs, err := c.tb.Specs()
+	if err != nil {
+		return err
+	}
+
+	for i, spec := range specs {
+		if err := spec.CheckPorts(); err != nil {
+			return &NodeError{Node: i, Err: err}
+		}
+	}
+
+	return c.each(func(n int, node testbedi.Core) error {
+		_, err := node.Init(ctx, args...)
+		return err
+	})
+}
+
+// StartAll starts every node, waiting for each to accept commands
+func (c *Cluster) StartAll(ctx context.Context, args ...string) error {
+	return c.each(func(n int, node testbedi.Core) error {
+		_, err := node.Start(ctx, true, args...)
+		return err
+	})
+}
+
+// StopAll stops every node
+func (c *Cluster) StopAll(ctx context.Context) error {
+	return c.each(func(n int, node testbedi.Core) error {
+		return node.Stop(ctx)
+	})
+}
+
+// Output is the outcome of a command run on a node
+type Output struct {
+	Node     int
+	Args     []string
+	ExitCode int
+	Stdout   string
+	Stderr   string
+	// Err reports a failure to run the command
+	Err     error
+	Elapsed time.Duration
+}
+
+// Run runs a command on node n
+func (c *Cluster) Run(ctx context.Context, n int, args ...string) (Output, error) {
+	node, err := c.tb.Node(n)
+	if err != nil {
+		return Output{Node: n, Args: args}, err
+	}
+
+	return run(ctx, n, node, args)
+}
+
+// RunAll runs a command on every node. The outputs are in node order, and
+// are returned even when the command fails to run on some nodes.
+func (c *Cluster) RunAll(ctx context.Context, args ...string) ([]Output, error) {
+	nodes, err := c.tb.Nodes()
+	if err != nil {
+		return nil, err
+	}
+
+	outs := make([]Output, len(nodes))
+	err = c.each(func(n int, node testbedi.Core) error {
+		var err error
+		outs[n], err = run(ctx, n, node, args)
+		return err
+	})
+
+	return outs, err
+}
+
+// ConnectTopology connects the nodes along the edges of topo, in order
+func (c *Cluster) ConnectTopology(ctx context.Context, topo Topology) error {
+	nodes, err := c.tb.Nodes()
+	if err != nil {
+		return err
+	}
+
+	var errs Errors
+	for _, e := range topo(len(nodes)) {
+		if e.From < 0 || e.From >= len(nodes) || e.To < 0 || e.To >= len(nodes) {
+			return fmt.Errorf("edge %d -> %d outside of the testbed of %d nodes", e.From, e.To, len(nodes))
+		}
+
+		if err := nodes[e.From].Connect(ctx, nodes[e.To]); err != nil {
+			errs = append(errs, &NodeError{Node: e.From, Err: fmt.Errorf("connecting to node[%d]: %s", e.To, err)})
+		}
+	}
+
+	if len(errs) != 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// Destroy releases the resources held by the nodes and removes the testbed
+func (c *Cluster) Destroy(ctx context.Context) error {
+	return c.tb.Destroy(ctx)
+}
+
+// NodeError is the failure of an operation on a node
+type NodeError struct {
+	Node int
+	Err  error
+}
+
+func (e *NodeError) Error() string {
+	return fmt.Sprintf("node[%d]: %s", e.Node, e.Err)
+}
+
+// Errors are the failures of an operation on several nodes, in node order
+type Errors []*NodeError
+
+func (es Errors) Error() string {
+	var msgs []string
+	for _, e := range es {
+		msgs = append(msgs, e.Error())
+	}
+
+	return strings.Join(msgs, "\n")
+}
+
+// each runs fn on every node concurrently, collecting the errors
+func (c *Cluster) each(fn func(n int, node testbedi.Core) error) error {
+	nodes, err := c.tb.Nodes()
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(nodes))
+
+	for n, node := range nodes {
+		wg.Add(1)
+		go func(n int, node testbedi.Core) {
+			defer wg.Done()
+			errs[n] = fn(n, node)
+		}(n, node)
+	}
+
+	wg.Wait()
+
+	var out Errors
+	for n, err := range errs {
+		if err != nil {
+			out = append(out, &NodeError{Node: n, Err: err})
+		}
+	}
+
+	if len(out) != 0 {
+		return out
+	}
+
+	return nil
+}
+
+func run(ctx context.Context, n int, node testbedi.Core, args []string) (Output, error) {
+	out := Output{Node: n, Args: args}
+
+	start := time.Now()
+	res, err := node.RunCmd(ctx, nil, args...)
+	out.Elapsed = time.Since(start)
+
+	if err != nil {
+		out.Err = err
+		return out, err
+	}
+
+	out.ExitCode = res.ExitCode()
+	out.Err = res.Error()
+
+	stdout, err := ioutil.ReadAll(res.Stdout())
+	if err != nil {
+		return out, err
+	}
+
+	stderr, err := ioutil.ReadAll(res.Stderr())
+	if err != nil {
+		return out, err
+	}
+
+	out.Stdout = string(stdout)
+	out.Stderr = string(stderr)
+
+	return out, nil
+}
+
+func rootDir(root string) (string, error) {
+	if root == "" {
+		root = os.Getenv("IPTB_ROOT")
+	}
+
+	if root == "" {
+		home := os.Getenv("HOME")
+		if home == "" {
+			return "", fmt.Errorf("environment variable HOME not set")
+		}
+
+		root = filepath.Join(home, "testbed")
+	}
+
+	return filepath.Abs(root)
+}
+
+// loadPlugin makes sure the plugin name is registered, loading the plugins
+// found in dir when it is not
+func loadPlugin(name, dir string) error {
+	if _, ok := testbed.GetPlugin(name); ok {
+		return nil
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for _, f := range files {
+		plg, err := testbed.LoadPlugin(filepath.Join(dir, f.Name()))
+		if err != nil {
+			continue
+		}
+
+		if _, ok := testbed.GetPlugin(plg.PluginName); ok {
+			continue
+		}
+
+		if _, err := testbed.RegisterPlugin(*plg, false); err != nil {
+			return err
+		}
+	}
+
+	if _, ok := testbed.GetPlugin(name); !ok {
+		return fmt.Errorf("could not find plugin %s in %s", name, dir)
+	}
+
+	return nil
+}
@@ -0,0 +1,182 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/iptb/testbed"
+	"github.com/ipfs/iptb/testbed/interfaces"
+	"github.com/ipfs/iptb/util"
+)
+
+// fakeNode records the operations made on it in its directory
+type fakeNode struct {
+	dir string
+}
+
+func init() {
+	testbed.RegisterPlugin(testbed.IptbPlugin{
+		PluginName: "fake",
+		NewNode: func(dir string, attrs map[string]string) (testbedi.Core, error) {
+			return &fakeNode{dir: dir}, nil
+		},
+	}, false)
+}
+
+func (f *fakeNode) record(line string) error {
+	fi, err := os.OpenFile(filepath.Join(f.dir, "ops"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0664)
+	if err != nil {
+		return err
+	}
+	defer fi.Close()
+
+	_, err = fmt.Fprintln(fi, line)
+	return err
+}
+
+func (f *fakeNode) Init(ctx context.Context, args ...string) (testbedi.Output, error) {
+	return nil, f.record("init")
+}
+
+func (f *fakeNode) Start(ctx context.Context, wait bool, args ...string) (testbedi.Output, error) {
+	return nil, f.record("start")
+}
+
+func (f *fakeNode) Stop(ctx context.Context) error {
+	return f.record("stop")
+}
+
+func (f *fakeNode) RunCmd(ctx context.Context, stdin io.Reader, args ...string) (testbedi.Output, error) {
+	if args[0] == "fail" {
+		return nil, fmt.Errorf("could not run")
+	}
+
+	return iptbutil.NewOutput(args, []byte(filepath.Base(f.dir)), nil, len(args), nil), nil
+}
+
+func (f *fakeNode) Connect(ctx context.Context, n testbedi.Core) error {
+	return f.record("connect " + filepath.Base(n.Dir()))
+}
+
+func (f *fakeNode) Shell(ctx context.Context, ns []testbedi.Core) error { return nil }
+func (f *fakeNode) PeerID() (string, error)                             { return f.dir, nil }
+func (f *fakeNode) APIAddr() (string, error)                            { return "", nil }
+func (f *fakeNode) SwarmAddrs() ([]string, error)                       { return nil, nil }
+func (f *fakeNode) Dir() string                                         { return f.dir }
+func (f *fakeNode) Type() string                                        { return "fake" }
+func (f *fakeNode) String() string                                      { return f.dir }
+
+func ops(t *testing.T, c *Cluster, n int) []string {
+	data, err := ioutil.ReadFile(filepath.Join(c.Dir(), fmt.Sprint(n), "ops"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return strings.Fields(strings.Replace(strings.TrimSpace(string(data)), " ", "_", -1))
+}
+
+func TestCluster(t *testing.T) {
+	ctx := context.Background()
+
+	c := New(t, Options{Type: "fake", Count: 3})
+
+	if err := c.InitAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.StartAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.ConnectTopology(ctx, Star(0)); err != nil {
+		t.Fatal(err)
+	}
+
+	outs, err := c.RunAll(ctx, "echo", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for n, out := range outs {
+		if out.Node != n || out.Stdout != fmt.Sprint(n) || out.ExitCode != 2 {
+			t.Fatalf("unexpected output %+v", out)
+		}
+	}
+
+	if err := c.StopAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := [][]string{
+		{"init", "start", "stop"},
+		{"init", "start", "connect_0", "stop"},
+		{"init", "start", "connect_0", "stop"},
+	}
+
+	for n, exp := range expected {
+		if got := ops(t, c, n); strings.Join(got, ",") != strings.Join(exp, ",") {
+			t.Fatalf("node %d: expected %v, got %v", n, exp, got)
+		}
+	}
+}
+
+func TestClusterErrors(t *testing.T) {
+	c := New(t, Options{Type: "fake", Count: 2})
+
+	outs, err := c.RunAll(context.Background(), "fail")
+
+	errs, ok := err.(Errors)
+	if !ok || len(errs) != 2 || errs[1].Node != 1 {
+		t.Fatalf("expected an error for each node, got %v", err)
+	}
+
+	if len(outs) != 2 || outs[1].Err == nil {
+		t.Fatalf("expected outputs to report the error, got %+v", outs)
+	}
+
+	if err := c.ConnectTopology(context.Background(), Edges(Edge{0, 2})); err == nil {
+		t.Fatal("expected an error for an edge outside of the testbed")
+	}
+}
+
+func TestCreateExisting(t *testing.T) {
+	root, err := ioutil.TempDir("", "iptb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	opts := Options{Root: root, Type: "fake", Count: 1}
+	if _, err := Create(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Create(opts); err == nil {
+		t.Fatal("expected an error creating an existing testbed")
+	}
+
+	opts.Force = true
+	if _, err := Create(opts); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Open(root, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nodes, err := c.Nodes()
+	if err != nil || len(nodes) != 1 {
+		t.Fatalf("expected one node, got %d: %v", len(nodes), err)
+	}
+
+	if _, err := Create(Options{Root: root, Type: "missing", Count: 1}); err == nil {
+		t.Fatal("expected an error for a missing plugin")
+	}
+}
@@ -0,0 +1,50 @@
+package cluster
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// New creates a testbed for the test t under a temporary IPTB_ROOT. The
+// testbed is destroyed and the root removed when the test ends. Plugins are
+// loaded from the plugins directory of the IPTB_ROOT of the environment
+// unless opts.PluginDir is set, and binaries are still resolved under it.
+// Any failure fails the test.
+func New(t testing.TB, opts Options) *Cluster {
+	t.Helper()
+
+	if opts.PluginDir == "" {
+		root, err := rootDir("")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		opts.PluginDir = filepath.Join(root, "plugins")
+	}
+
+	root, err := ioutil.TempDir("", "iptb")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts.Root = root
+
+	c, err := Create(opts)
+	if err != nil {
+		os.RemoveAll(root)
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		if err := c.Destroy(context.Background()); err != nil {
+			t.Errorf("destroying testbed: %s", err)
+		}
+
+		os.RemoveAll(root)
+	})
+
+	return c
+}
@@ -0,0 +1,69 @@
+package cluster
+
+// Edge connects node From to node To
+type Edge struct {
+	From int
+	To   int
+}
+
+// Topology returns the edges connecting a testbed of n nodes
+type Topology func(n int) []Edge
+
+// Full connects every node to every other node
+func Full() Topology {
+	return func(n int) []Edge {
+		var edges []Edge
+		for i := 0; i < n; i++ {
+			for j := i + 1; j < n; j++ {
+				edges = append(edges, Edge{i, j})
+			}
+		}
+
+		return edges
+	}
+}
+
+// Star connects every node to node center
+func Star(center int) Topology {
+	return func(n int) []Edge {
+		var edges []Edge
+		for i := 0; i < n; i++ {
+			if i != center {
+				edges = append(edges, Edge{i, center})
+			}
+		}
+
+		return edges
+	}
+}
+
+// Line connects each node to the next one
+func Line() Topology {
+	return func(n int) []Edge {
+		var edges []Edge
+		for i := 0; i+1 < n; i++ {
+			edges = append(edges, Edge{i, i + 1})
+		}
+
+		return edges
+	}
+}
+
+// Ring connects each node to the next one, and the last node to the first
+func Ring() Topology {
+	return func(n int) []Edge {
+		edges := Line()(n)
+		if n > 2 {
+			edges = append(edges, Edge{n - 1, 0})
+		}
+
+		return edges
+	}
+}
+
+// Edges connects the nodes along the given edges
+func Edges(edges ...Edge) Topology {
+	return func(n int) []Edge {
+		return edges
+	}
+}
@@ -0,0 +1,25 @@
+package cluster
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestTopologies(t *testing.T) {
+	cases := []struct {
+		topo     Topology
+		expected string
+	}{
+		{Full(), "[{0 1} {0 2} {0 3} {1 2} {1 3} {2 3}]"},
+		{Star(1), "[{0 1} {2 1} {3 1}]"},
+		{Line(), "[{0 1} {1 2} {2 3}]"},
+		{Ring(), "[{0 1} {1 2} {2 3} {3 0}]"},
+		{Edges(Edge{3, 0}), "[{3 0}]"},
+	}
+
+	for _, c := range cases {
+		if got := fmt.Sprint(c.topo(4)); got != c.expected {
+			t.Fatalf("expected %s, got %s", c.expected, got)
+		}
+	}
+}
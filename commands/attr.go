@@ -1,9 +1,9 @@
 package commands
 
 import (
+	"context"
 	"fmt"
 	"path"
-	"strconv"
 
 	cli "github.com/urfave/cli"
 
@@ -24,8 +24,8 @@ var AttrCmd = cli.Command{
 
 var AttrSetCmd = cli.Command{
 	Name:      "set",
-	Usage:     "set an attribute for a node",
-	ArgsUsage: "<node> <attr> <value>",
+	Usage:     "set an attribute for specified nodes",
+	ArgsUsage: "<nodes> <attr> <value>",
 	Flags: []cli.Flag{
 		cli.BoolFlag{
 			Name:  "save",
@@ -35,94 +35,133 @@ var AttrSetCmd = cli.Command{
 	Action: func(c *cli.Context) error {
 		flagRoot := c.GlobalString("IPTB_ROOT")
 		flagTestbed := c.GlobalString("testbed")
+		flagEncoding := c.GlobalString("encoding")
 		flagSave := c.Bool("save")
 
 		if c.NArg() != 3 {
 			return NewUsageError("set takes exactly 3 argument")
 		}
 
-		argNode := c.Args()[0]
+		argNodes := c.Args()[0]
 		argAttr := c.Args()[1]
 		argValue := c.Args()[2]
 
-		i, err := strconv.Atoi(argNode)
+		tb := testbed.NewTestbed(path.Join(flagRoot, "testbeds", flagTestbed))
+
+		// The specs are read after taking the lock, so they are up to date
+		// when written back
+		if flagSave {
+			lock, err := tb.Lock(c.GlobalDuration("lock-wait"))
+			if err != nil {
+				return err
+			}
+			defer lock.Unlock()
+		}
+
+		specs, err := tb.Specs()
 		if err != nil {
-			return fmt.Errorf("parse err: %s", err)
+			return err
 		}
 
-		tb := testbed.NewTestbed(path.Join(flagRoot, "testbeds", flagTestbed))
+		list, err := parseNodes(argNodes, specs)
+		if err != nil {
+			return err
+		}
 
-		node, err := tb.Node(i)
+		nodes, err := tb.Select(context.Background(), list)
 		if err != nil {
 			return err
 		}
 
-		attrNode, ok := node.(testbedi.Attribute)
-		if !ok {
-			return fmt.Errorf("node does not implement attributes")
+		runCmd := func(node testbedi.Core) (testbedi.Output, error) {
+			attrNode, ok := node.(testbedi.Attribute)
+			if !ok {
+				return nil, fmt.Errorf("node does not implement attributes")
+			}
+
+			return nil, attrNode.SetAttr(argAttr, argValue)
 		}
 
-		if err := attrNode.SetAttr(argAttr, argValue); err != nil {
+		results, err := mapWithOutput(list, nodes, runCmd)
+		if err != nil {
 			return err
 		}
 
+		// Only the nodes which took the value have it saved
 		if flagSave {
-			specs, err := tb.Specs()
-			if err != nil {
-				return err
+			for _, rs := range results {
+				if rs.Error != nil {
+					continue
+				}
+
+				// Network emulation settings accumulate, all of them are
+				// saved
+				if isLinkAttr(argAttr) {
+					if err := saveLinkSettings(specs[rs.Node], nodes[rs.Node]); err != nil {
+						return fmt.Errorf("node[%d]: %s", rs.Node, err)
+					}
+
+					continue
+				}
+
+				specs[rs.Node].SetAttr(argAttr, argValue)
 			}
 
-			specs[i].SetAttr(argAttr, argValue)
-
 			if err := testbed.WriteNodeSpecs(tb.Dir(), specs); err != nil {
 				return err
 			}
 		}
 
-		return nil
+		return buildReport(results, flagEncoding)
 	},
 }
 
 var AttrGetCmd = cli.Command{
 	Name:      "get",
-	Usage:     "get an attribute for a node",
-	ArgsUsage: "<node> <attr>",
+	Usage:     "get an attribute for specified nodes",
+	ArgsUsage: "<nodes> <attr>",
+	Description: `
+The value of a single node given by index is printed as it is, unless the
+encoding is json. Other nodes are reported like the output of run.
+`,
 	Action: func(c *cli.Context) error {
 		flagRoot := c.GlobalString("IPTB_ROOT")
 		flagTestbed := c.GlobalString("testbed")
+		flagEncoding := c.GlobalString("encoding")
 
 		if c.NArg() != 2 {
 			return NewUsageError("get takes exactly 2 argument")
 		}
 
-		argNode := c.Args()[0]
+		argNodes := c.Args()[0]
 		argAttr := c.Args()[1]
 
-		i, err := strconv.Atoi(argNode)
-		if err != nil {
-			return fmt.Errorf("parse err: %s", err)
-		}
-
 		tb := testbed.NewTestbed(path.Join(flagRoot, "testbeds", flagTestbed))
-
-		node, err := tb.Node(i)
+		specs, err := tb.Specs()
 		if err != nil {
 			return err
 		}
 
-		attrNode, ok := node.(testbedi.Attribute)
-		if !ok {
-			return fmt.Errorf("node does not implement attributes")
+		list, err := parseNodes(argNodes, specs)
+		if err != nil {
+			return err
 		}
 
-		value, err := attrNode.Attr(argAttr)
+		nodes, err := tb.Select(context.Background(), list)
 		if err != nil {
 			return err
 		}
 
-		_, err = fmt.Fprintf(c.App.Writer, "%s\n", value)
+		get := func(node testbedi.Core) (string, error) {
+			attrNode, ok := node.(testbedi.Attribute)
+			if !ok {
+				return "", fmt.Errorf("node does not implement attributes")
+			}
 
-		return err
+			return attrNode.Attr(argAttr)
+		}
+
+		return reportValues(c, argNodes, list, nodes, flagEncoding, get)
 	},
 }
 
@@ -146,19 +185,18 @@ var AttrListCmd = cli.Command{
 		}
 
 		if c.Args().Present() {
-			i, err := strconv.Atoi(c.Args().First())
+			tb := testbed.NewTestbed(path.Join(flagRoot, "testbeds", flagTestbed))
+			specs, err := tb.Specs()
 			if err != nil {
-				return fmt.Errorf("parse err: %s", err)
+				return err
 			}
 
-			tb := testbed.NewTestbed(path.Join(flagRoot, "testbeds", flagTestbed))
-
-			spec, err := tb.Spec(i)
+			i, err := parseNode(c.Args().First(), specs)
 			if err != nil {
 				return err
 			}
 
-			flagType = spec.Type
+			flagType = specs[i].Type
 		}
 
 		plg, ok := testbed.GetPlugin(flagType)
@@ -179,3 +217,48 @@ var AttrListCmd = cli.Command{
 		return nil
 	},
 }
+
+// linkConditions are the attributes of the network emulation settings of a
+// node, named after the link conditions
+var linkConditions = []string{"latency", "jitter", "bandwidth", "loss"}
+
+// isLinkAttr reports whether attr changes the network emulation settings of
+// a node, netem resets them
+func isLinkAttr(attr string) bool {
+	for _, c := range linkConditions {
+		if attr == c {
+			return true
+		}
+	}
+
+	return attr == "netem"
+}
+
+// saveLinkSettings records the network emulation settings of node in its
+// spec, conditions which are not set are removed
+func saveLinkSettings(spec *testbed.NodeSpec, node testbedi.Core) error {
+	attrNode, ok := node.(testbedi.Attribute)
+	if !ok {
+		return fmt.Errorf("node does not implement attributes")
+	}
+
+	for _, attr := range linkConditions {
+		v, err := attrNode.Attr(attr)
+		if err != nil {
+			return err
+		}
+
+		var ls testbedi.LinkSettings
+		if err := ls.Set(attr, v); err != nil {
+			return err
+		}
+
+		if ls.IsZero() {
+			delete(spec.Attrs, attr)
+		} else {
+			spec.SetAttr(attr, v)
+		}
+	}
+
+	return nil
+}
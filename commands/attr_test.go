@@ -0,0 +1,43 @@
+package commands
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	cli "github.com/urfave/cli"
+
+	"github.com/ipfs/iptb/testbed/interfaces"
+)
+
+type valueNode struct {
+	testbedi.Core
+	value string
+}
+
+func TestReportValuesSingleIndex(t *testing.T) {
+	var buf bytes.Buffer
+
+	app := cli.NewApp()
+	app.Writer = &buf
+	c := cli.NewContext(app, nil, nil)
+
+	nodes := []testbedi.Core{&valueNode{value: "a"}, &valueNode{value: "b"}}
+	get := func(node testbedi.Core) (string, error) {
+		return node.(*valueNode).value, nil
+	}
+
+	if err := reportValues(c, "1", []int{1}, nodes, "text", get); err != nil {
+		t.Fatal(err)
+	}
+
+	expect(t, buf.String(), "b\n")
+
+	fail := func(node testbedi.Core) (string, error) {
+		return "", fmt.Errorf("no value")
+	}
+
+	if err := reportValues(c, "[0-1]", []int{0, 1}, nodes, "text", fail); err == nil {
+		t.Fatal("expected the errors of the nodes to be reported")
+	}
+}
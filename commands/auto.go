@@ -57,6 +57,12 @@ $ iptb auto           -count 5 -type <type>
 		flagForce := c.Bool("force")
 
 		tb := testbed.NewTestbed(path.Join(flagRoot, "testbeds", flagTestbed))
+
+		lock, err := tb.Lock(c.GlobalDuration("lock-wait"))
+		if err != nil {
+			return err
+		}
+		defer lock.Unlock()
 		if err := testbed.AlreadyInitCheck(tb.Dir(), flagForce); err != nil {
 			return err
 		}
@@ -0,0 +1,619 @@
+package commands
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	cli "github.com/urfave/cli"
+
+	"github.com/ipfs/iptb/testbed"
+	"github.com/ipfs/iptb/testbed/interfaces"
+	"github.com/ipfs/iptb/util"
+)
+
+var BenchCmd = cli.Command{
+	Category: "METRICS",
+	Name:     "bench",
+	Usage:    "run benchmarks against the testbed",
+	Subcommands: []cli.Command{
+		BenchFetchCmd,
+	},
+}
+
+var BenchFetchCmd = cli.Command{
+	Name:      "fetch",
+	Usage:     "measure how long nodes take to fetch content added to other nodes",
+	ArgsUsage: "--from [nodes] --to [nodes]",
+	Description: `
+The fetch benchmark seeds content on the providers, then every fetcher
+concurrently retrieves it through its api. For every file fetched the
+time to first byte, total duration, throughput and the number of duplicate
+blocks received by bitswap are reported, followed by summary statistics.
+With --method get only the content of the files in the tar archive returned
+by the api is counted towards the bytes fetched.
+
+The csv format holds a single table: the samples, or the summary statistics
+with --summary.
+
+Every repetition seeds new content, so fetchers never hit their cache.
+
+$ iptb bench fetch --from 0 --to [1-4] --size 10MB --repeat 5
+$ iptb bench fetch --from [0-1] --to [2-9] --format csv > fetch.csv
+$ iptb bench fetch --from [0-1] --to [2-9] --format csv --summary > summary.csv
+`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "from",
+			Usage: "nodes providing the content",
+			Value: "0",
+		},
+		cli.StringFlag{
+			Name:  "to",
+			Usage: "nodes fetching the content, providers excluded (default: all)",
+		},
+		cli.StringFlag{
+			Name:  "size",
+			Usage: "size of each file (e.g. 512KB, 100MB)",
+			Value: "1MB",
+		},
+		cli.IntFlag{
+			Name:  "files",
+			Usage: "number of files fetched per repetition",
+			Value: 1,
+		},
+		cli.IntFlag{
+			Name:  "repeat",
+			Usage: "number of repetitions",
+			Value: 1,
+		},
+		cli.Int64Flag{
+			Name:  "seed",
+			Usage: "seed used to generate the content",
+		},
+		cli.StringFlag{
+			Name:  "chunker",
+			Usage: "chunking algorithm passed to ipfs add",
+		},
+		cli.StringFlag{
+			Name:  "method",
+			Usage: "api command used to fetch content (cat, get)",
+			Value: "cat",
+		},
+		cli.BoolTFlag{
+			Name:  "connect",
+			Usage: "connect fetchers to providers before fetching",
+		},
+		cli.StringFlag{
+			Name:  "timeout",
+			Usage: "timeout for each fetch",
+			Value: "5m",
+		},
+		cli.StringFlag{
+			Name:  "format",
+			Usage: "output format (text, json, csv), defaults to the global encoding",
+		},
+		cli.BoolFlag{
+			Name:  "summary",
+			Usage: "only report the summary statistics",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		flagRoot := c.GlobalString("IPTB_ROOT")
+		flagTestbed := c.GlobalString("testbed")
+		flagEncoding := c.GlobalString("encoding")
+		flagFrom := c.String("from")
+		flagTo := c.String("to")
+		flagFiles := c.Int("files")
+		flagRepeat := c.Int("repeat")
+		flagSeed := c.Int64("seed")
+		flagChunker := c.String("chunker")
+		flagMethod := c.String("method")
+		flagConnect := c.BoolT("connect")
+		flagFormat := c.String("format")
+		flagSummary := c.Bool("summary")
+
+		if flagFormat == "" {
+			flagFormat = flagEncoding
+		}
+
+		switch flagFormat {
+		case "text", "json", "csv":
+		default:
+			return NewUsageError(fmt.Sprintf("unknown format %s", flagFormat))
+		}
+
+		if flagMethod != "cat" && flagMethod != "get" {
+			return NewUsageError(fmt.Sprintf("unknown method %s", flagMethod))
+		}
+
+		if flagFiles < 1 || flagRepeat < 1 {
+			return NewUsageError("--files and --repeat must be at least 1")
+		}
+
+		size, err := iptbutil.ParseBytes(c.String("size"))
+		if err != nil {
+			return err
+		}
+
+		timeout, err := time.ParseDuration(c.String("timeout"))
+		if err != nil {
+			return err
+		}
+
+		tb := testbed.NewTestbed(path.Join(flagRoot, "testbeds", flagTestbed))
+		nodes, err := tb.Nodes()
+		if err != nil {
+			return err
+		}
+
+		specs, err := tb.Specs()
+		if err != nil {
+			return err
+		}
+
+		if flagTo == "" {
+			flagTo = "all"
+		}
+
+		from, err := parseNodes(flagFrom, specs)
+		if err != nil {
+			return err
+		}
+
+		all, err := parseNodes(flagTo, specs)
+		if err != nil {
+			return err
+		}
+
+		// Providers would fetch the content they hold
+		providers := make(map[int]bool)
+		for _, f := range from {
+			providers[f] = true
+		}
+
+		var to []int
+		for _, t := range all {
+			if !providers[t] {
+				to = append(to, t)
+			}
+		}
+
+		if len(to) == 0 {
+			return NewUsageError("no fetchers left once the providers are excluded")
+		}
+
+		if flagConnect {
+			for _, t := range to {
+				for _, f := range from {
+					if t == f {
+						continue
+					}
+
+					ctx, cancel := context.WithTimeout(context.Background(), timeout)
+					err := nodes[t].Connect(ctx, nodes[f])
+					cancel()
+
+					if err != nil {
+						return errors.Wrapf(err, "node[%d] => node[%d]", t, f)
+					}
+				}
+			}
+		}
+
+		var samples []fetchSample
+		for rep := 0; rep < flagRepeat; rep++ {
+			opts := seedOptions{
+				Size:    size,
+				Files:   flagFiles,
+				Seed:    flagSeed + int64(rep*flagFiles),
+				Chunker: flagChunker,
+			}
+
+			cids, err := seedProviders(from, nodes, opts)
+			if err != nil {
+				return err
+			}
+
+			samples = append(samples, fetchAll(rep, to, nodes, cids, flagMethod, timeout)...)
+		}
+
+		return writeBenchReport(os.Stdout, flagFormat, samples, flagSummary)
+	},
+}
+
+// fetchSample is a single fetch of a file by a node
+type fetchSample struct {
+	Repetition int
+	Node       int
+	Cid        string
+	Bytes      int64
+	TTFB       float64
+	Duration   float64
+	Throughput float64
+	DupBlocks  uint64
+	Error      string `json:",omitempty"`
+}
+
+// benchSummary holds summary statistics for one metric over all samples
+type benchSummary struct {
+	Metric string
+	Count  int
+	Min    float64
+	Median float64
+	P95    float64
+	Max    float64
+}
+
+type bitswapStat struct {
+	BlocksReceived  uint64
+	DupBlksReceived uint64
+}
+
+// seedProviders adds the same content to every provider and returns its cids
+func seedProviders(list []int, nodes []testbedi.Core, opts seedOptions) ([]string, error) {
+	var lk sync.Mutex
+	var cids []string
+
+	runCmd := func(node testbedi.Core) (testbedi.Output, error) {
+		added, err := seedContent(context.Background(), node, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		lk.Lock()
+		defer lk.Unlock()
+
+		if cids == nil {
+			for _, s := range added {
+				cids = append(cids, s.Cid)
+			}
+		}
+
+		return nil, nil
+	}
+
+	results, err := mapWithOutput(list, nodes, runCmd)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rs := range results {
+		if rs.Error != nil {
+			return nil, rs.Error
+		}
+	}
+
+	return cids, nil
+}
+
+// fetchAll fetches every cid on every node in list concurrently, files are
+// fetched one after another on each node
+func fetchAll(rep int, list []int, nodes []testbedi.Core, cids []string, method string, timeout time.Duration) []fetchSample {
+	var wg sync.WaitGroup
+	samples := make([][]fetchSample, len(list))
+
+	for i, n := range list {
+		wg.Add(1)
+		go func(i, n int) {
+			defer wg.Done()
+
+			for _, cid := range cids {
+				ctx, cancel := context.WithTimeout(context.Background(), timeout)
+				sample, err := fetch(ctx, nodes[n], cid, method)
+				cancel()
+
+				sample.Repetition = rep
+				sample.Node = n
+				sample.Cid = cid
+				if err != nil {
+					sample.Error = errors.Wrapf(err, "node[%d]", n).Error()
+				}
+
+				samples[i] = append(samples[i], sample)
+			}
+		}(i, n)
+	}
+
+	wg.Wait()
+
+	var out []fetchSample
+	for _, s := range samples {
+		out = append(out, s...)
+	}
+
+	return out
+}
+
+func fetch(ctx context.Context, node testbedi.Core, cid, method string) (fetchSample, error) {
+	var sample fetchSample
+
+	before, err := getBitswapStat(ctx, node)
+	if err != nil {
+		return sample, err
+	}
+
+	resp, start, err := apiRequest(ctx, node, method, cid)
+	if err != nil {
+		return sample, err
+	}
+	defer resp.Body.Close()
+
+	body := &timedReader{r: resp.Body}
+
+	// get returns a tar archive of the content, its framing is not counted
+	if method == "get" {
+		sample.Bytes, err = tarPayload(body)
+	} else {
+		sample.Bytes, err = io.Copy(ioutil.Discard, body)
+	}
+
+	if !body.first.IsZero() {
+		sample.TTFB = body.first.Sub(start).Seconds()
+	}
+
+	if err != nil {
+		return sample, err
+	}
+
+	sample.Duration = time.Since(start).Seconds()
+	if sample.Duration > 0 {
+		sample.Throughput = float64(sample.Bytes) / sample.Duration
+	}
+
+	after, err := getBitswapStat(ctx, node)
+	if err != nil {
+		return sample, err
+	}
+
+	sample.DupBlocks = after.DupBlksReceived - before.DupBlksReceived
+
+	return sample, nil
+}
+
+// timedReader records when the first byte is read from r
+type timedReader struct {
+	r     io.Reader
+	first time.Time
+}
+
+func (t *timedReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if n > 0 && t.first.IsZero() {
+		t.first = time.Now()
+	}
+
+	return n, err
+}
+
+// tarPayload reads the tar archive r to its end, returning the size of the
+// files it holds
+func tarPayload(r io.Reader) (int64, error) {
+	tr := tar.NewReader(r)
+
+	var total int64
+	for {
+		if _, err := tr.Next(); err == io.EOF {
+			break
+		} else if err != nil {
+			return total, err
+		}
+
+		n, err := io.Copy(ioutil.Discard, tr)
+		total += n
+
+		if err != nil {
+			return total, err
+		}
+	}
+
+	// The transfer is only complete once the padding of the archive is read
+	_, err := io.Copy(ioutil.Discard, r)
+	return total, err
+}
+
+func getBitswapStat(ctx context.Context, node testbedi.Core) (*bitswapStat, error) {
+	resp, _, err := apiRequest(ctx, node, "bitswap/stat", "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var stat bitswapStat
+	if err := json.NewDecoder(resp.Body).Decode(&stat); err != nil {
+		return nil, err
+	}
+
+	return &stat, nil
+}
+
+// apiRequest calls the http api of the node, returning the response and the
+// time the request was sent
+func apiRequest(ctx context.Context, node testbedi.Core, endpoint, arg string) (*http.Response, time.Time, error) {
+	u, err := iptbutil.APIURL(node, endpoint)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	if arg != "" {
+		u += "?arg=" + url.QueryEscape(arg)
+	}
+
+	req, err := http.NewRequest("POST", u, nil)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	start := time.Now()
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, start, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, start, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return resp, start, nil
+}
+
+// summarize computes summary statistics of the successful samples
+func summarize(samples []fetchSample) []benchSummary {
+	metrics := []struct {
+		name  string
+		value func(fetchSample) float64
+	}{
+		{"ttfb", func(s fetchSample) float64 { return s.TTFB }},
+		{"duration", func(s fetchSample) float64 { return s.Duration }},
+		{"throughput", func(s fetchSample) float64 { return s.Throughput }},
+		{"dup_blocks", func(s fetchSample) float64 { return float64(s.DupBlocks) }},
+	}
+
+	var out []benchSummary
+	for _, m := range metrics {
+		var values []float64
+		for _, s := range samples {
+			if s.Error == "" {
+				values = append(values, m.value(s))
+			}
+		}
+
+		summary := benchSummary{Metric: m.name, Count: len(values)}
+		if len(values) != 0 {
+			sort.Float64s(values)
+			summary.Min = values[0]
+			summary.Median = percentile(values, 50)
+			summary.P95 = percentile(values, 95)
+			summary.Max = values[len(values)-1]
+		}
+
+		out = append(out, summary)
+	}
+
+	return out
+}
+
+// percentile returns the nearest-rank percentile p of the sorted values
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+
+	return sorted[rank-1]
+}
+
+// writeBenchReport writes the samples followed by their summary statistics,
+// or only the latter when summaryOnly is set. Csv reports hold one of them.
+func writeBenchReport(w io.Writer, format string, samples []fetchSample, summaryOnly bool) error {
+	summary := summarize(samples)
+
+	switch format {
+	case "json":
+		report := struct {
+			Samples []fetchSample `json:",omitempty"`
+			Summary []benchSummary
+		}{Summary: summary}
+
+		if !summaryOnly {
+			report.Samples = samples
+		}
+
+		return json.NewEncoder(w).Encode(report)
+	case "csv":
+		cw := csv.NewWriter(w)
+		if summaryOnly {
+			cw.Write([]string{"metric", "count", "min", "median", "p95", "max"})
+			for _, s := range summary {
+				cw.Write([]string{s.Metric, fmt.Sprint(s.Count), fmt.Sprint(s.Min), fmt.Sprint(s.Median), fmt.Sprint(s.P95), fmt.Sprint(s.Max)})
+			}
+
+			cw.Flush()
+			return cw.Error()
+		}
+
+		cw.Write([]string{"repetition", "node", "cid", "bytes", "ttfb", "duration", "throughput", "dup_blocks", "error"})
+		for _, s := range samples {
+			cw.Write([]string{
+				fmt.Sprint(s.Repetition),
+				fmt.Sprint(s.Node),
+				s.Cid,
+				fmt.Sprint(s.Bytes),
+				fmt.Sprint(s.TTFB),
+				fmt.Sprint(s.Duration),
+				fmt.Sprint(s.Throughput),
+				fmt.Sprint(s.DupBlocks),
+				s.Error,
+			})
+		}
+
+		cw.Flush()
+		return cw.Error()
+	default:
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		if !summaryOnly {
+			fmt.Fprintln(tw, "REP\tNODE\tCID\tBYTES\tTTFB\tDURATION\tTHROUGHPUT\tDUP BLOCKS\t")
+			for _, s := range samples {
+				if s.Error != "" {
+					fmt.Fprintf(tw, "%d\t%d\t%s\terror: %s\n", s.Repetition, s.Node, s.Cid, s.Error)
+					continue
+				}
+
+				fmt.Fprintf(tw, "%d\t%d\t%s\t%d\t%s\t%s\t%s/s\t%d\t\n", s.Repetition, s.Node, s.Cid, s.Bytes,
+					seconds(s.TTFB), seconds(s.Duration), humanBytes(s.Throughput), s.DupBlocks)
+			}
+
+			fmt.Fprintln(tw)
+		}
+
+		fmt.Fprintln(tw, "METRIC\tCOUNT\tMIN\tMEDIAN\tP95\tMAX\t")
+		for _, s := range summary {
+			format := func(v float64) string { return fmt.Sprint(v) }
+			switch s.Metric {
+			case "ttfb", "duration":
+				format = seconds
+			case "throughput":
+				format = func(v float64) string { return humanBytes(v) + "/s" }
+			}
+
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\t\n", s.Metric, s.Count,
+				format(s.Min), format(s.Median), format(s.P95), format(s.Max))
+		}
+
+		return tw.Flush()
+	}
+}
+
+func seconds(s float64) string {
+	return time.Duration(s * float64(time.Second)).Round(time.Microsecond).String()
+}
+
+func humanBytes(b float64) string {
+	units := []string{"B", "KB", "MB", "GB"}
+
+	i := 0
+	for b >= 1000 && i < len(units)-1 {
+		b /= 1000
+		i++
+	}
+
+	return fmt.Sprintf("%.1f%s", b, units[i])
+}
@@ -0,0 +1,77 @@
+package commands
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/csv"
+	"testing"
+)
+
+func TestPercentile(t *testing.T) {
+	values := []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+
+	expect(t, percentile(values, 50), 5.0)
+	expect(t, percentile(values, 95), 10.0)
+	expect(t, percentile(values, 0), 1.0)
+	expect(t, percentile([]float64{42}, 95), 42.0)
+}
+
+func TestSummarize(t *testing.T) {
+	samples := []fetchSample{
+		{TTFB: 0.1, Duration: 1, Throughput: 100, DupBlocks: 0},
+		{TTFB: 0.3, Duration: 3, Throughput: 300, DupBlocks: 4},
+		{TTFB: 0.2, Duration: 2, Throughput: 200, DupBlocks: 2},
+		{Error: "timeout"},
+	}
+
+	summary := summarize(samples)
+
+	expect(t, len(summary), 4)
+	expect(t, summary[1], benchSummary{Metric: "duration", Count: 3, Min: 1, Median: 2, P95: 3, Max: 3})
+	expect(t, summary[3], benchSummary{Metric: "dup_blocks", Count: 3, Min: 0, Median: 2, P95: 4, Max: 4})
+}
+
+func TestBenchReportCSV(t *testing.T) {
+	samples := []fetchSample{
+		{Node: 1, Cid: "QmA", Bytes: 10, Duration: 1, Throughput: 10},
+		{Node: 2, Cid: "QmA", Error: "timeout"},
+	}
+
+	for summaryOnly, rows := range map[bool]int{false: 3, true: 5} {
+		var buf bytes.Buffer
+		if err := writeBenchReport(&buf, "csv", samples, summaryOnly); err != nil {
+			t.Fatal(err)
+		}
+
+		records, err := csv.NewReader(&buf).ReadAll()
+		if err != nil {
+			t.Fatalf("summary %v: %s", summaryOnly, err)
+		}
+
+		expect(t, len(records), rows)
+	}
+}
+
+func TestTarPayload(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+
+	tw.WriteHeader(&tar.Header{Name: "dir", Typeflag: tar.TypeDir, Mode: 0755})
+	for _, name := range []string{"dir/a", "dir/b"} {
+		tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: 1000})
+		tw.Write(make([]byte, 1000))
+	}
+	tw.Close()
+
+	size := buf.Len()
+
+	n, err := tarPayload(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect(t, n, int64(2000))
+	if size <= 2000 || buf.Len() != 0 {
+		t.Fatalf("expected the %d bytes of the archive to be read", size)
+	}
+}
@@ -0,0 +1,388 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	cli "github.com/urfave/cli"
+
+	"github.com/ipfs/iptb/testbed"
+	"github.com/ipfs/iptb/testbed/interfaces"
+)
+
+// ChurnLogFile is the name of the file, in the testbed directory, recording
+// the transitions made by `iptb churn`
+const ChurnLogFile = "churn.log"
+
+var ChurnCmd = cli.Command{
+	Category:  "CORE",
+	Name:      "churn",
+	Usage:     "repeatedly stop and start specified nodes (or all)",
+	ArgsUsage: "[nodes]",
+	Description: `
+Churn stops and starts nodes on a random schedule. Every node alternates
+between uptimes and downtimes drawn from the model around the given
+means: exponential draws memoryless durations, uniform draws durations
+between zero and twice the mean. Nodes are expected to be running when
+churn starts. Each node draws from its own generator derived from the
+seed, so a seed always produces the same schedule.
+
+Every transition is printed and appended, as a json line, to churn.log in
+the testbed directory. When churn ends, after --duration or on interrupt,
+nodes which are down are started again unless --restore=false is passed.
+Transitions under way are completed first, each stop and start is bounded
+by --timeout instead. A node failing to stop is left up.
+
+$ iptb churn [1-9] --mean-uptime 5m --mean-downtime 1m --seed 42 --duration 1h
+$ iptb churn [1-9] --model uniform --reconnect 0
+`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "model",
+			Usage: "distribution of uptimes and downtimes (uniform, exponential)",
+			Value: "exponential",
+		},
+		cli.DurationFlag{
+			Name:  "mean-uptime",
+			Usage: "mean time a node stays up",
+			Value: 5 * time.Minute,
+		},
+		cli.DurationFlag{
+			Name:  "mean-downtime",
+			Usage: "mean time a node stays down",
+			Value: time.Minute,
+		},
+		cli.Int64Flag{
+			Name:  "seed",
+			Usage: "seed of the schedule, defaults to the current time",
+		},
+		cli.DurationFlag{
+			Name:  "duration",
+			Usage: "how long to churn for, 0 churns until interrupted",
+			Value: time.Hour,
+		},
+		cli.StringFlag{
+			Name:  "reconnect",
+			Usage: "nodes to connect restarted nodes to",
+		},
+		cli.BoolTFlag{
+			Name:  "restore",
+			Usage: "start the nodes which are down when churn ends",
+		},
+		cli.DurationFlag{
+			Name:  "timeout",
+			Usage: "timeout of each stop and start",
+			Value: time.Minute,
+		},
+	},
+	Action: func(c *cli.Context) error {
+		flagRoot := c.GlobalString("IPTB_ROOT")
+		flagTestbed := c.GlobalString("testbed")
+		flagModel := c.String("model")
+		flagUptime := c.Duration("mean-uptime")
+		flagDowntime := c.Duration("mean-downtime")
+		flagSeed := c.Int64("seed")
+		flagDuration := c.Duration("duration")
+		flagReconnect := c.String("reconnect")
+		flagRestore := c.BoolT("restore")
+		flagTimeout := c.Duration("timeout")
+
+		model, ok := churnModels[flagModel]
+		if !ok {
+			return NewUsageError(fmt.Sprintf("unknown model %s", flagModel))
+		}
+
+		if flagUptime <= 0 || flagDowntime <= 0 {
+			return NewUsageError("--mean-uptime and --mean-downtime must be positive")
+		}
+
+		if flagTimeout <= 0 {
+			return NewUsageError("--timeout must be positive")
+		}
+
+		if !c.IsSet("seed") {
+			flagSeed = time.Now().UnixNano()
+		}
+
+		tb := testbed.NewTestbed(path.Join(flagRoot, "testbeds", flagTestbed))
+		nodes, err := tb.Nodes()
+		if err != nil {
+			return err
+		}
+
+		specs, err := tb.Specs()
+		if err != nil {
+			return err
+		}
+
+		nodeRange := c.Args().First()
+
+		if nodeRange == "" {
+			nodeRange = fmt.Sprintf("[0-%d]", len(nodes)-1)
+		}
+
+		list, err := parseNodes(nodeRange, specs)
+		if err != nil {
+			return err
+		}
+
+		var reconnect []int
+		if flagReconnect != "" {
+			reconnect, err = parseNodes(flagReconnect, specs)
+			if err != nil {
+				return err
+			}
+		}
+
+		logf, err := os.OpenFile(filepath.Join(tb.Dir(), ChurnLogFile), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0664)
+		if err != nil {
+			return err
+		}
+		defer logf.Close()
+
+		ctx := context.Background()
+		if flagDuration > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, flagDuration)
+			defer cancel()
+		}
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt)
+		defer signal.Stop(sigs)
+
+		go func() {
+			select {
+			case <-sigs:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		ch := &churner{
+			nodes:     nodes,
+			reconnect: reconnect,
+			timeout:   flagTimeout,
+			up:        make(map[int]bool),
+			log:       io.MultiWriter(logf, c.App.Writer),
+			links: func(n int) error {
+				return restoreLinks(tb, []int{n})
+			},
+		}
+
+		for _, n := range list {
+			ch.up[n] = true
+		}
+
+		for _, n := range reconnect {
+			if _, ok := ch.up[n]; !ok {
+				ch.up[n] = true
+			}
+		}
+
+		ch.record(churnEvent{Node: -1, Event: "begin", Seed: flagSeed})
+
+		var wg sync.WaitGroup
+		for _, n := range list {
+			wg.Add(1)
+			go func(n int) {
+				defer wg.Done()
+
+				r := rand.New(rand.NewSource(flagSeed + int64(n)))
+				ch.run(ctx, n, func() time.Duration {
+					return model(r, flagUptime)
+				}, func() time.Duration {
+					return model(r, flagDowntime)
+				})
+
+				if flagRestore && !ch.isUp(n) {
+					ch.start(n)
+				}
+			}(n)
+		}
+
+		wg.Wait()
+
+		ch.record(churnEvent{Node: -1, Event: "end"})
+
+		return nil
+	},
+}
+
+// churnModel draws a duration around mean
+type churnModel func(r *rand.Rand, mean time.Duration) time.Duration
+
+var churnModels = map[string]churnModel{
+	"exponential": func(r *rand.Rand, mean time.Duration) time.Duration {
+		return time.Duration(r.ExpFloat64() * float64(mean))
+	},
+	"uniform": func(r *rand.Rand, mean time.Duration) time.Duration {
+		return time.Duration(r.Float64() * 2 * float64(mean))
+	},
+}
+
+// churnEvent is a line of the churn log
+type churnEvent struct {
+	Time    time.Time
+	Node    int
+	Event   string
+	Seed    int64   `json:",omitempty"`
+	Elapsed float64 `json:",omitempty"`
+	Error   string  `json:",omitempty"`
+}
+
+type churner struct {
+	nodes     []testbedi.Core
+	reconnect []int
+	log       io.Writer
+
+	// timeout bounds each stop and start, which are not interrupted when
+	// churn ends so nodes are not left half stopped
+	timeout time.Duration
+
+	// links shapes again the links of a node once it started
+	links   func(n int) error
+	linksLk sync.Mutex
+
+	lk sync.Mutex
+	up map[int]bool
+}
+
+// run alternates node n between up and down until ctx is done
+func (ch *churner) run(ctx context.Context, n int, uptime, downtime func() time.Duration) {
+	for {
+		if !sleepCtx(ctx, uptime()) {
+			return
+		}
+
+		if !ch.stop(n) {
+			continue
+		}
+
+		if !sleepCtx(ctx, downtime()) {
+			return
+		}
+
+		ch.start(n)
+	}
+}
+
+// stop stops node n, which is only marked down if it stopped
+func (ch *churner) stop(n int) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), ch.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := ch.nodes[n].Stop(ctx)
+	ch.record(churnEvent{Node: n, Event: "stop", Elapsed: time.Since(start).Seconds(), Error: errString(err)})
+
+	if err != nil {
+		return false
+	}
+
+	ch.setUp(n, false)
+	return true
+}
+
+func (ch *churner) start(n int) {
+	ctx, cancel := context.WithTimeout(context.Background(), ch.timeout)
+	defer cancel()
+
+	start := time.Now()
+	_, err := ch.nodes[n].Start(ctx, true)
+	ch.record(churnEvent{Node: n, Event: "start", Elapsed: time.Since(start).Seconds(), Error: errString(err)})
+
+	if err != nil {
+		return
+	}
+
+	ch.setUp(n, true)
+
+	if ch.links != nil {
+		// Nodes starting together would shape the same interfaces
+		ch.linksLk.Lock()
+		start := time.Now()
+		err := ch.links(n)
+		ch.linksLk.Unlock()
+
+		if err != nil {
+			ch.record(churnEvent{Node: n, Event: "links", Elapsed: time.Since(start).Seconds(), Error: errString(err)})
+		}
+	}
+
+	for _, t := range ch.reconnect {
+		if t == n || !ch.isUp(t) {
+			continue
+		}
+
+		cctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		start := time.Now()
+		err := ch.nodes[n].Connect(cctx, ch.nodes[t])
+		cancel()
+
+		ch.record(churnEvent{
+			Node:    n,
+			Event:   fmt.Sprintf("connect %d", t),
+			Elapsed: time.Since(start).Seconds(),
+			Error:   errString(err),
+		})
+	}
+}
+
+func (ch *churner) setUp(n int, up bool) {
+	ch.lk.Lock()
+	defer ch.lk.Unlock()
+	ch.up[n] = up
+}
+
+func (ch *churner) isUp(n int) bool {
+	ch.lk.Lock()
+	defer ch.lk.Unlock()
+	return ch.up[n]
+}
+
+func (ch *churner) record(ev churnEvent) {
+	ev.Time = time.Now().UTC()
+
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+
+	ch.lk.Lock()
+	defer ch.lk.Unlock()
+	ch.log.Write(append(data, '\n'))
+}
+
+// sleepCtx waits for d, returning false if ctx is done first
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	return err.Error()
+}
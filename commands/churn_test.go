@@ -0,0 +1,76 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/ipfs/iptb/testbed/interfaces"
+)
+
+func TestChurnModels(t *testing.T) {
+	mean := time.Minute
+
+	for name, model := range churnModels {
+		r := rand.New(rand.NewSource(42))
+
+		var sum time.Duration
+		for i := 0; i < 10000; i++ {
+			d := model(r, mean)
+			if d < 0 {
+				t.Fatalf("%s: negative duration %s", name, d)
+			}
+
+			if name == "uniform" && d > 2*mean {
+				t.Fatalf("%s: duration %s over twice the mean", name, d)
+			}
+
+			sum += d
+		}
+
+		avg := sum / 10000
+		if avg < 55*time.Second || avg > 65*time.Second {
+			t.Fatalf("%s: mean %s, expected around %s", name, avg, mean)
+		}
+
+		a := rand.New(rand.NewSource(7))
+		b := rand.New(rand.NewSource(7))
+		for i := 0; i < 10; i++ {
+			expect(t, model(a, mean), model(b, mean))
+		}
+	}
+}
+
+// stopNode fails to stop with err, recording whether its context was live
+type stopNode struct {
+	testbedi.Core
+	err  error
+	live bool
+}
+
+func (n *stopNode) Stop(ctx context.Context) error {
+	n.live = ctx.Err() == nil
+	return n.err
+}
+
+func TestChurnerStop(t *testing.T) {
+	failing := &stopNode{err: errors.New("busy")}
+	stopping := &stopNode{}
+
+	ch := &churner{
+		nodes:   []testbedi.Core{failing, stopping},
+		log:     ioutil.Discard,
+		timeout: time.Minute,
+		up:      map[int]bool{0: true, 1: true},
+	}
+
+	expect(t, ch.stop(0), false)
+	expect(t, ch.isUp(0), true)
+
+	expect(t, ch.stop(1), true)
+	expect(t, ch.isUp(1), false)
+	expect(t, stopping.live, true)
+}
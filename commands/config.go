@@ -0,0 +1,181 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+
+	cli "github.com/urfave/cli"
+
+	"github.com/ipfs/iptb/testbed"
+	"github.com/ipfs/iptb/testbed/interfaces"
+	"github.com/ipfs/iptb/util"
+)
+
+var ConfigCmd = cli.Command{
+	Category: "ATTRIBUTES",
+	Name:     "config",
+	Usage:    "get, set, patch node configuration",
+	Description: `
+The config command reads and modifies the configuration of nodes which
+expose one. Keys are addressed by a dot separated path into the json
+configuration, for example Swarm.ConnMgr.HighWater.
+
+Values are parsed as json, falling back to a plain string. Nodes need to
+be restarted for changes to take effect.
+
+$ iptb config get [0-4] Swarm.ConnMgr
+$ iptb config set [0-4] Swarm.ConnMgr.HighWater 100
+$ iptb config patch [0-4] Experimental '{"ShardingEnabled": true}'
+`,
+	Subcommands: []cli.Command{
+		ConfigGetCmd,
+		ConfigSetCmd,
+		ConfigPatchCmd,
+	},
+}
+
+var ConfigGetCmd = cli.Command{
+	Name:      "get",
+	Usage:     "get a configuration value from nodes",
+	ArgsUsage: "[nodes] <path>",
+	Action: func(c *cli.Context) error {
+		var argPath string
+
+		switch c.NArg() {
+		case 1:
+			argPath = c.Args()[0]
+		case 2:
+			argPath = c.Args()[1]
+		default:
+			return NewUsageError("get takes 1 or 2 arguments")
+		}
+
+		return configMap(c, c.NArg() == 2, func(cfg map[string]interface{}) (interface{}, bool, error) {
+			value, err := iptbutil.GetPath(cfg, argPath)
+			return value, false, err
+		})
+	},
+}
+
+var ConfigSetCmd = cli.Command{
+	Name:      "set",
+	Usage:     "set a configuration value on nodes",
+	ArgsUsage: "[nodes] <path> <value>",
+	Action: func(c *cli.Context) error {
+		var argPath, argValue string
+
+		switch c.NArg() {
+		case 2:
+			argPath, argValue = c.Args()[0], c.Args()[1]
+		case 3:
+			argPath, argValue = c.Args()[1], c.Args()[2]
+		default:
+			return NewUsageError("set takes 2 or 3 arguments")
+		}
+
+		value := iptbutil.ParseValue(argValue)
+
+		return configMap(c, c.NArg() == 3, func(cfg map[string]interface{}) (interface{}, bool, error) {
+			return nil, true, iptbutil.SetPath(cfg, argPath, value)
+		})
+	},
+}
+
+var ConfigPatchCmd = cli.Command{
+	Name:      "patch",
+	Usage:     "merge a json object into the configuration of nodes",
+	ArgsUsage: "[nodes] <path> <json>",
+	Action: func(c *cli.Context) error {
+		var argPath, argValue string
+
+		switch c.NArg() {
+		case 2:
+			argPath, argValue = c.Args()[0], c.Args()[1]
+		case 3:
+			argPath, argValue = c.Args()[1], c.Args()[2]
+		default:
+			return NewUsageError("patch takes 2 or 3 arguments")
+		}
+
+		var patch map[string]interface{}
+		if err := json.Unmarshal([]byte(argValue), &patch); err != nil {
+			return fmt.Errorf("patch is not a json object: %s", err)
+		}
+
+		return configMap(c, c.NArg() == 3, func(cfg map[string]interface{}) (interface{}, bool, error) {
+			return nil, true, iptbutil.MergePath(cfg, argPath, patch)
+		})
+	},
+}
+
+// configFunc operates on the configuration of a node, it returns a value to
+// report and whether the configuration should be written back
+type configFunc func(cfg map[string]interface{}) (interface{}, bool, error)
+
+func configMap(c *cli.Context, hasRange bool, fn configFunc) error {
+	flagRoot := c.GlobalString("IPTB_ROOT")
+	flagTestbed := c.GlobalString("testbed")
+	flagEncoding := c.GlobalString("encoding")
+
+	tb := testbed.NewTestbed(path.Join(flagRoot, "testbeds", flagTestbed))
+	nodes, err := tb.Nodes()
+	if err != nil {
+		return err
+	}
+
+	specs, err := tb.Specs()
+	if err != nil {
+		return err
+	}
+
+	nodeRange := fmt.Sprintf("[0-%d]", len(nodes)-1)
+	if hasRange {
+		nodeRange = c.Args().First()
+	}
+
+	list, err := parseNodes(nodeRange, specs)
+	if err != nil {
+		return err
+	}
+
+	runCmd := func(node testbedi.Core) (testbedi.Output, error) {
+		cfgNode, ok := node.(testbedi.Config)
+		if !ok {
+			return nil, fmt.Errorf("node does not implement config")
+		}
+
+		icfg, err := cfgNode.Config()
+		if err != nil {
+			return nil, err
+		}
+
+		cfg, err := iptbutil.ToMap(icfg)
+		if err != nil {
+			return nil, err
+		}
+
+		value, write, err := fn(cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		if write {
+			return nil, cfgNode.WriteConfig(cfg)
+		}
+
+		out, err := json.MarshalIndent(value, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+
+		return iptbutil.NewOutput(c.Args(), append(out, '\n'), nil, 0, nil), nil
+	}
+
+	results, err := mapWithOutput(list, nodes, runCmd)
+	if err != nil {
+		return err
+	}
+
+	return buildReport(results, flagEncoding)
+}
@@ -10,6 +10,7 @@ import (
 	cli "github.com/urfave/cli"
 
 	"github.com/ipfs/iptb/testbed"
+	"github.com/ipfs/iptb/testbed/interfaces"
 )
 
 var ConnectCmd = cli.Command{
@@ -39,6 +40,14 @@ INPUT         EXPANDED
 [0,2-4]       0,2,3,4
 [2-4,0]       2,3,4,0
 [0,2,4]       0,2,4
+
+By default each node dials the first address of the remote node using the
+transport configured for it. The --transport and --addr-filter options
+select which addresses are dialed instead; every matching address is tried
+in turn until one succeeds.
+
+$ iptb connect --transport quic 0 1
+$ iptb connect --transport any --addr-filter '^/ip6/' 0 1
 `,
 	Flags: []cli.Flag{
 		cli.StringFlag{
@@ -46,6 +55,14 @@ INPUT         EXPANDED
 			Usage: "timeout on the command",
 			Value: "30s",
 		},
+		cli.StringFlag{
+			Name:  "transport",
+			Usage: "only dial addresses using this transport (tcp, ws, quic, any)",
+		},
+		cli.StringFlag{
+			Name:  "addr-filter",
+			Usage: "only dial addresses matching this regular expression",
+		},
 	},
 	Action: func(c *cli.Context) error {
 		flagRoot := c.GlobalString("IPTB_ROOT")
@@ -53,12 +70,23 @@ INPUT         EXPANDED
 		flagEncoding := c.GlobalString("encoding")
 
 		flagTimeout := c.String("timeout")
+		flagTransport := c.String("transport")
+		flagAddrFilter := c.String("addr-filter")
+
+		opts := testbedi.ConnectOptions{
+			Transport:  flagTransport,
+			AddrFilter: flagAddrFilter,
+		}
 
 		timeout, err := time.ParseDuration(flagTimeout)
 		if err != nil {
 			return err
 		}
 		tb := testbed.NewTestbed(path.Join(flagRoot, "testbeds", flagTestbed))
+		specs, err := tb.Specs()
+		if err != nil {
+			return err
+		}
 
 		var results []Result
 		// Case range is specified
@@ -75,30 +103,30 @@ INPUT         EXPANDED
 				return err
 			}
 
-			results, err = connectNodes(tb, fromto, fromto, timeout)
+			results, err = connectNodes(tb, fromto, fromto, timeout, opts)
 			if err != nil {
 				return err
 			}
 		case 1:
-			fromto, err := parseRange(args[0])
+			fromto, err := parseNodes(args[0], specs)
 			if err != nil {
 				return err
 			}
-			results, err = connectNodes(tb, fromto, fromto, timeout)
+			results, err = connectNodes(tb, fromto, fromto, timeout, opts)
 			if err != nil {
 				return err
 			}
 		case 2:
-			from, err := parseRange(args[0])
+			from, err := parseNodes(args[0], specs)
 			if err != nil {
 				return err
 			}
 
-			to, err := parseRange(args[1])
+			to, err := parseNodes(args[1], specs)
 			if err != nil {
 				return err
 			}
-			results, err = connectNodes(tb, from, to, timeout)
+			results, err = connectNodes(tb, from, to, timeout, opts)
 			if err != nil {
 				return err
 			}
@@ -109,23 +137,36 @@ INPUT         EXPANDED
 	},
 }
 
-func connectNodes(tb testbed.BasicTestbed, from, to []int, timeout time.Duration) ([]Result, error) {
+func connectNodes(tb testbed.BasicTestbed, from, to []int, timeout time.Duration, opts testbedi.ConnectOptions) ([]Result, error) {
 	var results []Result
 	nodes, err := tb.Nodes()
 	if err != nil {
 		return results, err
 	}
+
+	selected := opts.Transport != "" || opts.AddrFilter != ""
+
 	for _, f := range from {
 		for _, t := range to {
 			ctx, cancel := context.WithTimeout(context.Background(), timeout)
 			defer cancel()
 
-			err = nodes[f].Connect(ctx, nodes[t])
+			var out testbedi.Output
+			start := time.Now()
+
+			if dialer, ok := nodes[f].(testbedi.Dialer); ok {
+				out, err = dialer.ConnectWith(ctx, nodes[t], opts)
+			} else if selected {
+				err = fmt.Errorf("node does not support selecting addresses")
+			} else {
+				err = nodes[f].Connect(ctx, nodes[t])
+			}
 
 			results = append(results, Result{
-				Node:   f,
-				Output: nil,
-				Error:  errors.Wrapf(err, "node[%d] => node[%d]", f, t),
+				Node:    f,
+				Output:  out,
+				Error:   errors.Wrapf(err, "node[%d] => node[%d]", f, t),
+				Elapsed: time.Since(start),
 			})
 		}
 	}
@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"io"
 	"path"
-	"strconv"
 
 	cli "github.com/urfave/cli"
 
@@ -25,12 +24,16 @@ var EventsCmd = cli.Command{
 			return NewUsageError("events takes exactly 1 argument")
 		}
 
-		i, err := strconv.Atoi(c.Args().First())
+		tb := testbed.NewTestbed(path.Join(flagRoot, "testbeds", flagTestbed))
+		specs, err := tb.Specs()
 		if err != nil {
-			return fmt.Errorf("parse err: %s", err)
+			return err
 		}
 
-		tb := testbed.NewTestbed(path.Join(flagRoot, "testbeds", flagTestbed))
+		i, err := parseNode(c.Args().First(), specs)
+		if err != nil {
+			return err
+		}
 
 		node, err := tb.Node(i)
 		if err != nil {
@@ -0,0 +1,164 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	cli "github.com/urfave/cli"
+
+	"github.com/ipfs/iptb/testbed"
+	"github.com/ipfs/iptb/testbed/interfaces"
+	"github.com/ipfs/iptb/util"
+)
+
+var faults = []string{
+	testbedi.FaultPause,
+	testbedi.FaultResume,
+	testbedi.FaultKill,
+	testbedi.FaultFreezeDisk,
+}
+
+var FaultCmd = cli.Command{
+	Category:  "CORE",
+	Name:      "fault",
+	Usage:     "inject a fault into specified nodes (or all)",
+	ArgsUsage: "<pause|resume|kill|freeze-disk> [nodes]",
+	Description: `
+Fault makes running nodes fail, to test how the rest of the network reacts
+to hung or crashed peers. The node does not get a chance to react:
+
+  pause        suspends the node
+  resume       resumes a paused node, and unfreezes its disk
+  kill         ends the node abruptly, start it again with iptb start
+  freeze-disk  blocks the reads and writes of the node to its disk
+
+The state of the nodes is reported by iptb status. Faults are only supported
+by nodes implementing them, see the documentation of the plugin.
+
+$ iptb fault pause [0-2]
+$ iptb status
+$ iptb fault resume [0-2]
+`,
+	Action: func(c *cli.Context) error {
+		flagRoot := c.GlobalString("IPTB_ROOT")
+		flagTestbed := c.GlobalString("testbed")
+		flagEncoding := c.GlobalString("encoding")
+
+		if !c.Args().Present() {
+			return NewUsageError("fault takes one of pause, resume, kill or freeze-disk")
+		}
+
+		fault := c.Args().First()
+		if !isFault(fault) {
+			return NewUsageError(fmt.Sprintf("unknown fault %s", fault))
+		}
+
+		tb := testbed.NewTestbed(path.Join(flagRoot, "testbeds", flagTestbed))
+		specs, err := tb.Specs()
+		if err != nil {
+			return err
+		}
+
+		nodeRange := c.Args().Get(1)
+
+		if nodeRange == "" {
+			nodeRange = fmt.Sprintf("[0-%d]", len(specs)-1)
+		}
+
+		list, err := parseNodes(nodeRange, specs)
+		if err != nil {
+			return err
+		}
+
+		nodes, err := tb.Select(context.Background(), list)
+		if err != nil {
+			return err
+		}
+
+		runCmd := func(node testbedi.Core) (testbedi.Output, error) {
+			fnode, ok := node.(testbedi.Faulter)
+			if !ok {
+				return nil, fmt.Errorf("node does not implement faults")
+			}
+
+			return nil, fnode.Fault(context.Background(), fault)
+		}
+
+		results, err := mapWithOutput(list, nodes, runCmd)
+		if err != nil {
+			return err
+		}
+
+		return buildReport(results, flagEncoding)
+	},
+}
+
+var StatusCmd = cli.Command{
+	Category:  "CORE",
+	Name:      "status",
+	Usage:     "show the state of specified nodes (or all)",
+	ArgsUsage: "[nodes]",
+	Description: `
+Status reports whether nodes are running, stopped, paused or have their disk
+frozen. Nodes which do not implement faults are reported as unknown.
+`,
+	Action: func(c *cli.Context) error {
+		flagRoot := c.GlobalString("IPTB_ROOT")
+		flagTestbed := c.GlobalString("testbed")
+		flagEncoding := c.GlobalString("encoding")
+
+		tb := testbed.NewTestbed(path.Join(flagRoot, "testbeds", flagTestbed))
+		specs, err := tb.Specs()
+		if err != nil {
+			return err
+		}
+
+		nodeRange := c.Args().First()
+
+		if nodeRange == "" {
+			nodeRange = fmt.Sprintf("[0-%d]", len(specs)-1)
+		}
+
+		list, err := parseNodes(nodeRange, specs)
+		if err != nil {
+			return err
+		}
+
+		nodes, err := tb.Select(context.Background(), list)
+		if err != nil {
+			return err
+		}
+
+		runCmd := func(node testbedi.Core) (testbedi.Output, error) {
+			status := "unknown"
+
+			if fnode, ok := node.(testbedi.Faulter); ok {
+				var err error
+				status, err = fnode.Status(context.Background())
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			return iptbutil.NewOutput(nil, []byte(status+"\n"), nil, 0, nil), nil
+		}
+
+		results, err := mapWithOutput(list, nodes, runCmd)
+		if err != nil {
+			return err
+		}
+
+		return buildReport(results, flagEncoding)
+	},
+}
+
+func isFault(fault string) bool {
+	for _, f := range faults {
+		if f == fault {
+			return true
+		}
+	}
+
+	return false
+}
@@ -35,7 +35,7 @@ var InitCmd = cli.Command{
 		flagEncoding := c.GlobalString("encoding")
 
 		tb := testbed.NewTestbed(path.Join(flagRoot, "testbeds", flagTestbed))
-		nodes, err := tb.Nodes()
+		specs, err := tb.Specs()
 		if err != nil {
 			return err
 		}
@@ -43,12 +43,23 @@ var InitCmd = cli.Command{
 		nodeRange, args := parseCommand(c.Args(), c.IsSet("terminator"))
 
 		if nodeRange == "" {
-			nodeRange = fmt.Sprintf("[0-%d]", len(nodes)-1)
+			nodeRange = fmt.Sprintf("[0-%d]", len(specs)-1)
 		}
 
-		list, err := parseRange(nodeRange)
+		list, err := parseNodes(nodeRange, specs)
 		if err != nil {
-			return fmt.Errorf("could not parse node range %s", nodeRange)
+			return err
+		}
+
+		for _, n := range list {
+			if err := specs[n].CheckPorts(); err != nil {
+				return fmt.Errorf("node[%d]: %s", n, err)
+			}
+		}
+
+		nodes, err := tb.Select(context.Background(), list)
+		if err != nil {
+			return err
 		}
 
 		runCmd := func(node testbedi.Core) (testbedi.Output, error) {
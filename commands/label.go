@@ -0,0 +1,190 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	cli "github.com/urfave/cli"
+
+	"github.com/ipfs/iptb/testbed"
+)
+
+var LabelCmd = cli.Command{
+	Category: "ATTRIBUTES",
+	Name:     "label",
+	Usage:    "set, unset, list labels used to select nodes",
+	Description: `
+Labels are saved in the node specs, and select nodes in place of a range
+in every command taking nodes:
+
+$ iptb label set [0-1] role=bootstrap
+$ iptb start role=bootstrap
+$ iptb run "all - role=bootstrap" -- ipfs bootstrap add ...
+
+The type label holds the type of each node and can not be set.
+`,
+	Subcommands: []cli.Command{
+		LabelSetCmd,
+		LabelUnsetCmd,
+		LabelListCmd,
+	},
+}
+
+var LabelSetCmd = cli.Command{
+	Name:      "set",
+	Usage:     "set labels on nodes",
+	ArgsUsage: "<nodes> <key=value>...",
+	Action: func(c *cli.Context) error {
+		if c.NArg() < 2 {
+			return NewUsageError("set takes nodes and at least one label")
+		}
+
+		labels := make(map[string]string)
+		for _, arg := range c.Args()[1:] {
+			parts := strings.SplitN(arg, "=", 2)
+			if len(parts) != 2 || parts[0] == "" {
+				return NewUsageError(fmt.Sprintf("could not parse label %s, expected key=value", arg))
+			}
+
+			labels[parts[0]] = parts[1]
+		}
+
+		return updateLabels(c, func(spec *testbed.NodeSpec) error {
+			for k, v := range labels {
+				if err := spec.SetLabel(k, v); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+	},
+}
+
+var LabelUnsetCmd = cli.Command{
+	Name:      "unset",
+	Usage:     "remove labels from nodes",
+	ArgsUsage: "<nodes> <key>...",
+	Action: func(c *cli.Context) error {
+		if c.NArg() < 2 {
+			return NewUsageError("unset takes nodes and at least one label")
+		}
+
+		keys := c.Args()[1:]
+
+		return updateLabels(c, func(spec *testbed.NodeSpec) error {
+			for _, k := range keys {
+				delete(spec.Labels, k)
+			}
+
+			return nil
+		})
+	},
+}
+
+var LabelListCmd = cli.Command{
+	Name:      "list",
+	Usage:     "list the labels of nodes (or all)",
+	ArgsUsage: "[nodes]",
+	Action: func(c *cli.Context) error {
+		flagRoot := c.GlobalString("IPTB_ROOT")
+		flagTestbed := c.GlobalString("testbed")
+		flagEncoding := c.GlobalString("encoding")
+
+		tb := testbed.NewTestbed(path.Join(flagRoot, "testbeds", flagTestbed))
+		specs, err := tb.Specs()
+		if err != nil {
+			return err
+		}
+
+		nodeRange := c.Args().First()
+
+		if nodeRange == "" {
+			nodeRange = fmt.Sprintf("[0-%d]", len(specs)-1)
+		}
+
+		list, err := parseNodes(nodeRange, specs)
+		if err != nil {
+			return err
+		}
+
+		if flagEncoding == "json" {
+			out := make(map[int]map[string]string)
+			for _, n := range list {
+				out[n] = nodeLabels(specs[n])
+			}
+
+			return json.NewEncoder(c.App.Writer).Encode(out)
+		}
+
+		for _, n := range list {
+			labels := nodeLabels(specs[n])
+
+			var keys []string
+			for k := range labels {
+				keys = append(keys, k)
+			}
+
+			sort.Strings(keys)
+
+			var pairs []string
+			for _, k := range keys {
+				pairs = append(pairs, k+"="+labels[k])
+			}
+
+			fmt.Fprintf(c.App.Writer, "%d\t%s\n", n, strings.Join(pairs, ","))
+		}
+
+		return nil
+	},
+}
+
+// nodeLabels returns the labels of a node, including its type
+func nodeLabels(spec *testbed.NodeSpec) map[string]string {
+	labels := map[string]string{testbed.LabelType: spec.Type}
+	for k, v := range spec.Labels {
+		labels[k] = v
+	}
+
+	return labels
+}
+
+// updateLabels applies fn to the specs of the nodes given as first argument,
+// and saves them
+func updateLabels(c *cli.Context, fn func(spec *testbed.NodeSpec) error) error {
+	flagRoot := c.GlobalString("IPTB_ROOT")
+	flagTestbed := c.GlobalString("testbed")
+
+	tb := testbed.NewTestbed(path.Join(flagRoot, "testbeds", flagTestbed))
+
+	// The specs are read after taking the lock, so they are up to date when
+	// written back
+	lock, err := tb.Lock(c.GlobalDuration("lock-wait"))
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	specs, err := tb.Specs()
+	if err != nil {
+		return err
+	}
+
+	nodeRange := c.Args().First()
+
+	list, err := parseNodes(nodeRange, specs)
+	if err != nil {
+		return err
+	}
+
+	for _, n := range list {
+		if err := fn(specs[n]); err != nil {
+			return fmt.Errorf("node[%d]: %s", n, err)
+		}
+	}
+
+	return testbed.WriteNodeSpecs(tb.Dir(), specs)
+}
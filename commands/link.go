@@ -0,0 +1,615 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+
+	cli "github.com/urfave/cli"
+
+	"github.com/ipfs/iptb/testbed"
+	"github.com/ipfs/iptb/testbed/interfaces"
+	"github.com/ipfs/iptb/util"
+)
+
+var LinkCmd = cli.Command{
+	Category: "ATTRIBUTES",
+	Name:     "link",
+	Usage:    "manage network conditions between pairs of nodes",
+	Description: `
+Link conditions shape the traffic between two nodes, unlike the latency,
+jitter, bandwidth and loss attributes which shape all the traffic of a node.
+They are applied by the receiving node, classifying traffic by source
+address. The latency, jitter, bandwidth and loss attributes of the receiving
+node shape the rest of its traffic, and fill in the conditions a link does
+not set; changing them shapes the links again. Nodes have to support shaping
+links and report their address through the ip attribute, as docker nodes
+and local nodes running in a network namespace do. Links from nodes
+listening on both ipv4 and ipv6, with the ip6 attribute, are not supported.
+
+Conditions are recorded in links.json in the testbed directory, and
+applied again when nodes are started with iptb.
+`,
+	Subcommands: []cli.Command{
+		LinkSetCmd,
+		LinkMatrixCmd,
+		LinkShowCmd,
+		LinkResetCmd,
+	},
+}
+
+var LinkSetCmd = cli.Command{
+	Name:      "set",
+	Usage:     "set the conditions of the links between nodes",
+	ArgsUsage: "<nodes> <nodes> <condition>=<value>...",
+	Description: `
+Sets conditions on the links between every node of the first range and
+every node of the second one, in both directions unless --directed is
+passed. Conditions are latency, jitter (durations), loss (percentage) and
+bandwidth (Mbps), a value of 0 removes the condition. Conditions which
+are not given are left as they are.
+
+$ iptb link set 0 1 latency=80ms loss=1
+$ iptb link set --directed [0-3] [4-7] bandwidth=10
+`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "directed",
+			Usage: "only shape the traffic from the first nodes to the second ones",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		flagRoot := c.GlobalString("IPTB_ROOT")
+		flagTestbed := c.GlobalString("testbed")
+		flagEncoding := c.GlobalString("encoding")
+		flagDirected := c.Bool("directed")
+
+		if c.NArg() < 3 {
+			return NewUsageError("set takes two node ranges and at least one condition")
+		}
+
+		tb := testbed.NewTestbed(path.Join(flagRoot, "testbeds", flagTestbed))
+		specs, err := tb.Specs()
+		if err != nil {
+			return err
+		}
+
+		from, err := parseNodes(c.Args()[0], specs)
+		if err != nil {
+			return err
+		}
+
+		to, err := parseNodes(c.Args()[1], specs)
+		if err != nil {
+			return err
+		}
+
+		conditions, err := parseConditions(c.Args()[2:])
+		if err != nil {
+			return err
+		}
+
+		lock, err := tb.Lock(c.GlobalDuration("lock-wait"))
+		if err != nil {
+			return err
+		}
+		defer lock.Unlock()
+
+		links, err := testbed.ReadLinks(tb.Dir())
+		if err != nil {
+			return err
+		}
+
+		var pairs [][2]int
+		for _, f := range from {
+			for _, t := range to {
+				if f == t {
+					continue
+				}
+
+				pairs = append(pairs, [2]int{f, t})
+				if !flagDirected {
+					pairs = append(pairs, [2]int{t, f})
+				}
+			}
+		}
+
+		for _, p := range pairs {
+			link := findLink(&links, p[0], p[1])
+			for _, kv := range conditions {
+				if err := link.Set(kv[0], kv[1]); err != nil {
+					return err
+				}
+			}
+		}
+
+		return applyLinks(tb, links, linkTargets(pairs), flagEncoding)
+	},
+}
+
+var LinkMatrixCmd = cli.Command{
+	Name:      "matrix",
+	Usage:     "set the conditions of all links from a region matrix",
+	ArgsUsage: "<file>",
+	Description: `
+Assigns nodes to regions and sets the conditions of the links between
+them from a table indexed by region. A condition value without a name is
+a latency. The table is symmetric: the conditions from eu to us are used
+from us to eu when the latter are not given. Links which are not covered
+by the matrix are reset.
+
+{
+  "regions": {"us": "[0-3]", "eu": "[4-7]", "ap": "[8-9]"},
+  "links": {
+    "us": {"us": "5ms", "eu": "80ms", "ap": "latency=150ms loss=1"},
+    "eu": {"eu": "5ms", "ap": "200ms"},
+    "ap": {"ap": "5ms"}
+  }
+}
+
+$ iptb link matrix regions.json
+`,
+	Action: func(c *cli.Context) error {
+		flagRoot := c.GlobalString("IPTB_ROOT")
+		flagTestbed := c.GlobalString("testbed")
+		flagEncoding := c.GlobalString("encoding")
+
+		if c.NArg() != 1 {
+			return NewUsageError("matrix takes exactly 1 argument")
+		}
+
+		data, err := ioutil.ReadFile(c.Args().First())
+		if err != nil {
+			return err
+		}
+
+		var matrix linkMatrix
+		if err := json.Unmarshal(data, &matrix); err != nil {
+			return fmt.Errorf("could not parse matrix %s: %s", c.Args().First(), err)
+		}
+
+		tb := testbed.NewTestbed(path.Join(flagRoot, "testbeds", flagTestbed))
+		specs, err := tb.Specs()
+		if err != nil {
+			return err
+		}
+
+		links, err := matrix.links(specs)
+		if err != nil {
+			return err
+		}
+
+		lock, err := tb.Lock(c.GlobalDuration("lock-wait"))
+		if err != nil {
+			return err
+		}
+		defer lock.Unlock()
+
+		// Nodes with links set before are reapplied too, clearing them
+		old, err := testbed.ReadLinks(tb.Dir())
+		if err != nil {
+			return err
+		}
+
+		var pairs [][2]int
+		for _, l := range append(old, links...) {
+			pairs = append(pairs, [2]int{l.From, l.To})
+		}
+
+		return applyLinks(tb, links, linkTargets(pairs), flagEncoding)
+	},
+}
+
+var LinkShowCmd = cli.Command{
+	Name:      "show",
+	Usage:     "show the conditions of the links of nodes (or all)",
+	ArgsUsage: "[nodes]",
+	Action: func(c *cli.Context) error {
+		flagRoot := c.GlobalString("IPTB_ROOT")
+		flagTestbed := c.GlobalString("testbed")
+		flagEncoding := c.GlobalString("encoding")
+
+		tb := testbed.NewTestbed(path.Join(flagRoot, "testbeds", flagTestbed))
+
+		links, err := testbed.ReadLinks(tb.Dir())
+		if err != nil {
+			return err
+		}
+
+		if c.Args().Present() {
+			specs, err := tb.Specs()
+			if err != nil {
+				return err
+			}
+
+			list, err := parseNodes(c.Args().First(), specs)
+			if err != nil {
+				return err
+			}
+
+			links = filterLinks(links, list, true)
+		}
+
+		if flagEncoding == "json" {
+			if links == nil {
+				links = []testbed.Link{}
+			}
+
+			enc := json.NewEncoder(c.App.Writer)
+			enc.SetIndent("", "  ")
+			return enc.Encode(links)
+		}
+
+		for _, l := range links {
+			if _, err := fmt.Fprintf(c.App.Writer, "%d -> %d %s\n", l.From, l.To, l.LinkSettings); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	},
+}
+
+var LinkResetCmd = cli.Command{
+	Name:      "reset",
+	Usage:     "remove the conditions of the links of nodes (or all)",
+	ArgsUsage: "[nodes]",
+	Action: func(c *cli.Context) error {
+		flagRoot := c.GlobalString("IPTB_ROOT")
+		flagTestbed := c.GlobalString("testbed")
+		flagEncoding := c.GlobalString("encoding")
+
+		tb := testbed.NewTestbed(path.Join(flagRoot, "testbeds", flagTestbed))
+
+		lock, err := tb.Lock(c.GlobalDuration("lock-wait"))
+		if err != nil {
+			return err
+		}
+		defer lock.Unlock()
+
+		links, err := testbed.ReadLinks(tb.Dir())
+		if err != nil {
+			return err
+		}
+
+		var kept []testbed.Link
+		var pairs [][2]int
+
+		if c.Args().Present() {
+			specs, err := tb.Specs()
+			if err != nil {
+				return err
+			}
+
+			list, err := parseNodes(c.Args().First(), specs)
+			if err != nil {
+				return err
+			}
+
+			kept = filterLinks(links, list, false)
+			links = filterLinks(links, list, true)
+		}
+
+		for _, l := range links {
+			pairs = append(pairs, [2]int{l.From, l.To})
+		}
+
+		return applyLinks(tb, kept, linkTargets(pairs), flagEncoding)
+	},
+}
+
+// parseConditions parses `name=value` pairs, a value without a name is a latency
+func parseConditions(args []string) ([][2]string, error) {
+	var out [][2]string
+	for _, arg := range args {
+		for _, f := range strings.Fields(arg) {
+			parts := strings.SplitN(f, "=", 2)
+			if len(parts) == 1 {
+				parts = []string{"latency", parts[0]}
+			}
+
+			var ls testbedi.LinkSettings
+			if err := ls.Set(parts[0], parts[1]); err != nil {
+				return nil, NewUsageError(err.Error())
+			}
+
+			out = append(out, [2]string{parts[0], parts[1]})
+		}
+	}
+
+	return out, nil
+}
+
+// findLink returns the link from f to t, adding it to links if needed
+func findLink(links *[]testbed.Link, f, t int) *testbed.Link {
+	for i := range *links {
+		if (*links)[i].From == f && (*links)[i].To == t {
+			return &(*links)[i]
+		}
+	}
+
+	*links = append(*links, testbed.Link{From: f, To: t})
+	return &(*links)[len(*links)-1]
+}
+
+// filterLinks returns the links with (or without, if match is false) an end
+// in list
+func filterLinks(links []testbed.Link, list []int, match bool) []testbed.Link {
+	in := make(map[int]bool)
+	for _, n := range list {
+		in[n] = true
+	}
+
+	var out []testbed.Link
+	for _, l := range links {
+		if (in[l.From] || in[l.To]) == match {
+			out = append(out, l)
+		}
+	}
+
+	return out
+}
+
+// linkTargets returns the receiving nodes of the pairs, which are the
+// nodes whose interface is shaped
+func linkTargets(pairs [][2]int) []int {
+	seen := make(map[int]bool)
+	var out []int
+	for _, p := range pairs {
+		if !seen[p[1]] {
+			seen[p[1]] = true
+			out = append(out, p[1])
+		}
+	}
+
+	sort.Ints(out)
+	return out
+}
+
+type linkMatrix struct {
+	Regions map[string]string            `json:"regions"`
+	Links   map[string]map[string]string `json:"links"`
+}
+
+// links expands the matrix into links between every pair of nodes
+func (m *linkMatrix) links(specs []*testbed.NodeSpec) ([]testbed.Link, error) {
+	regions := make(map[string][]int)
+	for name, rng := range m.Regions {
+		list, err := parseNodes(rng, specs)
+		if err != nil {
+			return nil, fmt.Errorf("region %s: %s", name, err)
+		}
+
+		regions[name] = list
+	}
+
+	for a, row := range m.Links {
+		for b := range row {
+			for _, r := range []string{a, b} {
+				if _, ok := regions[r]; !ok {
+					return nil, fmt.Errorf("unknown region %s in links", r)
+				}
+			}
+		}
+	}
+
+	var links []testbed.Link
+	for a, from := range regions {
+		for b, to := range regions {
+			cond, ok := m.Links[a][b]
+			if !ok {
+				cond, ok = m.Links[b][a]
+			}
+
+			if !ok {
+				continue
+			}
+
+			conditions, err := parseConditions([]string{cond})
+			if err != nil {
+				return nil, fmt.Errorf("links %s to %s: %s", a, b, err)
+			}
+
+			var ls testbedi.LinkSettings
+			for _, kv := range conditions {
+				ls.Set(kv[0], kv[1])
+			}
+
+			for _, f := range from {
+				for _, t := range to {
+					if f != t {
+						links = append(links, testbed.Link{From: f, To: t, LinkSettings: ls})
+					}
+				}
+			}
+		}
+	}
+
+	return links, nil
+}
+
+// applyLinks shapes each target node according to the links it receives
+// traffic from, and records the links once applied
+func applyLinks(tb testbed.BasicTestbed, links []testbed.Link, targets []int, encoding string) error {
+	specs, err := tb.Specs()
+	if err != nil {
+		return err
+	}
+
+	for _, l := range links {
+		if err := validRange([]int{l.From, l.To}, len(specs)); err != nil {
+			return err
+		}
+
+		// Traffic is classified by the one address reported by the ip
+		// attribute, the other family would go unshaped
+		if _, ok := specs[l.From].Attrs["ip6"]; ok && !l.IsZero() {
+			return fmt.Errorf("node[%d] listens on both ipv4 and ipv6, links from it can not be shaped", l.From)
+		}
+	}
+
+	if err := validRange(targets, len(specs)); err != nil {
+		return err
+	}
+
+	old, err := testbed.ReadLinks(tb.Dir())
+	if err != nil {
+		return err
+	}
+
+	results, err := shapeLinks(tb, links, targets)
+	if err != nil {
+		return err
+	}
+
+	if err := testbed.WriteLinks(tb.Dir(), shapedLinks(old, links, results)); err != nil {
+		return err
+	}
+
+	return buildReport(results, encoding)
+}
+
+// shapedLinks returns the links in effect once the targets of results were
+// shaped with links: nodes which failed to be shaped keep their old links
+func shapedLinks(old, links []testbed.Link, results []Result) []testbed.Link {
+	failed := make(map[int]bool)
+	for _, rs := range results {
+		if rs.Error != nil {
+			failed[rs.Node] = true
+		}
+	}
+
+	var out []testbed.Link
+	for _, l := range old {
+		if failed[l.To] {
+			out = append(out, l)
+		}
+	}
+
+	for _, l := range links {
+		if !failed[l.To] {
+			out = append(out, l)
+		}
+	}
+
+	return out
+}
+
+// restoreLinks shapes again the links of the nodes of list once they
+// started: their interface is new, and so may be their address. Nodes
+// receiving traffic from them are only shaped again if they are running.
+func restoreLinks(tb testbed.BasicTestbed, list []int) error {
+	links, err := testbed.ReadLinks(tb.Dir())
+	if err != nil || len(links) == 0 {
+		return err
+	}
+
+	nodes, err := tb.Nodes()
+	if err != nil {
+		return err
+	}
+
+	started := make(map[int]bool)
+	for _, n := range list {
+		started[n] = true
+	}
+
+	var pairs [][2]int
+	for _, l := range filterLinks(links, list, true) {
+		if !started[l.To] {
+			if f, ok := nodes[l.To].(testbedi.Faulter); ok {
+				status, err := f.Status(context.Background())
+				if err != nil || status != testbedi.StatusRunning {
+					continue
+				}
+			}
+		}
+
+		pairs = append(pairs, [2]int{l.From, l.To})
+	}
+
+	results, err := shapeLinks(tb, links, linkTargets(pairs))
+	if err != nil {
+		return err
+	}
+
+	for _, rs := range results {
+		if rs.Error != nil {
+			return fmt.Errorf("restoring links: %s", rs.Error)
+		}
+	}
+
+	return nil
+}
+
+// succeeded returns the nodes of the results without an error
+func succeeded(results []Result) []int {
+	var out []int
+	for _, rs := range results {
+		if rs.Error == nil {
+			out = append(out, rs.Node)
+		}
+	}
+
+	return out
+}
+
+// shapeLinks shapes the traffic each target node receives according to the
+// links it receives traffic from, on top of its own network emulation
+// settings
+func shapeLinks(tb testbed.BasicTestbed, links []testbed.Link, targets []int) ([]Result, error) {
+	nodes, err := tb.Nodes()
+	if err != nil {
+		return nil, err
+	}
+
+	runCmd := func(t int, node testbedi.Core) (testbedi.Output, error) {
+		shaper, ok := node.(testbedi.LinkShaper)
+		if !ok {
+			return nil, fmt.Errorf("node does not support shaping links")
+		}
+
+		var peers []testbedi.LinkPeer
+		var report bytes.Buffer
+		for _, l := range links {
+			if l.To != t || l.IsZero() {
+				continue
+			}
+
+			ip, err := linkAttr(nodes[l.From], "ip")
+			if err != nil {
+				return nil, fmt.Errorf("node[%d]: %s", l.From, err)
+			}
+
+			peers = append(peers, testbedi.LinkPeer{IP: ip, LinkSettings: l.LinkSettings})
+			fmt.Fprintf(&report, "%d -> %d %s\n", l.From, l.To, l.LinkSettings)
+		}
+
+		if err := shaper.ShapeLinks(context.Background(), peers); err != nil {
+			return nil, err
+		}
+
+		return iptbutil.NewOutput(nil, report.Bytes(), nil, 0, nil), nil
+	}
+
+	return mapWithIndex(targets, nodes, runCmd)
+}
+
+func linkAttr(node testbedi.Core, attr string) (string, error) {
+	attrNode, ok := node.(testbedi.Attribute)
+	if !ok {
+		return "", fmt.Errorf("node does not implement attributes")
+	}
+
+	val, err := attrNode.Attr(attr)
+	if err != nil {
+		return "", fmt.Errorf("could not get %s: %s", attr, err)
+	}
+
+	return val, nil
+}
@@ -0,0 +1,82 @@
+package commands
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ipfs/iptb/testbed"
+	"github.com/ipfs/iptb/testbed/interfaces"
+)
+
+func TestLinkMatrix(t *testing.T) {
+	m := linkMatrix{
+		Regions: map[string]string{"us": "[0-1]", "eu": "2"},
+		Links: map[string]map[string]string{
+			"us": {"us": "5ms", "eu": "latency=80ms loss=1"},
+		},
+	}
+
+	specs := make([]*testbed.NodeSpec, 3)
+	for i := range specs {
+		specs[i] = &testbed.NodeSpec{}
+	}
+
+	links, err := m.links(specs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[[2]int]testbedi.LinkSettings)
+	for _, l := range links {
+		got[[2]int{l.From, l.To}] = l.LinkSettings
+	}
+
+	intra := testbedi.LinkSettings{Latency: 5 * time.Millisecond}
+	inter := testbedi.LinkSettings{Latency: 80 * time.Millisecond, Loss: 1}
+
+	expected := map[[2]int]testbedi.LinkSettings{
+		{0, 1}: intra,
+		{1, 0}: intra,
+		{0, 2}: inter,
+		{1, 2}: inter,
+		{2, 0}: inter,
+		{2, 1}: inter,
+	}
+
+	expect(t, len(got), len(expected))
+	for pair, ls := range expected {
+		expect(t, got[pair], ls)
+	}
+
+	m.Links["ap"] = map[string]string{"us": "1ms"}
+	if _, err := m.links(specs); err == nil {
+		t.Fatal("expected an error for an unknown region")
+	}
+}
+
+func TestShapedLinks(t *testing.T) {
+	slow := testbedi.LinkSettings{Latency: time.Second}
+	fast := testbedi.LinkSettings{Latency: time.Millisecond}
+
+	old := []testbed.Link{
+		{From: 0, To: 1, LinkSettings: slow},
+		{From: 0, To: 2, LinkSettings: slow},
+	}
+
+	links := []testbed.Link{
+		{From: 0, To: 1, LinkSettings: fast},
+		{From: 0, To: 2, LinkSettings: fast},
+	}
+
+	results := []Result{
+		{Node: 1},
+		{Node: 2, Error: fmt.Errorf("tc failed")},
+	}
+
+	// Node 2 keeps the links it was shaped with
+	got := shapedLinks(old, links, results)
+	expect(t, len(got), 2)
+	expect(t, got[0], old[1])
+	expect(t, got[1], links[0])
+}
@@ -1,6 +1,7 @@
 package commands
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -36,7 +37,7 @@ var LogsCmd = cli.Command{
 		flagOut := c.BoolT("out")
 
 		tb := testbed.NewTestbed(path.Join(flagRoot, "testbeds", flagTestbed))
-		nodes, err := tb.Nodes()
+		specs, err := tb.Specs()
 		if err != nil {
 			return err
 		}
@@ -44,10 +45,15 @@ var LogsCmd = cli.Command{
 		nodeRange := c.Args().First()
 
 		if nodeRange == "" {
-			nodeRange = fmt.Sprintf("[0-%d]", len(nodes)-1)
+			nodeRange = fmt.Sprintf("[0-%d]", len(specs)-1)
 		}
 
-		list, err := parseRange(nodeRange)
+		list, err := parseNodes(nodeRange, specs)
+		if err != nil {
+			return err
+		}
+
+		nodes, err := tb.Select(context.Background(), list)
 		if err != nil {
 			return err
 		}
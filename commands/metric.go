@@ -1,9 +1,9 @@
 package commands
 
 import (
+	"context"
 	"fmt"
 	"path"
-	"strconv"
 
 	cli "github.com/urfave/cli"
 
@@ -14,8 +14,13 @@ import (
 var MetricCmd = cli.Command{
 	Category:  "METRICS",
 	Name:      "metric",
-	Usage:     "get metric from node",
-	ArgsUsage: "<node> [metric]",
+	Usage:     "get metric from specified nodes, or list the metrics of a node",
+	ArgsUsage: "<nodes> [metric]",
+	Description: `
+Without a metric, lists the metrics available for a node. The value of a
+single node given by index is printed as it is, unless the encoding is json.
+Other nodes are reported like the output of run.
+`,
 	Action: func(c *cli.Context) error {
 		if c.NArg() == 1 {
 			return metricList(c)
@@ -33,12 +38,16 @@ func metricList(c *cli.Context) error {
 	flagRoot := c.GlobalString("IPTB_ROOT")
 	flagTestbed := c.GlobalString("testbed")
 
-	i, err := strconv.Atoi(c.Args().First())
+	tb := testbed.NewTestbed(path.Join(flagRoot, "testbeds", flagTestbed))
+	specs, err := tb.Specs()
 	if err != nil {
-		return fmt.Errorf("parse err: %s", err)
+		return err
 	}
 
-	tb := testbed.NewTestbed(path.Join(flagRoot, "testbeds", flagTestbed))
+	i, err := parseNode(c.Args().First(), specs)
+	if err != nil {
+		return err
+	}
 
 	node, err := tb.Node(i)
 	if err != nil {
@@ -66,33 +75,35 @@ func metricList(c *cli.Context) error {
 func metricGet(c *cli.Context) error {
 	flagRoot := c.GlobalString("IPTB_ROOT")
 	flagTestbed := c.GlobalString("testbed")
+	flagEncoding := c.GlobalString("encoding")
 
-	argNode := c.Args()[0]
+	argNodes := c.Args()[0]
 	argMetric := c.Args()[1]
 
-	i, err := strconv.Atoi(argNode)
-	if err != nil {
-		return fmt.Errorf("parse err: %s", err)
-	}
-
 	tb := testbed.NewTestbed(path.Join(flagRoot, "testbeds", flagTestbed))
-
-	node, err := tb.Node(i)
+	specs, err := tb.Specs()
 	if err != nil {
 		return err
 	}
 
-	metricNode, ok := node.(testbedi.Metric)
-	if !ok {
-		return fmt.Errorf("node does not implement metrics")
+	list, err := parseNodes(argNodes, specs)
+	if err != nil {
+		return err
 	}
 
-	value, err := metricNode.Metric(argMetric)
+	nodes, err := tb.Select(context.Background(), list)
 	if err != nil {
 		return err
 	}
 
-	_, err = fmt.Fprintf(c.App.Writer, "%s\n", value)
+	get := func(node testbedi.Core) (string, error) {
+		metricNode, ok := node.(testbedi.Metric)
+		if !ok {
+			return "", fmt.Errorf("node does not implement metrics")
+		}
+
+		return metricNode.Metric(argMetric)
+	}
 
-	return err
+	return reportValues(c, argNodes, list, nodes, flagEncoding, get)
 }
@@ -40,7 +40,7 @@ var RestartCmd = cli.Command{
 		flagWait := c.Bool("wait")
 
 		tb := testbed.NewTestbed(path.Join(flagRoot, "testbeds", flagTestbed))
-		nodes, err := tb.Nodes()
+		specs, err := tb.Specs()
 		if err != nil {
 			return err
 		}
@@ -48,12 +48,17 @@ var RestartCmd = cli.Command{
 		nodeRange, args := parseCommand(c.Args(), c.IsSet("terminator"))
 
 		if nodeRange == "" {
-			nodeRange = fmt.Sprintf("[0-%d]", len(nodes)-1)
+			nodeRange = fmt.Sprintf("[0-%d]", len(specs)-1)
 		}
 
-		list, err := parseRange(nodeRange)
+		list, err := parseNodes(nodeRange, specs)
 		if err != nil {
-			return fmt.Errorf("could not parse node range %s", nodeRange)
+			return err
+		}
+
+		nodes, err := tb.Select(context.Background(), list)
+		if err != nil {
+			return err
 		}
 
 		runCmd := func(node testbedi.Core) (testbedi.Output, error) {
@@ -69,6 +74,12 @@ var RestartCmd = cli.Command{
 			return err
 		}
 
-		return buildReport(results, flagEncoding)
+		reportErr := buildReport(results, flagEncoding)
+
+		if err := restoreLinks(tb, succeeded(results)); err != nil {
+			return err
+		}
+
+		return reportErr
 	},
 }
@@ -1,9 +1,11 @@
 package commands
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"path"
+	"text/template"
 
 	cli "github.com/urfave/cli"
 
@@ -16,7 +18,20 @@ var RunCmd = cli.Command{
 	Name:      "run",
 	Usage:     "run command on specified nodes (or all)",
 	ArgsUsage: "[nodes] -- <command...>",
+	Description: `
+The run command executes a command in the context of each node.
+
+With --template, arguments are expanded as go templates before running.
+The cid function returns the content recorded by 'iptb seed', where
+{{cid 0 2}} is the third file added to node 0.
+
+$ iptb run --template [1-4] -- ipfs cat '{{cid 0 0}}'
+`,
 	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "template",
+			Usage: "expand templates in the command arguments",
+		},
 		cli.BoolFlag{
 			Name:   "terminator",
 			Hidden: true,
@@ -32,9 +47,10 @@ var RunCmd = cli.Command{
 		flagRoot := c.GlobalString("IPTB_ROOT")
 		flagTestbed := c.GlobalString("testbed")
 		flagEncoding := c.GlobalString("encoding")
+		flagTemplate := c.Bool("template")
 
 		tb := testbed.NewTestbed(path.Join(flagRoot, "testbeds", flagTestbed))
-		nodes, err := tb.Nodes()
+		specs, err := tb.Specs()
 		if err != nil {
 			return err
 		}
@@ -42,12 +58,29 @@ var RunCmd = cli.Command{
 		nodeRange, args := parseCommand(c.Args(), c.IsSet("terminator"))
 
 		if nodeRange == "" {
-			nodeRange = fmt.Sprintf("[0-%d]", len(nodes)-1)
+			nodeRange = fmt.Sprintf("[0-%d]", len(specs)-1)
+		}
+
+		list, err := parseNodes(nodeRange, specs)
+		if err != nil {
+			return err
+		}
+
+		if flagTemplate {
+			seeds, err := testbed.ReadSeeds(tb.Dir())
+			if err != nil {
+				return err
+			}
+
+			args, err = expandArgs(args, seeds)
+			if err != nil {
+				return err
+			}
 		}
 
-		list, err := parseRange(nodeRange)
+		nodes, err := tb.Select(context.Background(), list)
 		if err != nil {
-			return fmt.Errorf("could not parse node range %s", nodeRange)
+			return err
 		}
 
 		runCmd := func(node testbedi.Core) (testbedi.Output, error) {
@@ -62,3 +95,34 @@ var RunCmd = cli.Command{
 		return buildReport(results, flagEncoding)
 	},
 }
+
+// expandArgs executes each argument as a template, providing access to the
+// content recorded by the seed command
+func expandArgs(args []string, seeds map[int][]testbed.Seed) ([]string, error) {
+	funcs := template.FuncMap{
+		"cid": func(node, file int) (string, error) {
+			if file < 0 || file >= len(seeds[node]) {
+				return "", fmt.Errorf("no seeded file %d for node %d", file, node)
+			}
+
+			return seeds[node][file].Cid, nil
+		},
+	}
+
+	var out []string
+	for _, arg := range args {
+		tmpl, err := template.New("arg").Funcs(funcs).Parse(arg)
+		if err != nil {
+			return nil, err
+		}
+
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, nil); err != nil {
+			return nil, err
+		}
+
+		out = append(out, buf.String())
+	}
+
+	return out, nil
+}
@@ -0,0 +1,217 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"path"
+	"strings"
+	"sync"
+
+	cli "github.com/urfave/cli"
+
+	"github.com/ipfs/iptb/testbed"
+	"github.com/ipfs/iptb/testbed/interfaces"
+	"github.com/ipfs/iptb/util"
+)
+
+var SeedCmd = cli.Command{
+	Category:  "CORE",
+	Name:      "seed",
+	Usage:     "add generated content to specified nodes (or all)",
+	ArgsUsage: "[nodes]",
+	Description: `
+The seed command generates deterministic random content and adds it
+through each node. Every file is generated from the seed and its index,
+so seeding with the same options always produces the same CIDs. Use
+--unique to also mix in the node index and give every node different
+content.
+
+The resulting CIDs are printed and recorded in seeds.json in the testbed
+directory, where they can be referenced by 'iptb run --template'.
+
+$ iptb seed 0 --size 100MB --files 10 --seed 42
+$ iptb run --template 1 -- ipfs cat '{{cid 0 0}}'
+`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "size",
+			Usage: "size of each file (e.g. 512KB, 100MB)",
+			Value: "1MB",
+		},
+		cli.IntFlag{
+			Name:  "files",
+			Usage: "number of files to add to each node",
+			Value: 1,
+		},
+		cli.Int64Flag{
+			Name:  "seed",
+			Usage: "seed used to generate the content",
+		},
+		cli.StringFlag{
+			Name:  "chunker",
+			Usage: "chunking algorithm passed to ipfs add",
+		},
+		cli.BoolFlag{
+			Name:  "unique",
+			Usage: "generate different content for every node",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		flagRoot := c.GlobalString("IPTB_ROOT")
+		flagTestbed := c.GlobalString("testbed")
+		flagEncoding := c.GlobalString("encoding")
+		flagFiles := c.Int("files")
+		flagSeed := c.Int64("seed")
+		flagChunker := c.String("chunker")
+		flagUnique := c.Bool("unique")
+
+		size, err := iptbutil.ParseBytes(c.String("size"))
+		if err != nil {
+			return err
+		}
+
+		if flagFiles < 1 {
+			return NewUsageError("--files must be at least 1")
+		}
+
+		tb := testbed.NewTestbed(path.Join(flagRoot, "testbeds", flagTestbed))
+		nodes, err := tb.Nodes()
+		if err != nil {
+			return err
+		}
+
+		specs, err := tb.Specs()
+		if err != nil {
+			return err
+		}
+
+		nodeRange := c.Args().First()
+
+		if nodeRange == "" {
+			nodeRange = fmt.Sprintf("[0-%d]", len(nodes)-1)
+		}
+
+		list, err := parseNodes(nodeRange, specs)
+		if err != nil {
+			return err
+		}
+
+		var lk sync.Mutex
+		added := make(map[int][]testbed.Seed)
+
+		runCmd := func(n int, node testbedi.Core) (testbedi.Output, error) {
+			opts := seedOptions{
+				Size:    size,
+				Files:   flagFiles,
+				Seed:    flagSeed,
+				Chunker: flagChunker,
+			}
+
+			if flagUnique {
+				opts.Seed = flagSeed ^ int64(n)<<32
+			}
+
+			nodeSeeds, err := seedContent(context.Background(), node, opts)
+
+			lk.Lock()
+			added[n] = nodeSeeds
+			lk.Unlock()
+
+			if err != nil {
+				return nil, err
+			}
+
+			var stdout bytes.Buffer
+			for _, s := range nodeSeeds {
+				fmt.Fprintf(&stdout, "%s %d\n", s.Cid, s.Size)
+			}
+
+			return iptbutil.NewOutput(nil, stdout.Bytes(), nil, 0, nil), nil
+		}
+
+		results, err := mapWithIndex(list, nodes, runCmd)
+		if err != nil {
+			return err
+		}
+
+		// The seeds are read after taking the lock, so concurrent seeds of the
+		// testbed are all recorded
+		lock, err := tb.Lock(c.GlobalDuration("lock-wait"))
+		if err != nil {
+			return err
+		}
+		defer lock.Unlock()
+
+		seeds, err := testbed.ReadSeeds(tb.Dir())
+		if err != nil {
+			return err
+		}
+
+		for n, nodeSeeds := range added {
+			seeds[n] = append(seeds[n], nodeSeeds...)
+		}
+
+		if err := testbed.WriteSeeds(tb.Dir(), seeds); err != nil {
+			return err
+		}
+
+		return buildReport(results, flagEncoding)
+	},
+}
+
+type seedOptions struct {
+	Size    int64
+	Files   int
+	Seed    int64
+	Chunker string
+}
+
+// seedContent generates opts.Files files of opts.Size bytes and adds them
+// through the node. File i is generated from the seed opts.Seed + i.
+func seedContent(ctx context.Context, node testbedi.Core, opts seedOptions) ([]testbed.Seed, error) {
+	var added []testbed.Seed
+
+	for i := 0; i < opts.Files; i++ {
+		seed := opts.Seed + int64(i)
+
+		args := []string{"ipfs", "add", "-q"}
+		if opts.Chunker != "" {
+			args = append(args, "--chunker="+opts.Chunker)
+		}
+
+		data := io.LimitReader(rand.New(rand.NewSource(seed)), opts.Size)
+
+		out, err := node.RunCmd(ctx, data, args...)
+		if err != nil {
+			return added, err
+		}
+
+		stdout, err := ioutil.ReadAll(out.Stdout())
+		if err != nil {
+			return added, err
+		}
+
+		if out.ExitCode() != 0 {
+			stderr, _ := ioutil.ReadAll(out.Stderr())
+			return added, fmt.Errorf("ipfs add failed: %s", strings.TrimSpace(string(stderr)))
+		}
+
+		lines := strings.Fields(string(stdout))
+		if len(lines) == 0 {
+			return added, fmt.Errorf("ipfs add did not return a cid")
+		}
+
+		added = append(added, testbed.Seed{
+			Cid:     lines[len(lines)-1],
+			Size:    opts.Size,
+			Seed:    seed,
+			Chunker: opts.Chunker,
+		})
+	}
+
+	return added, nil
+}
@@ -0,0 +1,299 @@
+package commands
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/ipfs/iptb/testbed"
+)
+
+// parseNodes parses the nodes argument of a command against the specs of the
+// testbed. It is an index or a list of them as understood by parseIndexes, or
+// a selector:
+//
+//	all                     every node
+//	random(10), random(10,42)
+//	                        10 nodes drawn at random, with an optional seed
+//	role=bootstrap          nodes with a label set to a value
+//	role!=bootstrap         nodes without a label set to a value
+//	role                    nodes with a label set
+//	role=client,region=eu   nodes matching every requirement
+//	!role=bootstrap         nodes not matching
+//
+// The type label holds the type of each node. Selectors are combined left to
+// right by the set operations + (union), - (difference) and & (intersection),
+// separated by spaces: "all - [0-3]".
+//
+// A single index list keeps its order, combined selectors are in node order.
+// No node is returned twice, and malformed input is a UsageError.
+func parseNodes(s string, specs []*testbed.NodeSpec) ([]int, error) {
+	tokens, err := splitSelector(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tokens) == 0 {
+		return nil, NewUsageError("no nodes given")
+	}
+
+	list, err := evalSelector(tokens[0], specs)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tokens) == 1 {
+		return list, nil
+	}
+
+	set := make([]bool, len(specs))
+	for _, n := range list {
+		set[n] = true
+	}
+
+	for i := 1; i < len(tokens); i += 2 {
+		op := tokens[i]
+		if op != "+" && op != "-" && op != "&" {
+			return nil, NewUsageError(fmt.Sprintf("unknown operator %s in %s, expected +, - or &", op, s))
+		}
+
+		if i+1 == len(tokens) {
+			return nil, NewUsageError(fmt.Sprintf("operator %s is missing a selector in %s", op, s))
+		}
+
+		rhs, err := evalSelector(tokens[i+1], specs)
+		if err != nil {
+			return nil, err
+		}
+
+		other := make([]bool, len(specs))
+		for _, n := range rhs {
+			other[n] = true
+		}
+
+		for n := range set {
+			switch op {
+			case "+":
+				set[n] = set[n] || other[n]
+			case "-":
+				set[n] = set[n] && !other[n]
+			case "&":
+				set[n] = set[n] && other[n]
+			}
+		}
+	}
+
+	var out []int
+	for n, ok := range set {
+		if ok {
+			out = append(out, n)
+		}
+	}
+
+	return out, nil
+}
+
+// parseNode parses the node argument of a command taking a single node, with
+// the syntax of parseNodes
+func parseNode(s string, specs []*testbed.NodeSpec) (int, error) {
+	list, err := parseNodes(s, specs)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(list) != 1 {
+		return 0, NewUsageError(fmt.Sprintf("%s selects %d nodes, expected exactly one", s, len(list)))
+	}
+
+	return list[0], nil
+}
+
+// splitSelector splits s on the spaces outside of brackets and parentheses
+func splitSelector(s string) ([]string, error) {
+	var tokens []string
+	var cur strings.Builder
+	depth := 0
+
+	for _, r := range s {
+		switch {
+		case r == '[' || r == '(':
+			depth++
+		case r == ']' || r == ')':
+			depth--
+			if depth < 0 {
+				return nil, NewUsageError(fmt.Sprintf("unbalanced %c in %s", r, s))
+			}
+		case unicode.IsSpace(r) && depth == 0:
+			if cur.Len() != 0 {
+				tokens = append(tokens, cur.String())
+				cur.Reset()
+			}
+
+			continue
+		}
+
+		cur.WriteRune(r)
+	}
+
+	if depth != 0 {
+		return nil, NewUsageError(fmt.Sprintf("unbalanced brackets in %s", s))
+	}
+
+	if cur.Len() != 0 {
+		tokens = append(tokens, cur.String())
+	}
+
+	return tokens, nil
+}
+
+// evalSelector returns the nodes a single selector matches
+func evalSelector(sel string, specs []*testbed.NodeSpec) ([]int, error) {
+	if sel == "" {
+		return nil, NewUsageError("empty node selector")
+	}
+
+	switch {
+	case strings.HasPrefix(sel, "!"):
+		neg, err := evalSelector(sel[1:], specs)
+		if err != nil {
+			return nil, err
+		}
+
+		set := make([]bool, len(specs))
+		for _, n := range neg {
+			set[n] = true
+		}
+
+		var out []int
+		for n, ok := range set {
+			if !ok {
+				out = append(out, n)
+			}
+		}
+
+		return out, nil
+	case sel == "all":
+		out := make([]int, len(specs))
+		for n := range out {
+			out[n] = n
+		}
+
+		return out, nil
+	case strings.HasPrefix(sel, "random("):
+		return randomNodes(sel, len(specs))
+	case sel == "last" || strings.HasPrefix(sel, "[") || strings.HasPrefix(sel, "-") || unicode.IsDigit(rune(sel[0])):
+		return parseIndexes(sel, len(specs))
+	}
+
+	reqs, err := parseRequirements(sel)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []int
+	for n, spec := range specs {
+		match := true
+		for _, req := range reqs {
+			if !req.matches(spec) {
+				match = false
+				break
+			}
+		}
+
+		if match {
+			out = append(out, n)
+		}
+	}
+
+	return out, nil
+}
+
+// randomNodes parses random(count) or random(count,seed), drawing count
+// distinct nodes out of total, in node order
+func randomNodes(sel string, total int) ([]int, error) {
+	if !strings.HasSuffix(sel, ")") {
+		return nil, NewUsageError(fmt.Sprintf("could not parse %s, expected random(count) or random(count,seed)", sel))
+	}
+
+	args := strings.Split(sel[len("random("):len(sel)-1], ",")
+	if len(args) > 2 {
+		return nil, NewUsageError(fmt.Sprintf("could not parse %s, expected random(count) or random(count,seed)", sel))
+	}
+
+	count, err := strconv.Atoi(strings.TrimSpace(args[0]))
+	if err != nil || count < 0 {
+		return nil, NewUsageError(fmt.Sprintf("count of %s must be a number of nodes", sel))
+	}
+
+	if count > total {
+		return nil, NewUsageError(fmt.Sprintf("%s draws more nodes than the %d of the testbed", sel, total))
+	}
+
+	seed := time.Now().UnixNano()
+	if len(args) == 2 {
+		seed, err = strconv.ParseInt(strings.TrimSpace(args[1]), 10, 64)
+		if err != nil {
+			return nil, NewUsageError(fmt.Sprintf("seed of %s must be a number", sel))
+		}
+	}
+
+	out := rand.New(rand.NewSource(seed)).Perm(total)[:count]
+	sort.Ints(out)
+
+	return out, nil
+}
+
+// requirement is a condition on a label of a node
+type requirement struct {
+	key   string
+	value string
+	// op is =, != or empty when the label only has to be set
+	op string
+}
+
+func (r requirement) matches(spec *testbed.NodeSpec) bool {
+	v, ok := spec.Label(r.key)
+
+	switch r.op {
+	case "=":
+		return ok && v == r.value
+	case "!=":
+		return !ok || v != r.value
+	}
+
+	return ok
+}
+
+func parseRequirements(sel string) ([]requirement, error) {
+	var reqs []requirement
+
+	for _, part := range strings.Split(sel, ",") {
+		var req requirement
+
+		if i := strings.Index(part, "!="); i >= 0 {
+			req = requirement{key: part[:i], value: part[i+2:], op: "!="}
+		} else if i := strings.Index(part, "="); i >= 0 {
+			req = requirement{key: part[:i], value: part[i+1:], op: "="}
+		} else {
+			req = requirement{key: part}
+		}
+
+		if req.key == "" {
+			return nil, NewUsageError(fmt.Sprintf("could not parse node selector %s, expected a label such as role=bootstrap", sel))
+		}
+
+		// Set operations written without spaces would otherwise be a
+		// label matching no node
+		if strings.ContainsAny(req.key, "[]()+&") || strings.ContainsAny(req.value, "[]()") {
+			return nil, NewUsageError(fmt.Sprintf("could not parse node selector %s, separate set operations by spaces: \"all - [0]\"", sel))
+		}
+
+		reqs = append(reqs, req)
+	}
+
+	return reqs, nil
+}
@@ -0,0 +1,83 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/ipfs/iptb/testbed"
+)
+
+func TestParseNodes(t *testing.T) {
+	specs := []*testbed.NodeSpec{
+		{Type: "localipfs", Labels: map[string]string{"role": "bootstrap", "region": "eu"}},
+		{Type: "localipfs", Labels: map[string]string{"role": "client", "region": "eu"}},
+		{Type: "dockeripfs", Labels: map[string]string{"role": "client", "region": "us"}},
+		{Type: "dockeripfs"},
+	}
+
+	cases := map[string][]int{
+		"[2,0]":                 {2, 0},
+		"3":                     {3},
+		"all":                   {0, 1, 2, 3},
+		"role=bootstrap":        {0},
+		"role=client,region=eu": {1},
+		"role!=client":          {0, 3},
+		"role":                  {0, 1, 2},
+		"!role":                 {3},
+		"type=dockeripfs":       {2, 3},
+		"all - [0-1]":           {2, 3},
+		"role=client + [3]":     {1, 2, 3},
+		"type=localipfs & region=eu - role=bootstrap": {1},
+	}
+
+	for sel, expected := range cases {
+		list, err := parseNodes(sel, specs)
+		if err != nil {
+			t.Fatalf("%s: %s", sel, err)
+		}
+
+		expect(t, len(list), len(expected))
+		for i := range expected {
+			expect(t, list[i], expected[i])
+		}
+	}
+
+	malformed := []string{"", "all -", "all ^ 1", "=client", "[3-1]", "[0-3", "[0-3:0]", "[1:2]", "[-2-3]", "random(5)", "random(x)", "!", "all - !", "all-[0]", "role=client+[3]", "all&role"}
+	for _, sel := range malformed {
+		_, err := parseNodes(sel, specs)
+		if _, ok := err.(*UsageError); !ok {
+			t.Fatalf("expected %q to be a usage error, got %v", sel, err)
+		}
+	}
+
+	for _, sel := range []string{"4", "-5", "[0-4]", "all - [0-9]"} {
+		if _, err := parseNodes(sel, specs); err == nil {
+			t.Fatalf("expected %q to be outside of the testbed", sel)
+		}
+	}
+}
+
+func TestParseNodesRandom(t *testing.T) {
+	specs := make([]*testbed.NodeSpec, 10)
+
+	a, err := parseNodes("random(4,42)", specs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := parseNodes("random(4,42)", specs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expect(t, len(a), 4)
+	expect(t, a, b)
+
+	seen := make(map[int]bool)
+	for _, n := range a {
+		if seen[n] || n < 0 || n >= len(specs) {
+			t.Fatalf("unexpected node %d in %v", n, a)
+		}
+
+		seen[n] = true
+	}
+}
@@ -2,9 +2,7 @@ package commands
 
 import (
 	"context"
-	"fmt"
 	"path"
-	"strconv"
 
 	cli "github.com/urfave/cli"
 
@@ -24,14 +22,19 @@ var ShellCmd = cli.Command{
 			return NewUsageError("shell takes exactly 1 argument")
 		}
 
-		i, err := strconv.Atoi(c.Args().First())
+		tb := testbed.NewTestbed(path.Join(flagRoot, "testbeds", flagTestbed))
+
+		nodes, err := tb.Nodes()
 		if err != nil {
-			return fmt.Errorf("parse err: %s", err)
+			return err
 		}
 
-		tb := testbed.NewTestbed(path.Join(flagRoot, "testbeds", flagTestbed))
+		specs, err := tb.Specs()
+		if err != nil {
+			return err
+		}
 
-		nodes, err := tb.Nodes()
+		i, err := parseNode(c.Args().First(), specs)
 		if err != nil {
 			return err
 		}
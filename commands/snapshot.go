@@ -0,0 +1,171 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"time"
+
+	cli "github.com/urfave/cli"
+
+	"github.com/ipfs/iptb/testbed"
+)
+
+var SnapshotCmd = cli.Command{
+	Name:  "snapshot",
+	Usage: "save and restore the state of the testbed",
+	Description: `
+Snapshots archive the testbed directory, the manifest and the node
+directories with their keys, repositories and configuration, into the
+snapshots directory of the testbed. Restoring a snapshot brings the
+testbed back to that state exactly.
+
+Nodes must be stopped while a snapshot is created or restored, --stop
+stops the running nodes first.
+`,
+	Subcommands: []cli.Command{
+		SnapshotCreateCmd,
+		SnapshotListCmd,
+		SnapshotRestoreCmd,
+		SnapshotDeleteCmd,
+	},
+}
+
+var SnapshotCreateCmd = cli.Command{
+	Name:      "create",
+	Usage:     "snapshot the testbed",
+	ArgsUsage: "<name>",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "stop",
+			Usage: "stop running nodes first",
+		},
+		cli.BoolFlag{
+			Name:  "force",
+			Usage: "replace an existing snapshot of the same name",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		flagRoot := c.GlobalString("IPTB_ROOT")
+		flagTestbed := c.GlobalString("testbed")
+		flagStop := c.Bool("stop")
+		flagForce := c.Bool("force")
+
+		if c.NArg() != 1 {
+			return NewUsageError("create takes exactly 1 argument")
+		}
+
+		tb := testbed.NewTestbed(path.Join(flagRoot, "testbeds", flagTestbed))
+
+		lock, err := tb.Lock(c.GlobalDuration("lock-wait"))
+		if err != nil {
+			return err
+		}
+		defer lock.Unlock()
+
+		ctx := context.Background()
+
+		if flagStop {
+			if err := tb.StopRunning(ctx); err != nil {
+				return err
+			}
+		}
+
+		return tb.CreateSnapshot(ctx, c.Args().First(), flagForce)
+	},
+}
+
+var SnapshotListCmd = cli.Command{
+	Name:  "list",
+	Usage: "list snapshots of the testbed",
+	Action: func(c *cli.Context) error {
+		flagRoot := c.GlobalString("IPTB_ROOT")
+		flagTestbed := c.GlobalString("testbed")
+		flagEncoding := c.GlobalString("encoding")
+
+		tb := testbed.NewTestbed(path.Join(flagRoot, "testbeds", flagTestbed))
+
+		snapshots, err := tb.Snapshots()
+		if err != nil {
+			return err
+		}
+
+		if flagEncoding == "json" {
+			if snapshots == nil {
+				snapshots = []testbed.Snapshot{}
+			}
+
+			return json.NewEncoder(c.App.Writer).Encode(snapshots)
+		}
+
+		for _, s := range snapshots {
+			fmt.Fprintf(c.App.Writer, "%s\t%s\t%d\n", s.Name, s.Created.Format(time.RFC3339), s.Size)
+		}
+
+		return nil
+	},
+}
+
+var SnapshotRestoreCmd = cli.Command{
+	Name:      "restore",
+	Usage:     "restore the testbed from a snapshot",
+	ArgsUsage: "<name>",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "stop",
+			Usage: "stop running nodes first",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		flagRoot := c.GlobalString("IPTB_ROOT")
+		flagTestbed := c.GlobalString("testbed")
+		flagStop := c.Bool("stop")
+
+		if c.NArg() != 1 {
+			return NewUsageError("restore takes exactly 1 argument")
+		}
+
+		tb := testbed.NewTestbed(path.Join(flagRoot, "testbeds", flagTestbed))
+
+		lock, err := tb.Lock(c.GlobalDuration("lock-wait"))
+		if err != nil {
+			return err
+		}
+		defer lock.Unlock()
+
+		ctx := context.Background()
+
+		if flagStop {
+			if err := tb.StopRunning(ctx); err != nil {
+				return err
+			}
+		}
+
+		return tb.RestoreSnapshot(ctx, c.Args().First())
+	},
+}
+
+var SnapshotDeleteCmd = cli.Command{
+	Name:      "delete",
+	Usage:     "delete a snapshot",
+	ArgsUsage: "<name>",
+	Action: func(c *cli.Context) error {
+		flagRoot := c.GlobalString("IPTB_ROOT")
+		flagTestbed := c.GlobalString("testbed")
+
+		if c.NArg() != 1 {
+			return NewUsageError("delete takes exactly 1 argument")
+		}
+
+		tb := testbed.NewTestbed(path.Join(flagRoot, "testbeds", flagTestbed))
+
+		lock, err := tb.Lock(c.GlobalDuration("lock-wait"))
+		if err != nil {
+			return err
+		}
+		defer lock.Unlock()
+
+		return tb.DeleteSnapshot(c.Args().First())
+	},
+}
@@ -22,7 +22,7 @@ var StopCmd = cli.Command{
 		flagEncoding := c.GlobalString("encoding")
 
 		tb := testbed.NewTestbed(path.Join(flagRoot, "testbeds", flagTestbed))
-		nodes, err := tb.Nodes()
+		specs, err := tb.Specs()
 		if err != nil {
 			return err
 		}
@@ -30,12 +30,17 @@ var StopCmd = cli.Command{
 		nodeRange := c.Args().First()
 
 		if nodeRange == "" {
-			nodeRange = fmt.Sprintf("[0-%d]", len(nodes)-1)
+			nodeRange = fmt.Sprintf("[0-%d]", len(specs)-1)
 		}
 
-		list, err := parseRange(nodeRange)
+		list, err := parseNodes(nodeRange, specs)
 		if err != nil {
-			return fmt.Errorf("could not parse node range %s", nodeRange)
+			return err
+		}
+
+		nodes, err := tb.Select(context.Background(), list)
+		if err != nil {
+			return err
 		}
 
 		runCmd := func(node testbedi.Core) (testbedi.Output, error) {
@@ -2,11 +2,17 @@ package commands
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"os"
 	"path"
+	"path/filepath"
+	"strings"
 
 	cli "github.com/urfave/cli"
 
 	"github.com/ipfs/iptb/testbed"
+	"github.com/ipfs/iptb/util"
 )
 
 var TestbedCmd = cli.Command{
@@ -14,6 +20,10 @@ var TestbedCmd = cli.Command{
 	Usage: "manage testbeds",
 	Subcommands: []cli.Command{
 		TestbedCreateCmd,
+		TestbedDeleteCmd,
+		TestbedValidateCmd,
+		TestbedExportCmd,
+		TestbedImportCmd,
 	},
 }
 
@@ -39,10 +49,31 @@ var TestbedCreateCmd = cli.Command{
 			Name:  "attr",
 			Usage: "specify addition attributes for nodes",
 		},
+		cli.StringSliceFlag{
+			Name:  "meta",
+			Usage: "record metadata about the testbed, as key,value",
+		},
 		cli.BoolFlag{
 			Name:  "init",
 			Usage: "initialize after creation (like calling `init` after create)",
 		},
+		cli.IntFlag{
+			Name:  "port-base",
+			Usage: "assign each node a fixed range of ports starting at this port, for nodes listening on the host",
+		},
+		cli.IntFlag{
+			Name:  "port-stride",
+			Usage: "number of ports reserved for each node when using --port-base",
+			Value: testbed.DefaultPortStride,
+		},
+		cli.StringSliceFlag{
+			Name:  "binary",
+			Usage: "binary to distribute across nodes (round robin), may be passed multiple times",
+		},
+		cli.BoolFlag{
+			Name:  "gateway",
+			Usage: "also assign a gateway address when using --port-base",
+		},
 	},
 	Action: func(c *cli.Context) error {
 		flagRoot := c.GlobalString("IPTB_ROOT")
@@ -52,10 +83,41 @@ var TestbedCreateCmd = cli.Command{
 		flagCount := c.Int("count")
 		flagForce := c.Bool("force")
 		flagAttrs := c.StringSlice("attr")
+		flagMeta := c.StringSlice("meta")
+		flagPortBase := c.Int("port-base")
+		flagPortStride := c.Int("port-stride")
+		flagGateway := c.Bool("gateway")
+		flagBinaries := c.StringSlice("binary")
 
 		attrs := parseAttrSlice(flagAttrs)
 		tb := testbed.NewTestbed(path.Join(flagRoot, "testbeds", flagTestbed))
 
+		// Relative binaries are resolved once, later commands may run from
+		// other directories
+		for i, bin := range flagBinaries {
+			abs, err := absBinary(bin)
+			if err != nil {
+				return err
+			}
+
+			flagBinaries[i] = abs
+		}
+
+		if bin, ok := attrs["binary"]; ok {
+			abs, err := absBinary(bin)
+			if err != nil {
+				return err
+			}
+
+			attrs["binary"] = abs
+		}
+
+		lock, err := tb.Lock(c.GlobalDuration("lock-wait"))
+		if err != nil {
+			return err
+		}
+		defer lock.Unlock()
+
 		if err := testbed.AlreadyInitCheck(tb.Dir(), flagForce); err != nil {
 			return err
 		}
@@ -65,11 +127,47 @@ var TestbedCreateCmd = cli.Command{
 			return err
 		}
 
-		if err := testbed.WriteNodeSpecs(tb.Dir(), specs); err != nil {
+		if len(flagBinaries) != 0 {
+			for i, spec := range specs {
+				spec.SetAttr("binary", flagBinaries[i%len(flagBinaries)])
+			}
+		}
+
+		if _, ok := attrs["privnet"]; ok {
+			if err := testbed.SetupPrivateNetwork(tb.Dir(), specs); err != nil {
+				return err
+			}
+		}
+
+		if flagPortBase != 0 {
+			pa := testbed.NewPortAllocator(flagPortBase, flagGateway)
+			pa.Stride = flagPortStride
+
+			if err := pa.Assign(specs); err != nil {
+				return err
+			}
+		}
+
+		manifest := &testbed.Manifest{
+			Created: testbed.NewCreationInfo(),
+			Nodes:   specs,
+		}
+
+		if len(flagMeta) != 0 {
+			manifest.Metadata = parseAttrSlice(flagMeta)
+		}
+
+		if err := testbed.WriteManifest(tb.Dir(), manifest); err != nil {
 			return err
 		}
 
 		if flagInit {
+			for i, spec := range specs {
+				if err := spec.CheckPorts(); err != nil {
+					return fmt.Errorf("node[%d]: %s", i, err)
+				}
+			}
+
 			nodes, err := tb.Nodes()
 			if err != nil {
 				return err
@@ -85,3 +183,282 @@ var TestbedCreateCmd = cli.Command{
 		return nil
 	},
 }
+
+var TestbedDeleteCmd = cli.Command{
+	Name:  "delete",
+	Usage: "delete testbed",
+	Description: `
+Deletes the testbed directory, after releasing the resources each node
+holds outside of it, such as docker containers and networks.
+`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "force",
+			Usage: "do not ask for confirmation",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		flagRoot := c.GlobalString("IPTB_ROOT")
+		flagTestbed := c.GlobalString("testbed")
+		flagForce := c.Bool("force")
+
+		tb := testbed.NewTestbed(path.Join(flagRoot, "testbeds", flagTestbed))
+
+		lock, err := tb.Lock(c.GlobalDuration("lock-wait"))
+		if err != nil {
+			return err
+		}
+		defer lock.Unlock()
+
+		if _, err := os.Stat(tb.Dir()); os.IsNotExist(err) {
+			return fmt.Errorf("testbed %s does not exist", flagTestbed)
+		}
+
+		if !flagForce && !iptbutil.YesNoPrompt(fmt.Sprintf("delete testbed %s? [y/n]", flagTestbed)) {
+			return nil
+		}
+
+		return destroyTestbed(c, &tb)
+	},
+}
+
+var TestbedValidateCmd = cli.Command{
+	Name:  "validate",
+	Usage: "check the testbed manifest",
+	Description: `
+Checks that the plugin of every node is loaded, that the attributes of the
+node specs are recognized by the plugin and that the node directories exist.
+
+Manifests written by older versions of iptb are migrated when the testbed
+changes next, --migrate rewrites the manifest right away.
+`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "migrate",
+			Usage: "rewrite the manifest in the current version",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		flagRoot := c.GlobalString("IPTB_ROOT")
+		flagTestbed := c.GlobalString("testbed")
+		flagMigrate := c.Bool("migrate")
+
+		tb := testbed.NewTestbed(path.Join(flagRoot, "testbeds", flagTestbed))
+
+		if flagMigrate {
+			lock, err := tb.Lock(c.GlobalDuration("lock-wait"))
+			if err != nil {
+				return err
+			}
+			defer lock.Unlock()
+		}
+
+		manifest, err := testbed.ReadManifest(tb.Dir())
+		if err != nil {
+			return err
+		}
+
+		if manifest.Version < testbed.ManifestVersion {
+			if flagMigrate {
+				if err := testbed.WriteManifest(tb.Dir(), manifest); err != nil {
+					return err
+				}
+
+				fmt.Fprintf(c.App.Writer, "migrated manifest from version %d to %d\n", manifest.Version, testbed.ManifestVersion)
+			} else {
+				fmt.Fprintf(c.App.Writer, "manifest version %d is migrated to %d with the next change, or --migrate\n", manifest.Version, testbed.ManifestVersion)
+			}
+		}
+
+		errs := manifest.Validate()
+		for _, err := range errs {
+			fmt.Fprintf(c.App.Writer, "%s\n", err)
+		}
+
+		if len(errs) != 0 {
+			return fmt.Errorf("testbed %s has %d problems", flagTestbed, len(errs))
+		}
+
+		return nil
+	},
+}
+
+var TestbedExportCmd = cli.Command{
+	Name:      "export",
+	Usage:     "archive a testbed to hand it over",
+	ArgsUsage: "[name] --output <file>",
+	Description: `
+Writes the testbed, its manifest and node directories with their keys,
+repositories, configuration and logs, to a gzipped tar archive. Snapshots
+are left out. The name defaults to the testbed given with --testbed, and an
+output of - writes the archive to stdout.
+
+Nodes must be stopped, --stop stops the running nodes first.
+`,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "output, o",
+			Usage: "file to write the archive to",
+		},
+		cli.BoolFlag{
+			Name:  "no-datastore",
+			Usage: "leave out the blocks and datastore of the nodes",
+		},
+		cli.BoolFlag{
+			Name:  "stop",
+			Usage: "stop running nodes first",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		flagRoot := c.GlobalString("IPTB_ROOT")
+		flagTestbed := c.GlobalString("testbed")
+		flagOutput := c.String("output")
+		flagNoDatastore := c.Bool("no-datastore")
+		flagStop := c.Bool("stop")
+
+		if c.NArg() > 1 {
+			return NewUsageError("export takes at most 1 argument")
+		}
+
+		if flagOutput == "" {
+			return NewUsageError("specify a file to write the archive to with --output")
+		}
+
+		if c.Args().Present() {
+			flagTestbed = c.Args().First()
+		}
+
+		tb := testbed.NewTestbed(path.Join(flagRoot, "testbeds", flagTestbed))
+
+		lock, err := tb.Lock(c.GlobalDuration("lock-wait"))
+		if err != nil {
+			return err
+		}
+		defer lock.Unlock()
+
+		ctx := context.Background()
+
+		if flagStop {
+			if err := tb.StopRunning(ctx); err != nil {
+				return err
+			}
+		}
+
+		opts := testbed.ExportOptions{
+			ExcludeDatastore: flagNoDatastore,
+		}
+
+		if flagOutput == "-" {
+			return tb.Export(ctx, c.App.Writer, opts)
+		}
+
+		f, err := os.Create(flagOutput)
+		if err != nil {
+			return err
+		}
+
+		if err := tb.Export(ctx, f, opts); err != nil {
+			f.Close()
+			os.Remove(flagOutput)
+			return err
+		}
+
+		return f.Close()
+	},
+}
+
+var TestbedImportCmd = cli.Command{
+	Name:      "import",
+	Usage:     "create a testbed from an exported archive",
+	ArgsUsage: "<file> [name]",
+	Description: `
+Creates a testbed from an archive written by export, with the node
+directories rewritten for the new location. The plugins of the nodes must be
+available. The name defaults to the testbed given with --testbed, and a file
+of - reads the archive from stdin.
+`,
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "force",
+			Usage: "replace an existing testbed of the same name",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		flagRoot := c.GlobalString("IPTB_ROOT")
+		flagTestbed := c.GlobalString("testbed")
+		flagForce := c.Bool("force")
+
+		if c.NArg() < 1 || c.NArg() > 2 {
+			return NewUsageError("import takes 1 or 2 arguments")
+		}
+
+		argFile := c.Args()[0]
+		if c.NArg() == 2 {
+			flagTestbed = c.Args()[1]
+		}
+
+		tb := testbed.NewTestbed(path.Join(flagRoot, "testbeds", flagTestbed))
+
+		lock, err := tb.Lock(c.GlobalDuration("lock-wait"))
+		if err != nil {
+			return err
+		}
+		defer lock.Unlock()
+
+		if _, err := os.Stat(tb.Dir()); err == nil {
+			if !flagForce {
+				return fmt.Errorf("testbed %s already exists", flagTestbed)
+			}
+
+			if err := destroyTestbed(c, &tb); err != nil {
+				return err
+			}
+		}
+
+		var r io.Reader = os.Stdin
+		if argFile != "-" {
+			f, err := os.Open(argFile)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			r = f
+		}
+
+		manifest, err := testbed.Import(r, tb.Dir())
+		if err != nil {
+			return err
+		}
+
+		// Attributes unknown to the local plugins are worth knowing about,
+		// but do not keep the testbed from being used
+		for _, err := range manifest.Validate() {
+			fmt.Fprintf(c.App.ErrWriter, "warning: %s\n", err)
+		}
+
+		return nil
+	},
+}
+
+// absBinary returns the absolute path of bin when it is a path, names looked
+// up by the plugins are returned as is
+func absBinary(bin string) (string, error) {
+	if !strings.ContainsRune(bin, filepath.Separator) {
+		return bin, nil
+	}
+
+	return filepath.Abs(bin)
+}
+
+// destroyTestbed destroys tb, printing the nodes which failed to release
+// their resources as warnings
+func destroyTestbed(c *cli.Context, tb *testbed.BasicTestbed) error {
+	err := tb.Destroy(context.Background())
+	if warnings, ok := err.(testbed.DestroyWarnings); ok {
+		fmt.Fprintln(c.App.ErrWriter, warnings)
+		return nil
+	}
+
+	return err
+}
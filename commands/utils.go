@@ -15,6 +15,7 @@ import (
 	cli "github.com/urfave/cli"
 
 	"github.com/ipfs/iptb/testbed/interfaces"
+	"github.com/ipfs/iptb/util"
 )
 
 // the flag terminator stops flag parsing, but it also swallowed if its the
@@ -75,54 +76,149 @@ func parseCommand(args []string, terminator bool) (string, []string) {
 	return args[0], arguments
 }
 
+// parseRange parses indexes without knowing the size of the testbed, so last
+// and negative indexes are rejected. See parseIndexes.
 func parseRange(s string) ([]int, error) {
-	if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
-		ranges := strings.Split(s[1:len(s)-1], ",")
-		var out []int
-		for _, r := range ranges {
-			rng, err := expandDashRange(r)
-			if err != nil {
-				return nil, err
-			}
+	return parseIndexes(s, -1)
+}
 
-			out = append(out, rng...)
+// parseIndexes parses a node index, or a list of them in brackets, for a
+// testbed of total nodes:
+//
+//	3, last, -1             a single node, negative indexes count from the end
+//	[0,2-4]                 nodes 0, 2, 3 and 4
+//	[0-last:2]              every other node, starting at 0
+//
+// A negative total is an unknown size. Indexes are returned in the order
+// given, the first of duplicate indexes is kept.
+func parseIndexes(s string, total int) ([]int, error) {
+	if strings.HasPrefix(s, "[") != strings.HasSuffix(s, "]") {
+		return nil, NewUsageError(fmt.Sprintf("unbalanced brackets in node range %s", s))
+	}
+
+	if !strings.HasPrefix(s, "[") {
+		i, err := parseIndex(s, total, true)
+		if err != nil {
+			return nil, err
 		}
-		return out, nil
+
+		return []int{i}, nil
 	}
-	i, err := strconv.Atoi(s)
-	if err != nil {
-		return nil, err
+
+	seen := make(map[int]bool)
+	var out []int
+
+	for _, item := range strings.Split(s[1:len(s)-1], ",") {
+		list, err := expandRangeItem(strings.TrimSpace(item), total)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, i := range list {
+			if !seen[i] {
+				seen[i] = true
+				out = append(out, i)
+			}
+		}
 	}
 
-	return []int{i}, nil
+	return out, nil
 }
 
-func expandDashRange(s string) ([]int, error) {
-	parts := strings.Split(s, "-")
-	if len(parts) == 1 {
-		i, err := strconv.Atoi(s)
+// expandRangeItem expands an item of a bracketed range: an index, or lo-hi
+// with an optional :step
+func expandRangeItem(s string, total int) ([]int, error) {
+	if s == "" {
+		return nil, NewUsageError("empty item in node range")
+	}
+
+	step := 1
+	if i := strings.Index(s, ":"); i >= 0 {
+		var err error
+		step, err = strconv.Atoi(s[i+1:])
+		if err != nil || step < 1 {
+			return nil, NewUsageError(fmt.Sprintf("step of %s must be a positive number", s))
+		}
+
+		s = s[:i]
+	}
+
+	// A leading dash is a negative index rather than a range
+	dash := strings.Index(s, "-")
+	if dash <= 0 {
+		if step != 1 {
+			return nil, NewUsageError(fmt.Sprintf("step of %s needs a range such as [0-10:2]", s))
+		}
+
+		i, err := parseIndex(s, total, true)
 		if err != nil {
 			return nil, err
 		}
+
 		return []int{i}, nil
 	}
-	low, err := strconv.Atoi(parts[0])
+
+	lo, err := parseIndex(s[:dash], total, false)
 	if err != nil {
 		return nil, err
 	}
 
-	hi, err := strconv.Atoi(parts[1])
+	hi, err := parseIndex(s[dash+1:], total, false)
 	if err != nil {
 		return nil, err
 	}
 
+	if lo > hi {
+		return nil, NewUsageError(fmt.Sprintf("range %s is reversed, use %d-%d", s, hi, lo))
+	}
+
 	var out []int
-	for i := low; i <= hi; i++ {
+	for i := lo; i <= hi; i += step {
 		out = append(out, i)
 	}
+
 	return out, nil
 }
 
+// parseIndex parses a single index, last or, when negative is set, a
+// negative index counting from the end. Indexes outside of a testbed of known
+// size are an error.
+func parseIndex(s string, total int, negative bool) (int, error) {
+	var i int
+
+	if s == "last" {
+		if total < 0 {
+			return 0, NewUsageError("last can not be used here")
+		}
+
+		i = total - 1
+	} else {
+		var err error
+		i, err = strconv.Atoi(s)
+		if err != nil {
+			return 0, NewUsageError(fmt.Sprintf("could not parse node index %q", s))
+		}
+
+		if i < 0 {
+			if !negative {
+				return 0, NewUsageError(fmt.Sprintf("negative index %s can not be used in a range, use last", s))
+			}
+
+			if total < 0 {
+				return 0, NewUsageError(fmt.Sprintf("negative index %s can not be used here", s))
+			}
+
+			i += total
+		}
+	}
+
+	if total >= 0 && (i < 0 || i >= total) {
+		return 0, fmt.Errorf("Node range contains value (%s) outside of valid range [0-%d]", s, total-1)
+	}
+
+	return i, nil
+}
+
 type Result struct {
 	Node    int
 	Output  testbedi.Output
@@ -141,7 +237,17 @@ type output struct {
 
 type outputFunc func(testbedi.Core) (testbedi.Output, error)
 
+// indexedOutputFunc is an outputFunc which is also passed the index of the node
+type indexedOutputFunc func(int, testbedi.Core) (testbedi.Output, error)
+
 func mapWithOutput(list []int, nodes []testbedi.Core, fn outputFunc) ([]Result, error) {
+	return mapWithIndex(list, nodes, func(_ int, node testbedi.Core) (testbedi.Output, error) {
+		return fn(node)
+	})
+}
+
+// mapWithIndex runs fn on every node in list concurrently, like mapWithOutput
+func mapWithIndex(list []int, nodes []testbedi.Core, fn indexedOutputFunc) ([]Result, error) {
 	var wg sync.WaitGroup
 	var lk sync.Mutex
 	results := make([]Result, len(list))
@@ -155,7 +261,7 @@ func mapWithOutput(list []int, nodes []testbedi.Core, fn outputFunc) ([]Result,
 		go func(i, n int, node testbedi.Core) {
 			defer wg.Done()
 			start := time.Now()
-			out, err := fn(node)
+			out, err := fn(n, node)
 			elapsed := time.Since(start)
 			lk.Lock()
 			defer lk.Unlock()
@@ -190,6 +296,37 @@ func validRange(list []int, total int) error {
 	return nil
 }
 
+// reportValues reports the value get reads from each node in list. A single
+// node given by index in the text encoding prints the bare value, as it did
+// before commands took ranges, so scripts reading it keep working.
+func reportValues(c *cli.Context, arg string, list []int, nodes []testbedi.Core, encoding string, get func(testbedi.Core) (string, error)) error {
+	if _, err := strconv.Atoi(arg); err == nil && encoding == "text" {
+		value, err := get(nodes[list[0]])
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintf(c.App.Writer, "%s\n", value)
+		return err
+	}
+
+	runCmd := func(node testbedi.Core) (testbedi.Output, error) {
+		value, err := get(node)
+		if err != nil {
+			return nil, err
+		}
+
+		return iptbutil.NewOutput(nil, []byte(value+"\n"), nil, 0, nil), nil
+	}
+
+	results, err := mapWithOutput(list, nodes, runCmd)
+	if err != nil {
+		return err
+	}
+
+	return buildReport(results, encoding)
+}
+
 func buildReport(results []Result, encoding string) error {
 	var errs []error
 
@@ -3,6 +3,7 @@ package commands
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"reflect"
 	"runtime"
 	"strings"
@@ -35,6 +36,8 @@ func TestParseRange(t *testing.T) {
 		{"[0,1]", []int{0, 1}, nil},
 		{"[1,4]", []int{1, 4}, nil},
 		{"[1,3,5-8]", []int{1, 3, 5, 6, 7, 8}, nil},
+		{"[0-6:3]", []int{0, 3, 6}, nil},
+		{"[1,1,0-1]", []int{1, 0}, nil},
 	}
 
 	for _, c := range cases {
@@ -103,3 +106,13 @@ func TestParseAttrSlice(t *testing.T) {
 		expect(t, attrs, c.expectedAttrs)
 	}
 }
+
+func TestAbsBinary(t *testing.T) {
+	bin, err := absBinary("ipfs")
+	expect(t, err, nil)
+	expect(t, bin, "ipfs")
+
+	bin, err = absBinary(filepath.Join("bin", "ipfs"))
+	expect(t, err, nil)
+	expect(t, bin, filepath.Join(wd, "bin", "ipfs"))
+}
@@ -62,6 +62,11 @@ func main() {
 			EnvVar: "IPTB_ROOT",
 			Hidden: true,
 		},
+		cli.DurationFlag{
+			Name:   "lock-wait",
+			EnvVar: "IPTB_LOCK_WAIT",
+			Usage:  "how long to wait for another iptb process changing the testbed",
+		},
 		cli.StringFlag{
 			Name:  "encoding",
 			Usage: "Specify the output format, current options JSON and text",
@@ -104,11 +109,18 @@ func main() {
 		c.Set("encoding", flagFormatLwr)
 
 		c.Set("IPTB_ROOT", flagRoot)
+
+		// Plugins resolve resources (such as binaries) relative to IPTB_ROOT
+		if err := os.Setenv("IPTB_ROOT", flagRoot); err != nil {
+			return err
+		}
+
 		return loadPlugins(path.Join(flagRoot, "plugins"))
 	}
 	app.Commands = []cli.Command{
 		commands.AutoCmd,
 		commands.TestbedCmd,
+		commands.SnapshotCmd,
 
 		commands.InitCmd,
 		commands.StartCmd,
@@ -117,12 +129,20 @@ func main() {
 		commands.RunCmd,
 		commands.ConnectCmd,
 		commands.ShellCmd,
+		commands.SeedCmd,
+		commands.ChurnCmd,
+		commands.FaultCmd,
+		commands.StatusCmd,
 
 		commands.AttrCmd,
+		commands.ConfigCmd,
+		commands.LabelCmd,
+		commands.LinkCmd,
 
 		commands.LogsCmd,
 		commands.EventsCmd,
 		commands.MetricCmd,
+		commands.BenchCmd,
 	}
 
 	// https://github.com/urfave/cli/issues/736
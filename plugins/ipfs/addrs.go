@@ -0,0 +1,179 @@
+package ipfs
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/multiformats/go-multiaddr"
+
+	"github.com/ipfs/iptb/util"
+)
+
+const (
+	TransportTCP  = "tcp"
+	TransportWS   = "ws"
+	TransportQUIC = "quic"
+	TransportUDP  = "udp"
+)
+
+// TransportAny disables filtering addresses by transport
+const TransportAny = "any"
+
+// DefaultTransport is the transport used to connect nodes when none is requested
+const DefaultTransport = TransportTCP
+
+// Transport returns the transport used by the multiaddr maddr, one of tcp,
+// ws, quic or udp. An empty string is returned if it cannot be determined.
+func Transport(maddr string) string {
+	var transport string
+
+	for _, p := range strings.Split(maddr, "/") {
+		switch p {
+		case TransportTCP, TransportUDP:
+			transport = p
+		case TransportWS, TransportQUIC:
+			return p
+		case "ipfs", "p2p":
+			return transport
+		}
+	}
+
+	return transport
+}
+
+// FilterByTransport returns the addresses in addrs using transport
+func FilterByTransport(addrs []string, transport string) []string {
+	var out []string
+	for _, a := range addrs {
+		if Transport(a) == transport {
+			out = append(out, a)
+		}
+	}
+
+	return out
+}
+
+// SwarmListenAddrs builds the list of swarm addresses a node should listen on
+// from its attributes. The `swarmaddrs` attribute is a comma separated list of
+// multiaddrs which is used verbatim. Otherwise, when the `transports` or `ip6`
+// attributes are set, addresses are derived from the host and port of the
+// `swarmaddr` attribute (or def): `transports` is a comma separated list of
+// tcp, ws and quic, and `ip6` repeats the addresses for the ip6 equivalent of
+// the host. Without them, `swarmaddr` (or def) is used as is.
+//
+// Addresses are kept as strings, transports such as ws may not be registered
+// with the multiaddr library until the node itself parses them.
+func SwarmListenAddrs(attrs map[string]string, def string) ([]string, error) {
+	if v, ok := attrs["swarmaddrs"]; ok {
+		var out []string
+		for _, s := range strings.Split(v, ",") {
+			out = append(out, strings.TrimSpace(s))
+		}
+
+		return out, nil
+	}
+
+	base := def
+	if v, ok := attrs["swarmaddr"]; ok {
+		base = v
+	}
+
+	v, derive := attrs["transports"]
+	_, ip6 := attrs["ip6"]
+	if !derive && !ip6 {
+		return []string{base}, nil
+	}
+
+	transports := []string{TransportTCP}
+	if derive {
+		transports = strings.Split(v, ",")
+	}
+
+	proto, host, port, err := iptbutil.ParseTCPAddr(base)
+	if err != nil || proto != "ip4" && proto != "ip6" || len(strings.Split(base, "/")) != 5 {
+		return nil, fmt.Errorf("swarmaddr %s must be of the form /ip4/<ip>/tcp/<port> to use transports or ip6", base)
+	}
+
+	hosts := [][2]string{{proto, host}}
+	if ip6 && proto == "ip4" {
+		host6 := "::1"
+		if host == "0.0.0.0" {
+			host6 = "::"
+		}
+
+		hosts = append(hosts, [2]string{"ip6", host6})
+	}
+
+	if _, err := multiaddr.NewMultiaddr(base); err != nil {
+		return nil, err
+	}
+
+	var out []string
+	for _, h := range hosts {
+		for _, t := range transports {
+			var s string
+			switch strings.TrimSpace(t) {
+			case TransportTCP:
+				s = fmt.Sprintf("/%s/%s/tcp/%s", h[0], h[1], port)
+			case TransportWS:
+				// ws can not share the tcp port, let the os pick one
+				s = fmt.Sprintf("/%s/%s/tcp/0/ws", h[0], h[1])
+			case TransportQUIC:
+				s = fmt.Sprintf("/%s/%s/udp/%s/quic", h[0], h[1], port)
+			default:
+				return nil, fmt.Errorf("unsupported transport %s", t)
+			}
+
+			out = append(out, s)
+		}
+	}
+
+	return out, nil
+}
+
+// HasTransport returns true if any of addrs uses transport
+func HasTransport(addrs []string, transport string) bool {
+	for _, a := range addrs {
+		if Transport(a) == transport {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsLoopback returns true if the multiaddr maddr is a loopback address
+func IsLoopback(maddr string) bool {
+	parts := strings.Split(maddr, "/")
+	if len(parts) < 3 {
+		return false
+	}
+
+	switch parts[1] {
+	case "ip4", "ip6":
+		ip := net.ParseIP(parts[2])
+		return ip != nil && ip.IsLoopback()
+	case "dns", "dns4", "dns6":
+		return parts[2] == "localhost"
+	}
+
+	return false
+}
+
+// IsUnroutable returns true if the multiaddr maddr can not be dialed from
+// another node, such as unspecified or link-local addresses
+func IsUnroutable(maddr string) bool {
+	parts := strings.Split(maddr, "/")
+	if len(parts) < 3 {
+		return true
+	}
+
+	switch parts[1] {
+	case "ip4", "ip6":
+		ip := net.ParseIP(parts[2])
+		return ip == nil || ip.IsUnspecified() || ip.IsLinkLocalUnicast()
+	}
+
+	return false
+}
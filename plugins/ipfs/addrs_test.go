@@ -0,0 +1,96 @@
+package ipfs
+
+import (
+	"testing"
+)
+
+func TestTransport(t *testing.T) {
+	cases := map[string]string{
+		"/ip4/127.0.0.1/tcp/4001/ipfs/QmPeer":    TransportTCP,
+		"/ip6/::1/tcp/4001":                      TransportTCP,
+		"/ip4/127.0.0.1/tcp/4002/ws/ipfs/QmPeer": TransportWS,
+		"/ip4/127.0.0.1/udp/4001/quic":           TransportQUIC,
+		"/ip4/127.0.0.1/udp/4001":                TransportUDP,
+		"":                                       "",
+	}
+
+	for input, expected := range cases {
+		if transport := Transport(input); transport != expected {
+			t.Errorf("%s: expected %s, got %s", input, expected, transport)
+		}
+	}
+}
+
+func TestSwarmListenAddrs(t *testing.T) {
+	attrs := map[string]string{
+		"transports": "tcp,ws,quic",
+		"ip6":        "true",
+	}
+
+	maddrs, err := SwarmListenAddrs(attrs, "/ip4/127.0.0.1/tcp/4001")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"/ip4/127.0.0.1/tcp/4001",
+		"/ip4/127.0.0.1/tcp/0/ws",
+		"/ip4/127.0.0.1/udp/4001/quic",
+		"/ip6/::1/tcp/4001",
+		"/ip6/::1/tcp/0/ws",
+		"/ip6/::1/udp/4001/quic",
+	}
+
+	if len(maddrs) != len(expected) {
+		t.Fatalf("expected %d addresses, got %d", len(expected), len(maddrs))
+	}
+
+	for i, maddr := range maddrs {
+		if maddr != expected[i] {
+			t.Errorf("expected %s, got %s", expected[i], maddr)
+		}
+	}
+}
+
+func TestAddrScope(t *testing.T) {
+	cases := []struct {
+		input      string
+		loopback   bool
+		unroutable bool
+	}{
+		{"/ip4/127.0.0.1/tcp/4001", true, false},
+		{"/ip6/::1/tcp/4001", true, false},
+		{"/ip4/172.17.0.2/tcp/4001", false, false},
+		{"/ip4/0.0.0.0/tcp/4001", false, true},
+		{"/ip6/fe80::1/tcp/4001", false, true},
+		{"/dns4/localhost/tcp/4001", true, false},
+		{"", false, true},
+	}
+
+	for _, c := range cases {
+		if IsLoopback(c.input) != c.loopback {
+			t.Errorf("%s: expected loopback %t", c.input, c.loopback)
+		}
+
+		if IsUnroutable(c.input) != c.unroutable {
+			t.Errorf("%s: expected unroutable %t", c.input, c.unroutable)
+		}
+	}
+}
+
+func TestSwarmListenAddrsPassthrough(t *testing.T) {
+	for _, swarmaddr := range []string{"/ip4/0.0.0.0/tcp/4001/ws", "/ip4/127.0.0.1/udp/4001/quic"} {
+		maddrs, err := SwarmListenAddrs(map[string]string{"swarmaddr": swarmaddr}, "/ip4/127.0.0.1/tcp/4001")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(maddrs) != 1 || maddrs[0] != swarmaddr {
+			t.Errorf("expected %s to be used as is, got %v", swarmaddr, maddrs)
+		}
+	}
+
+	if _, err := SwarmListenAddrs(map[string]string{"swarmaddr": "/ip4/0.0.0.0/tcp/4001/ws", "ip6": ""}, ""); err == nil {
+		t.Error("expected an error deriving addresses from a ws address")
+	}
+}
@@ -0,0 +1,618 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const (
+	defaultDockerHost = "unix:///var/run/docker.sock"
+	dockerAPIVersion  = "v1.24"
+)
+
+// dockerClient is a minimal client for the Docker Engine API
+type dockerClient struct {
+	network string
+	addr    string
+	http    *http.Client
+}
+
+// newDockerClient returns a client for the docker daemon at host, which is
+// either unix:///path/to/socket or tcp://host:port. An empty host uses
+// DOCKER_HOST, or the default unix socket.
+func newDockerClient(host string) (*dockerClient, error) {
+	if host == "" {
+		host = os.Getenv("DOCKER_HOST")
+	}
+
+	if host == "" {
+		host = defaultDockerHost
+	}
+
+	parts := strings.SplitN(host, "://", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid docker host %s", host)
+	}
+
+	network, addr := parts[0], parts[1]
+	if network != "unix" && network != "tcp" {
+		return nil, fmt.Errorf("unsupported docker host protocol %s", network)
+	}
+
+	dc := &dockerClient{
+		network: network,
+		addr:    addr,
+	}
+
+	dc.http = &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dc.dial(ctx)
+			},
+		},
+	}
+
+	return dc, nil
+}
+
+func (dc *dockerClient) dial(ctx context.Context) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, dc.network, dc.addr)
+}
+
+func (dc *dockerClient) url(path string, query url.Values) string {
+	// The host is ignored when dialing, but required to build a valid request
+	u := "http://docker/" + dockerAPIVersion + path
+	if len(query) != 0 {
+		u += "?" + query.Encode()
+	}
+
+	return u
+}
+
+// do sends a request to the docker api, a non 2xx response is turned into an error
+func (dc *dockerClient) do(ctx context.Context, method, path string, query url.Values, body interface{}) (*http.Response, error) {
+	var rbody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+
+		rbody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, dc.url(path, query), rbody)
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := dc.http.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		return nil, newDockerError(resp)
+	}
+
+	return resp, nil
+}
+
+// dockerError is an error reported by the docker daemon
+type dockerError struct {
+	StatusCode int
+	Message    string `json:"message"`
+}
+
+func newDockerError(resp *http.Response) error {
+	derr := &dockerError{StatusCode: resp.StatusCode}
+
+	data, _ := ioutil.ReadAll(resp.Body)
+	if err := json.Unmarshal(data, derr); err != nil || derr.Message == "" {
+		derr.Message = strings.TrimSpace(string(data))
+	}
+
+	return derr
+}
+
+func (e *dockerError) Error() string {
+	return fmt.Sprintf("docker: %s (%d)", e.Message, e.StatusCode)
+}
+
+func isNotFound(err error) bool {
+	derr, ok := err.(*dockerError)
+	return ok && derr.StatusCode == http.StatusNotFound
+}
+
+func (dc *dockerClient) doJSON(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	resp, err := dc.do(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		_, err = io.Copy(ioutil.Discard, resp.Body)
+		return err
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+type containerConfig struct {
+	Image      string
+	Cmd        []string          `json:",omitempty"`
+	Env        []string          `json:",omitempty"`
+	Labels     map[string]string `json:",omitempty"`
+	HostConfig hostConfig
+}
+
+type hostConfig struct {
+	Binds       []string `json:",omitempty"`
+	NetworkMode string   `json:",omitempty"`
+	resources
+}
+
+// resources are the container limits which can be set at creation, and
+// except for PidsLimit and Ulimits, updated while the container runs
+type resources struct {
+	CPUPeriod  int64    `json:"CpuPeriod,omitempty"`
+	CPUQuota   int64    `json:"CpuQuota,omitempty"`
+	Memory     int64    `json:",omitempty"`
+	MemorySwap int64    `json:",omitempty"`
+	PidsLimit  int64    `json:",omitempty"`
+	Ulimits    []ulimit `json:",omitempty"`
+}
+
+type ulimit struct {
+	Name string
+	Soft int64
+	Hard int64
+}
+
+type containerState struct {
+	Status   string
+	Running  bool
+	Paused   bool
+	ExitCode int
+}
+
+type endpointSettings struct {
+	IPAddress         string
+	GlobalIPv6Address string
+}
+
+type containerJSON struct {
+	ID              string `json:"Id"`
+	Name            string
+	State           containerState
+	HostConfig      hostConfig
+	NetworkSettings struct {
+		Networks map[string]endpointSettings
+	}
+}
+
+// createContainer creates a container, pulling the image if it is not available
+func (dc *dockerClient) createContainer(ctx context.Context, name string, cfg *containerConfig) (string, error) {
+	query := url.Values{}
+	if name != "" {
+		query.Set("name", name)
+	}
+
+	var out struct {
+		ID string `json:"Id"`
+	}
+
+	err := dc.doJSON(ctx, "POST", "/containers/create", query, cfg, &out)
+	if isNotFound(err) {
+		if err := dc.pullImage(ctx, cfg.Image); err != nil {
+			return "", err
+		}
+
+		err = dc.doJSON(ctx, "POST", "/containers/create", query, cfg, &out)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return out.ID, nil
+}
+
+func (dc *dockerClient) pullImage(ctx context.Context, image string) error {
+	tag := "latest"
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image, tag = image[:i], image[i+1:]
+	}
+
+	query := url.Values{}
+	query.Set("fromImage", image)
+	query.Set("tag", tag)
+
+	resp, err := dc.do(ctx, "POST", "/images/create", query, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// The pull progress is streamed as json messages, errors included
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg struct {
+			Error string `json:"error"`
+		}
+
+		if err := dec.Decode(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if msg.Error != "" {
+			return fmt.Errorf("docker: pulling %s: %s", image, msg.Error)
+		}
+	}
+}
+
+func (dc *dockerClient) startContainer(ctx context.Context, id string) error {
+	return dc.doJSON(ctx, "POST", "/containers/"+id+"/start", nil, nil, nil)
+}
+
+func (dc *dockerClient) killContainer(ctx context.Context, id, signal string) error {
+	query := url.Values{}
+	query.Set("signal", signal)
+
+	return dc.doJSON(ctx, "POST", "/containers/"+id+"/kill", query, nil, nil)
+}
+
+// pauseContainer suspends the processes of the container
+func (dc *dockerClient) pauseContainer(ctx context.Context, id string) error {
+	return dc.doJSON(ctx, "POST", "/containers/"+id+"/pause", nil, nil, nil)
+}
+
+func (dc *dockerClient) unpauseContainer(ctx context.Context, id string) error {
+	return dc.doJSON(ctx, "POST", "/containers/"+id+"/unpause", nil, nil, nil)
+}
+
+// removeContainer removes the container, force also removes a running container
+func (dc *dockerClient) removeContainer(ctx context.Context, id string, force bool) error {
+	query := url.Values{}
+	if force {
+		query.Set("force", "1")
+	}
+
+	return dc.doJSON(ctx, "DELETE", "/containers/"+id, query, nil, nil)
+}
+
+// updateContainer changes the resource limits of a container
+func (dc *dockerClient) updateContainer(ctx context.Context, id string, res resources) error {
+	return dc.doJSON(ctx, "POST", "/containers/"+id+"/update", nil, res, nil)
+}
+
+// waitContainer blocks until the container stops
+func (dc *dockerClient) waitContainer(ctx context.Context, id string) error {
+	return dc.doJSON(ctx, "POST", "/containers/"+id+"/wait", nil, nil, nil)
+}
+
+func (dc *dockerClient) inspectContainer(ctx context.Context, id string) (*containerJSON, error) {
+	var out containerJSON
+	if err := dc.doJSON(ctx, "GET", "/containers/"+id+"/json", nil, nil, &out); err != nil {
+		return nil, err
+	}
+
+	return &out, nil
+}
+
+type networkJSON struct {
+	ID         string `json:"Id"`
+	Name       string
+	Labels     map[string]string
+	Containers map[string]struct {
+		Name string
+	}
+}
+
+// createNetwork creates a bridge network, it is not an error for the network
+// to already exist
+func (dc *dockerClient) createNetwork(ctx context.Context, name string, labels map[string]string) error {
+	cfg := map[string]interface{}{
+		"Name":           name,
+		"CheckDuplicate": true,
+		"Driver":         "bridge",
+		"Labels":         labels,
+	}
+
+	err := dc.doJSON(ctx, "POST", "/networks/create", nil, cfg, nil)
+	if derr, ok := err.(*dockerError); ok && derr.StatusCode == http.StatusConflict {
+		return nil
+	}
+
+	return err
+}
+
+func (dc *dockerClient) inspectNetwork(ctx context.Context, name string) (*networkJSON, error) {
+	var out networkJSON
+	if err := dc.doJSON(ctx, "GET", "/networks/"+name, nil, nil, &out); err != nil {
+		return nil, err
+	}
+
+	return &out, nil
+}
+
+func (dc *dockerClient) removeNetwork(ctx context.Context, name string) error {
+	return dc.doJSON(ctx, "DELETE", "/networks/"+name, nil, nil, nil)
+}
+
+// logs returns the demultiplexed stdout or stderr stream of the container
+func (dc *dockerClient) logs(ctx context.Context, id string, stderr bool, follow bool) (io.ReadCloser, error) {
+	query := url.Values{}
+	if stderr {
+		query.Set("stderr", "1")
+	} else {
+		query.Set("stdout", "1")
+	}
+
+	if follow {
+		query.Set("follow", "1")
+	}
+
+	resp, err := dc.do(ctx, "GET", "/containers/"+id+"/logs", query, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		defer resp.Body.Close()
+		pw.CloseWithError(demux(resp.Body, pw, pw))
+	}()
+
+	return pr, nil
+}
+
+// dockerEvent is a message from the docker event stream
+type dockerEvent struct {
+	Type   string
+	Action string
+	Actor  struct {
+		ID         string
+		Attributes map[string]string
+	}
+}
+
+// events streams docker events for the container until ctx is done
+func (dc *dockerClient) events(ctx context.Context, id string) (<-chan dockerEvent, error) {
+	filters, err := json.Marshal(map[string][]string{
+		"container": {id},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{}
+	query.Set("filters", string(filters))
+
+	resp, err := dc.do(ctx, "GET", "/events", query, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan dockerEvent)
+	go func() {
+		defer close(out)
+		defer resp.Body.Close()
+
+		dec := json.NewDecoder(resp.Body)
+		for {
+			var ev dockerEvent
+			if err := dec.Decode(&ev); err != nil {
+				return
+			}
+
+			select {
+			case out <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// exec runs cmd in the container, returning its output and exit code
+func (dc *dockerClient) exec(ctx context.Context, id string, cmd []string, env []string, stdin io.Reader) ([]byte, []byte, int, error) {
+	execID, err := dc.createExec(ctx, id, cmd, env, stdin != nil, false)
+	if err != nil {
+		return nil, nil, -1, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	if err := dc.execStart(ctx, execID, false, stdin, &stdout, &stderr); err != nil {
+		return nil, nil, -1, err
+	}
+
+	code, err := dc.execExitCode(ctx, execID)
+	if err != nil {
+		return nil, nil, -1, err
+	}
+
+	return stdout.Bytes(), stderr.Bytes(), code, nil
+}
+
+// execTTY runs cmd in the container attached to a pseudo terminal, as
+// `docker exec -it` does, returning its exit code
+func (dc *dockerClient) execTTY(ctx context.Context, id string, cmd []string, env []string, stdin io.Reader, stdout io.Writer) (int, error) {
+	execID, err := dc.createExec(ctx, id, cmd, env, true, true)
+	if err != nil {
+		return -1, err
+	}
+
+	if err := dc.execStart(ctx, execID, true, stdin, stdout, stdout); err != nil {
+		return -1, err
+	}
+
+	return dc.execExitCode(ctx, execID)
+}
+
+func (dc *dockerClient) createExec(ctx context.Context, id string, cmd []string, env []string, stdin, tty bool) (string, error) {
+	cfg := map[string]interface{}{
+		"AttachStdin":  stdin,
+		"AttachStdout": true,
+		"AttachStderr": true,
+		"Tty":          tty,
+		"Cmd":          cmd,
+		"Env":          env,
+	}
+
+	var created struct {
+		ID string `json:"Id"`
+	}
+
+	if err := dc.doJSON(ctx, "POST", "/containers/"+id+"/exec", nil, cfg, &created); err != nil {
+		return "", err
+	}
+
+	return created.ID, nil
+}
+
+func (dc *dockerClient) execExitCode(ctx context.Context, id string) (int, error) {
+	var inspect struct {
+		Running  bool
+		ExitCode int
+	}
+
+	if err := dc.doJSON(ctx, "GET", "/exec/"+id+"/json", nil, nil, &inspect); err != nil {
+		return -1, err
+	}
+
+	return inspect.ExitCode, nil
+}
+
+// execStart starts an exec instance. The connection is hijacked, so stdin
+// can be streamed to the process while its output is read. Output of a tty
+// is a raw stream, otherwise stdout and stderr are multiplexed. The
+// connection is closed once ctx is done, interrupting the process' streams.
+func (dc *dockerClient) execStart(ctx context.Context, id string, tty bool, stdin io.Reader, stdout, stderr io.Writer) error {
+	conn, err := dc.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	body, err := json.Marshal(map[string]bool{"Detach": false, "Tty": tty})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", dc.url("/exec/"+id+"/start", nil), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "tcp")
+
+	if err := req.Write(conn); err != nil {
+		return ctxErr(ctx, err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return ctxErr(ctx, err)
+	}
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusSwitchingProtocols {
+		defer resp.Body.Close()
+		return newDockerError(resp)
+	}
+
+	if stdin != nil {
+		go func() {
+			io.Copy(conn, stdin)
+			if cw, ok := conn.(interface {
+				CloseWrite() error
+			}); ok {
+				cw.CloseWrite()
+			}
+		}()
+	}
+
+	if tty {
+		_, err = io.Copy(stdout, br)
+	} else {
+		err = demux(br, stdout, stderr)
+	}
+
+	return ctxErr(ctx, err)
+}
+
+// ctxErr returns the error of ctx once it is done, it explains the errors of
+// the connections closed because of it
+func ctxErr(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return err
+}
+
+// demux splits a multiplexed docker stream into stdout and stderr. Each frame
+// starts with an 8 byte header: the stream type, 3 bytes of padding and the
+// big endian frame size.
+func demux(r io.Reader, stdout, stderr io.Writer) error {
+	header := make([]byte, 8)
+
+	for {
+		if _, err := io.ReadFull(r, header); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		var w io.Writer
+		switch header[0] {
+		case 0, 1:
+			w = stdout
+		case 2:
+			w = stderr
+		default:
+			return fmt.Errorf("unexpected stream type %d", header[0])
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.CopyN(w, r, size); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func frame(stream byte, data string) []byte {
+	header := make([]byte, 8)
+	header[0] = stream
+	binary.BigEndian.PutUint32(header[4:], uint32(len(data)))
+	return append(header, data...)
+}
+
+// newStandInServer returns a server implementing the subset of the docker api
+// used by the plugin, for a single container `abc`
+func newStandInServer(t *testing.T) *httptest.Server {
+	pulled := false
+	paused := false
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1.24/images/create", func(w http.ResponseWriter, r *http.Request) {
+		pulled = true
+		w.Write([]byte(`{"status":"Pulling"}` + "\n" + `{"status":"Done"}`))
+	})
+	mux.HandleFunc("/v1.24/containers/create", func(w http.ResponseWriter, r *http.Request) {
+		if !pulled {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"No such image"}`))
+			return
+		}
+
+		var cfg containerConfig
+		if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+			t.Error(err)
+		}
+
+		if cfg.Image != "ipfs/go-ipfs" || len(cfg.HostConfig.Binds) != 1 {
+			t.Errorf("unexpected container config: %+v", cfg)
+		}
+
+		w.Write([]byte(`{"Id":"abc"}`))
+	})
+	mux.HandleFunc("/v1.24/containers/abc/start", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/v1.24/containers/abc/pause", func(w http.ResponseWriter, r *http.Request) {
+		paused = true
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/v1.24/containers/abc/unpause", func(w http.ResponseWriter, r *http.Request) {
+		paused = false
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/v1.24/containers/abc/json", func(w http.ResponseWriter, r *http.Request) {
+		if paused {
+			w.Write([]byte(`{"Id":"abc","State":{"Status":"paused","Running":true,"Paused":true}}`))
+			return
+		}
+
+		w.Write([]byte(`{"Id":"abc","State":{"Status":"running","Running":true}}`))
+	})
+	mux.HandleFunc("/v1.24/containers/abc/logs", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("stderr") == "1" {
+			w.Write(frame(2, "daemon error\n"))
+			return
+		}
+
+		w.Write(frame(1, "Daemon is ready\n"))
+	})
+	mux.HandleFunc("/v1.24/containers/abc/exec", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"Id":"exec1"}`))
+	})
+	mux.HandleFunc("/v1.24/exec/exec1/start", func(w http.ResponseWriter, r *http.Request) {
+		// Consume the start options before the connection is hijacked
+		var opts struct{ Tty bool }
+		json.NewDecoder(r.Body).Decode(&opts)
+
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		rw.WriteString("HTTP/1.1 101 UPGRADED\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+		rw.Flush()
+
+		stdin, _ := ioutil.ReadAll(rw)
+
+		if opts.Tty {
+			rw.WriteString(strings.ToUpper(string(stdin)))
+			rw.Flush()
+			return
+		}
+
+		rw.Write(frame(1, strings.ToUpper(string(stdin))))
+		rw.Write(frame(2, "warning"))
+		rw.Flush()
+	})
+	mux.HandleFunc("/v1.24/exec/exec1/json", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"Running":false,"ExitCode":3}`))
+	})
+
+	mux.HandleFunc("/v1.24/networks/create", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`{"message":"network with name iptb-default already exists"}`))
+	})
+	mux.HandleFunc("/v1.24/networks/iptb-default", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"Name":"iptb-default","Labels":{"iptb.testbed":"default"},"Containers":{"abc":{"Name":"iptb-default-0"}}}`))
+	})
+
+	return httptest.NewServer(mux)
+}
+
+func newTestClient(t *testing.T, srv *httptest.Server) *dockerClient {
+	dc, err := newDockerClient("tcp://" + srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dc
+}
+
+func TestClientContainerLifecycle(t *testing.T) {
+	srv := newStandInServer(t)
+	defer srv.Close()
+
+	dc := newTestClient(t, srv)
+	ctx := context.Background()
+
+	id, err := dc.createContainer(ctx, "", &containerConfig{
+		Image:      "ipfs/go-ipfs",
+		HostConfig: hostConfig{Binds: []string{"/tmp/0:/data/ipfs"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id != "abc" {
+		t.Fatalf("expected container id abc, got %s", id)
+	}
+
+	if err := dc.startContainer(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := dc.inspectContainer(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !info.State.Running {
+		t.Fatal("expected container to be running")
+	}
+
+	if err := dc.pauseContainer(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err = dc.inspectContainer(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !info.State.Paused {
+		t.Fatal("expected container to be paused")
+	}
+
+	if err := dc.unpauseContainer(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = dc.inspectContainer(ctx, "missing")
+	if !isNotFound(err) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+}
+
+func TestClientNetworks(t *testing.T) {
+	srv := newStandInServer(t)
+	defer srv.Close()
+
+	dc := newTestClient(t, srv)
+	ctx := context.Background()
+
+	// Creating a network which already exists is not an error
+	if err := dc.createNetwork(ctx, "iptb-default", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	nw, err := dc.inspectNetwork(ctx, "iptb-default")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if nw.Labels[labelTestbed] != "default" || len(nw.Containers) != 1 {
+		t.Fatalf("unexpected network: %+v", nw)
+	}
+
+	_, err = dc.inspectNetwork(ctx, "missing")
+	if !isNotFound(err) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+}
+
+func TestClientExec(t *testing.T) {
+	srv := newStandInServer(t)
+	defer srv.Close()
+
+	dc := newTestClient(t, srv)
+
+	stdout, stderr, code, err := dc.exec(context.Background(), "abc", []string{"ipfs", "add"}, nil, strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(stdout) != "HELLO" || string(stderr) != "warning" {
+		t.Fatalf("unexpected output: stdout %q stderr %q", stdout, stderr)
+	}
+
+	if code != 3 {
+		t.Fatalf("expected exit code 3, got %d", code)
+	}
+}
+
+func TestClientExecTTY(t *testing.T) {
+	srv := newStandInServer(t)
+	defer srv.Close()
+
+	dc := newTestClient(t, srv)
+
+	var out bytes.Buffer
+	code, err := dc.execTTY(context.Background(), "abc", []string{"/bin/sh"}, nil, strings.NewReader("exit"), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out.String() != "EXIT" || code != 3 {
+		t.Fatalf("unexpected output %q with exit code %d", out.String(), code)
+	}
+}
+
+func TestClientExecCancel(t *testing.T) {
+	srv := newStandInServer(t)
+	defer srv.Close()
+
+	dc := newTestClient(t, srv)
+
+	// The stand-in server waits for stdin, which is never closed
+	stdin, w := io.Pipe()
+	defer w.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, _, _, err := dc.exec(ctx, "abc", []string{"ipfs", "add"}, nil, stdin)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected the exec to be interrupted, got %v", err)
+	}
+}
+
+func TestClientLogs(t *testing.T) {
+	srv := newStandInServer(t)
+	defer srv.Close()
+
+	dc := newTestClient(t, srv)
+
+	for stderr, expected := range map[bool]string{false: "Daemon is ready\n", true: "daemon error\n"} {
+		r, err := dc.logs(context.Background(), "abc", stderr, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		out, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(out) != expected {
+			t.Errorf("expected %q, got %q", expected, out)
+		}
+	}
+}
+
+func TestDemux(t *testing.T) {
+	var in bytes.Buffer
+	in.Write(frame(1, "out1 "))
+	in.Write(frame(2, "err"))
+	in.Write(frame(1, "out2"))
+
+	var stdout, stderr bytes.Buffer
+	if err := demux(&in, &stdout, &stderr); err != nil {
+		t.Fatal(err)
+	}
+
+	if stdout.String() != "out1 out2" || stderr.String() != "err" {
+		t.Fatalf("unexpected output: stdout %q stderr %q", stdout.String(), stderr.String())
+	}
+}
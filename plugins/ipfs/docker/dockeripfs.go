@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -9,16 +8,13 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ipfs/go-cid"
-	config "github.com/ipfs/go-ipfs-config"
-	serial "github.com/ipfs/go-ipfs-config/serialize"
 	"github.com/multiformats/go-multiaddr"
 	"github.com/pkg/errors"
-	cnet "github.com/whyrusleeping/go-ctrlnet"
 
 	"github.com/ipfs/iptb/plugins/ipfs"
 	"github.com/ipfs/iptb/testbed/interfaces"
@@ -30,7 +26,21 @@ var ErrTimeout = errors.New("timeout")
 var PluginName = "dockeripfs"
 
 const (
-	attrIfName = "ifname"
+	attrIfName      = "ifname"
+	attrNetwork     = "network"
+	attrContainer   = "container"
+	attrIP          = "ip"
+	attrImage       = "image"
+	attrRepoBuilder = "repobuilder"
+	attrDockerHost  = "dockerhost"
+)
+
+// Labels attached to the containers and networks created by the plugin
+const (
+	labelTestbed = "iptb.testbed"
+	labelNode    = "iptb.node"
+	labelPlugin  = "iptb.plugin"
+	labelDir     = "iptb.dir"
 )
 
 type DockerIpfs struct {
@@ -39,9 +49,22 @@ type DockerIpfs struct {
 	dir         string
 	repobuilder string
 	peerid      *cid.Cid
+	peeridLk    sync.Mutex
 	apiaddr     multiaddr.Multiaddr
-	swarmaddr   multiaddr.Multiaddr
+	swarmaddrs  []string
+	transport   string
+	gatewayaddr multiaddr.Multiaddr
+	swarmkey    string
+	profile     string
+	configpatch string
 	mdns        bool
+	testbed     string
+	index       string
+	network     string
+	limits      ipfs.Limits
+	netem       *ipfs.Netem
+	client      *dockerClient
+	spec        map[string]string
 }
 
 var NewNode testbedi.NewNodeFunc
@@ -58,18 +81,13 @@ func init() {
 			return nil, err
 		}
 
-		swarmaddr, err := multiaddr.NewMultiaddr("/ip4/0.0.0.0/tcp/4001")
-		if err != nil {
-			return nil, err
-		}
-
 		var repobuilder string
 
-		if v, ok := attrs["image"]; ok {
+		if v, ok := attrs[attrImage]; ok {
 			imagename = v
 		}
 
-		if v, ok := attrs["repobuilder"]; ok {
+		if v, ok := attrs[attrRepoBuilder]; ok {
 			repobuilder = v
 		} else {
 			ipfspath, err := exec.LookPath("ipfs")
@@ -89,37 +107,116 @@ func init() {
 			}
 		}
 
-		if swarmaddrstr, ok := attrs["swarmaddr"]; ok {
-			var err error
-			swarmaddr, err = multiaddr.NewMultiaddr(swarmaddrstr)
+		swarmaddrs, err := ipfs.SwarmListenAddrs(attrs, "/ip4/0.0.0.0/tcp/4001")
+		if err != nil {
+			return nil, err
+		}
 
-			if err != nil {
-				return nil, err
-			}
+		transport := ipfs.DefaultTransport
+		if v, ok := attrs["transport"]; ok {
+			transport = v
 		}
 
 		if _, ok := attrs["mdns"]; ok {
 			mdns = true
 		}
 
+		if err := ipfs.CheckProfile(attrs["profile"]); err != nil {
+			return nil, err
+		}
+
+		client, err := newDockerClient(attrs[attrDockerHost])
+		if err != nil {
+			return nil, err
+		}
+
+		// Nodes live in <root>/testbeds/<testbed>/<index>
+		testbed := filepath.Base(filepath.Dir(dir))
+		index := filepath.Base(dir)
+
+		network := dockerName("iptb", testbed)
+		if v, ok := attrs[attrNetwork]; ok {
+			network = v
+		}
+
+		limits, err := ipfs.ParseLimits(attrs)
+		if err != nil {
+			return nil, err
+		}
+
+		netem, err := ipfs.LoadNetem(dir, attrs)
+		if err != nil {
+			return nil, err
+		}
+
+		var gatewayaddr multiaddr.Multiaddr
+		if gatewayaddrstr, ok := attrs["gatewayaddr"]; ok {
+			var err error
+			gatewayaddr, err = multiaddr.NewMultiaddr(gatewayaddrstr)
+
+			if err != nil {
+				return nil, err
+			}
+		}
+
 		return &DockerIpfs{
 			dir:         dir,
 			image:       imagename,
 			repobuilder: repobuilder,
 			apiaddr:     apiaddr,
-			swarmaddr:   swarmaddr,
+			swarmaddrs:  swarmaddrs,
+			transport:   transport,
+			gatewayaddr: gatewayaddr,
+			swarmkey:    attrs["swarmkey"],
+			profile:     attrs["profile"],
+			configpatch: attrs["configpatch"],
 			mdns:        mdns,
+			testbed:     testbed,
+			index:       index,
+			network:     network,
+			limits:      limits,
+			netem:       netem,
+			client:      client,
+			spec:        attrs,
 		}, nil
 	}
 
 	GetAttrList = func() []string {
-		return append(ipfs.GetAttrList(), attrIfName)
+		attrs := append(ipfs.GetAttrList(), attrIfName, attrNetwork, attrContainer, attrIP)
+		attrs = append(attrs, attrImage, attrRepoBuilder, attrDockerHost)
+		attrs = append(attrs, ipfs.LimitAttrList()...)
+		attrs = append(attrs, ipfs.LinkAttrList()...)
+		return append(attrs, ipfs.SpecAttrList()...)
 	}
 
 	GetAttrDesc = func(attr string) (string, error) {
 		switch attr {
 		case attrIfName:
 			return "docker ifname", nil
+		case attrNetwork:
+			return "docker network shared by the testbed", nil
+		case attrContainer:
+			return "docker container name", nil
+		case attrIP:
+			return "container ip on the testbed network", nil
+		case attrImage:
+			return "docker image of the container", nil
+		case attrRepoBuilder:
+			return "ipfs binary initializing the repo on the host", nil
+		case attrDockerHost:
+			return "docker daemon address", nil
+		}
+
+		if ipfs.IsLimitAttr(attr) {
+			return ipfs.LimitAttrDesc(attr)
+		}
+
+		if ipfs.IsLinkAttr(attr) {
+			return ipfs.LinkAttrDesc(attr)
+		}
+
+		if ipfs.IsSpecAttr(attr) {
+			return ipfs.SpecAttrDesc(attr)
 		}
 
 		return ipfs.GetAttrDesc(attr)
@@ -142,34 +239,50 @@ func (l *DockerIpfs) Init(ctx context.Context, agrs ...string) (testbedi.Output,
 		return nil, fmt.Errorf("error getting env: %s", err)
 	}
 
-	cmd := exec.CommandContext(ctx, l.repobuilder, "init")
+	initargs := []string{"init"}
+	if l.profile != "" {
+		initargs = append(initargs, "--profile="+l.profile)
+	}
+
+	cmd := exec.CommandContext(ctx, l.repobuilder, initargs...)
 	cmd.Env = env
 	out, err := cmd.CombinedOutput()
 	if err != nil {
 		return nil, fmt.Errorf("%s: %s", err, string(out))
 	}
 
-	icfg, err := l.Config()
+	cfg, err := ipfs.ReadConfig(l.dir)
 	if err != nil {
 		return nil, err
 	}
 
-	lcfg, ok := icfg.(*config.Config)
-	if !ok {
-		return nil, fmt.Errorf("Error: Config() is not an ipfs config")
+	gatewayaddr := ""
+	if l.gatewayaddr != nil {
+		gatewayaddr = l.gatewayaddr.String()
 	}
 
-	lcfg.Bootstrap = nil
-	lcfg.Addresses.Swarm = []string{l.swarmaddr.String()}
-	lcfg.Addresses.API = l.apiaddr.String()
-	lcfg.Addresses.Gateway = ""
-	lcfg.Discovery.MDNS.Enabled = l.mdns
+	err = ipfs.InitConfig(cfg, l.swarmaddrs, l.apiaddr.String(), gatewayaddr, l.mdns)
+	if err != nil {
+		return nil, err
+	}
 
-	err = l.WriteConfig(lcfg)
+	err = l.WriteConfig(cfg)
 	if err != nil {
 		return nil, err
 	}
 
+	if l.swarmkey != "" {
+		if err := ipfs.InstallSwarmKey(l.swarmkey, l.dir); err != nil {
+			return nil, err
+		}
+	}
+
+	if l.configpatch != "" {
+		if err := ipfs.ApplyConfigPatch(l, l.configpatch); err != nil {
+			return nil, err
+		}
+	}
+
 	return nil, err
 }
 
@@ -183,122 +296,219 @@ func (l *DockerIpfs) Start(ctx context.Context, wait bool, args ...string) (test
 		return nil, fmt.Errorf("node is already running")
 	}
 
-	fargs := []string{"run", "-d", "-v", l.dir + ":/data/ipfs", l.image}
-	fargs = append(fargs, args...)
-	cmd := exec.Command("docker", fargs...)
-	out, err := cmd.CombinedOutput()
+	if err := l.ensureNetwork(ctx); err != nil {
+		return nil, err
+	}
+
+	// A container left behind by a node which was not stopped cleanly still
+	// holds on to the name
+	err = l.client.removeContainer(ctx, l.containerName(), true)
+	if err != nil && !isNotFound(err) {
+		return nil, err
+	}
+
+	cfg := &containerConfig{
+		Image:  l.image,
+		Cmd:    args,
+		Labels: l.labels(),
+		HostConfig: hostConfig{
+			Binds:       []string{l.dir + ":/data/ipfs"},
+			NetworkMode: l.network,
+			resources:   limitsToResources(l.limits),
+		},
+	}
+
+	id, err := l.client.createContainer(ctx, l.containerName(), cfg)
 	if err != nil {
-		return nil, fmt.Errorf("%s: %s", err, string(out))
+		return nil, err
 	}
 
-	id := bytes.TrimSpace(out)
-	l.id = string(id)
+	l.id = id
 
 	idfile := filepath.Join(l.dir, "dockerid")
-	err = ioutil.WriteFile(idfile, id, 0664)
+	err = ioutil.WriteFile(idfile, []byte(id), 0664)
+	if err != nil {
+		return nil, err
+	}
 
+	// Subscribe before starting, so an early crash is not missed
+	evctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	events, err := l.client.events(evctx, id)
 	if err != nil {
-		killErr := l.killContainer()
-		if killErr != nil {
-			return nil, combineErrors(err, killErr)
-		}
 		return nil, err
 	}
 
+	if err := l.client.startContainer(ctx, id); err != nil {
+		return nil, err
+	}
+
+	// The container gets a new interface, shaped by the settings of the spec
+	if err := l.netem.Restarted(); err != nil {
+		return nil, err
+	}
+
+	if !l.netem.IsZero() {
+		ifn, err := l.getInterfaceName()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := l.netem.Apply(ifn); err != nil {
+			return nil, err
+		}
+	}
+
+	if wait {
+		if err := l.waitOnDaemon(ctx, events); err != nil {
+			return nil, err
+		}
+	}
+
 	return nil, nil
 }
 
 func (l *DockerIpfs) Stop(ctx context.Context) error {
-	err := l.killContainer()
+	id, err := l.getID()
 	if err != nil {
 		return err
 	}
-	return os.Remove(filepath.Join(l.dir, "dockerid"))
-}
 
-func (l *DockerIpfs) RunCmd(ctx context.Context, stdin io.Reader, args ...string) (testbedi.Output, error) {
-	id, err := l.getID()
+	// A paused container can not be signalled
+	info, err := l.client.inspectContainer(ctx, id)
+	if err == nil && info.State.Paused {
+		if err := l.client.unpauseContainer(ctx, id); err != nil {
+			return err
+		}
+	}
+
+	err = l.killContainer()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	if stdin != nil {
-		args = append([]string{"exec", "-i", id}, args...)
-	} else {
-		args = append([]string{"exec", id}, args...)
+	// Give the daemon time to shut down, the forced removal below takes care
+	// of one which does not
+	wctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	l.client.waitContainer(wctx, id)
+	cancel()
+
+	err = l.client.removeContainer(ctx, id, true)
+	if err != nil && !isNotFound(err) {
+		return err
 	}
 
-	cmd := exec.CommandContext(ctx, "docker", args...)
-	cmd.Stdin = stdin
+	l.id = ""
+	return os.Remove(filepath.Join(l.dir, "dockerid"))
+}
 
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return nil, err
+// Destroy removes the container of the node, and the testbed network once
+// the last container attached to it is gone
+func (l *DockerIpfs) Destroy(ctx context.Context) error {
+	err := l.client.removeContainer(ctx, l.containerName(), true)
+	if err != nil && !isNotFound(err) {
+		return err
 	}
 
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		return nil, err
+	l.id = ""
+	if err := os.Remove(filepath.Join(l.dir, "dockerid")); err != nil && !os.IsNotExist(err) {
+		return err
 	}
 
-	err = cmd.Start()
-	if err != nil {
-		return nil, err
+	nw, err := l.client.inspectNetwork(ctx, l.network)
+	if isNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
 	}
 
-	stderrbytes, err := ioutil.ReadAll(stderr)
-	if err != nil {
-		return nil, err
+	// Networks which were not created by iptb are left alone
+	if len(nw.Containers) != 0 || nw.Labels[labelTestbed] == "" {
+		return nil
 	}
 
-	stdoutbytes, err := ioutil.ReadAll(stdout)
-	if err != nil {
-		return nil, err
+	return l.client.removeNetwork(ctx, l.network)
+}
+
+// Fault pauses, unpauses or kills the container of the node. The container
+// of a killed node is kept, along with its logs, until the node starts again.
+func (l *DockerIpfs) Fault(ctx context.Context, fault string) error {
+	id, err := l.getID()
+	if os.IsNotExist(err) {
+		return fmt.Errorf("node is not running")
+	} else if err != nil {
+		return err
 	}
 
-	if err != nil {
-		return nil, err
+	switch fault {
+	case testbedi.FaultPause:
+		return l.client.pauseContainer(ctx, id)
+	case testbedi.FaultResume:
+		return l.client.unpauseContainer(ctx, id)
+	case testbedi.FaultKill:
+		return l.client.killContainer(ctx, id, "SIGKILL")
 	}
 
-	exiterr := cmd.Wait()
+	return fmt.Errorf("%s is not supported by %s nodes", fault, PluginName)
+}
 
-	var exitcode = 0
-	switch oerr := exiterr.(type) {
-	case *exec.ExitError:
-		if ctx.Err() == context.DeadlineExceeded {
-			err = errors.Wrapf(oerr, "context deadline exceeded for command: %q", strings.Join(cmd.Args, " "))
-		}
+// Status reports whether the container of the node is running, stopped or
+// paused
+func (l *DockerIpfs) Status(ctx context.Context) (string, error) {
+	id, err := l.getID()
+	if os.IsNotExist(err) {
+		return testbedi.StatusStopped, nil
+	} else if err != nil {
+		return "", err
+	}
+
+	info, err := l.client.inspectContainer(ctx, id)
+	if isNotFound(err) {
+		return testbedi.StatusStopped, nil
+	} else if err != nil {
+		return "", err
+	}
 
-		exitcode = 1
-	case nil:
-		err = oerr
+	switch {
+	case info.State.Paused:
+		return testbedi.StatusPaused, nil
+	case info.State.Running:
+		return testbedi.StatusRunning, nil
 	}
 
-	return iptbutil.NewOutput(args, stdoutbytes, stderrbytes, exitcode, err), nil
+	return testbedi.StatusStopped, nil
 }
 
-func (l *DockerIpfs) Connect(ctx context.Context, n testbedi.Core) error {
-	swarmaddrs, err := n.SwarmAddrs()
+func (l *DockerIpfs) RunCmd(ctx context.Context, stdin io.Reader, args ...string) (testbedi.Output, error) {
+	id, err := l.getID()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	output, err := l.RunCmd(ctx, nil, "ipfs", "swarm", "connect", swarmaddrs[1])
-
+	stdout, stderr, exitcode, err := l.client.exec(ctx, id, args, nil, stdin)
 	if err != nil {
-		return err
+		if ctx.Err() == context.DeadlineExceeded {
+			err = errors.Wrapf(err, "context deadline exceeded for command: %q", strings.Join(args, " "))
+		}
+
+		return nil, err
 	}
 
-	if output.ExitCode() != 0 {
-		out, err := ioutil.ReadAll(output.Stderr())
-		if err != nil {
-			return err
-		}
+	return iptbutil.NewOutput(args, stdout, stderr, exitcode, nil), nil
+}
+
+func (l *DockerIpfs) Connect(ctx context.Context, n testbedi.Core) error {
+	_, err := l.ConnectWith(ctx, n, testbedi.ConnectOptions{})
+	return err
+}
 
-		return fmt.Errorf("%s", string(out))
+func (l *DockerIpfs) ConnectWith(ctx context.Context, n testbedi.Core, opts testbedi.ConnectOptions) (testbedi.Output, error) {
+	if opts.Transport == "" {
+		opts.Transport = l.transport
 	}
 
-	return nil
+	return ipfs.Connect(ctx, l, n, opts)
 }
 
 func (l *DockerIpfs) Shell(ctx context.Context, nodes []testbedi.Core) error {
@@ -318,18 +528,47 @@ func (l *DockerIpfs) Shell(ctx context.Context, nodes []testbedi.Core) error {
 		nenvs = append(nenvs, fmt.Sprintf("NODE%d=%s", i, peerid))
 	}
 
-	args := []string{"exec", "-it"}
-	for _, e := range nenvs {
-		args = append(args, "-e", e)
+	// The shell gets a pseudo terminal of the daemon of the node, the local
+	// terminal passes keystrokes through untouched while it runs
+	restore, err := rawTerminal()
+	if err != nil {
+		return err
 	}
+	defer restore()
 
-	args = append(args, id, "/bin/sh")
-	cmd := exec.Command("docker", args...)
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
-	cmd.Stdin = os.Stdin
+	code, err := l.client.execTTY(ctx, id, []string{"/bin/sh"}, nenvs, os.Stdin, os.Stdout)
+	if err != nil {
+		return err
+	}
 
-	return cmd.Run()
+	if code != 0 {
+		return fmt.Errorf("shell exited with code %d", code)
+	}
+
+	return nil
+}
+
+// rawTerminal puts the terminal of stdin in raw mode, returning a function
+// restoring its previous state
+func rawTerminal() (func(), error) {
+	stty := func(args ...string) ([]byte, error) {
+		cmd := exec.Command("stty", args...)
+		cmd.Stdin = os.Stdin
+		return cmd.Output()
+	}
+
+	state, err := stty("-g")
+	if err != nil {
+		return nil, fmt.Errorf("stdin is not a terminal: %s", err)
+	}
+
+	if _, err := stty("raw", "-echo"); err != nil {
+		return nil, err
+	}
+
+	return func() {
+		stty(strings.TrimSpace(string(state)))
+	}, nil
 }
 
 func (l *DockerIpfs) String() string {
@@ -345,7 +584,31 @@ func (l *DockerIpfs) APIAddr() (string, error) {
 }
 
 func (l *DockerIpfs) SwarmAddrs() ([]string, error) {
-	return ipfs.SwarmAddrs(l)
+	// Loopback addresses refer to the container itself
+	addrs, err := ipfs.SwarmAddrs(l, false)
+	if err != nil {
+		return nil, err
+	}
+
+	ip, err := l.containerIP()
+	if err != nil {
+		return nil, err
+	}
+
+	// Other nodes reach the container through the testbed network, addresses
+	// on other networks the container may be attached to are skipped
+	var out []string
+	for _, addr := range addrs {
+		if parts := strings.Split(addr, "/"); len(parts) > 2 && parts[2] == ip {
+			out = append(out, addr)
+		}
+	}
+
+	if len(out) == 0 {
+		return nil, fmt.Errorf("node %s has no swarm address on network %s", l.containerName(), l.network)
+	}
+
+	return out, nil
 }
 
 func (l *DockerIpfs) Dir() string {
@@ -353,6 +616,10 @@ func (l *DockerIpfs) Dir() string {
 }
 
 func (l *DockerIpfs) PeerID() (string, error) {
+	// Nodes are shared by the goroutines of commands such as churn
+	l.peeridLk.Lock()
+	defer l.peeridLk.Unlock()
+
 	if l.peerid != nil {
 		return l.peerid.String(), nil
 	}
@@ -407,6 +674,30 @@ func (l *DockerIpfs) Attr(attr string) (string, error) {
 	switch attr {
 	case attrIfName:
 		return l.getInterfaceName()
+	case attrNetwork:
+		return l.network, nil
+	case attrContainer:
+		return l.containerName(), nil
+	case attrIP:
+		return l.containerIP()
+	case attrImage:
+		return l.image, nil
+	case attrRepoBuilder:
+		return l.repobuilder, nil
+	case attrDockerHost:
+		return ipfs.SpecAttr(l.spec, attr)
+	}
+
+	if ipfs.IsLimitAttr(attr) {
+		return l.limit(attr)
+	}
+
+	if ipfs.IsLinkAttr(attr) {
+		return l.netem.Get(attr)
+	}
+
+	if ipfs.IsSpecAttr(attr) {
+		return ipfs.SpecAttr(l.spec, attr)
 	}
 
 	return ipfs.GetAttr(l, attr)
@@ -414,33 +705,55 @@ func (l *DockerIpfs) Attr(attr string) (string, error) {
 
 func (l *DockerIpfs) SetAttr(attr string, val string) error {
 	switch attr {
-	case "latency":
-		return l.setLatency(val)
-	case "bandwidth":
-		return l.setBandwidth(val)
-	case "jitter":
-		return l.setJitter(val)
-	case "loss":
-		return l.setPacketLoss(val)
+	case ipfs.AttrLatency, ipfs.AttrBandwidth, ipfs.AttrJitter, ipfs.AttrLoss, ipfs.AttrNetem:
+		ifn, err := l.getInterfaceName()
+		if err != nil {
+			return err
+		}
+
+		return l.netem.Set(ifn, attr, val)
+	case ipfs.AttrCPU, ipfs.AttrMemory, ipfs.AttrPids, ipfs.AttrNoFile:
+		return l.setLimit(attr, val)
 	default:
 		return fmt.Errorf("no attribute named: %s", attr)
 	}
 }
 
+// ShapeLinks shapes the traffic the node receives from each of peers, on top
+// of its network emulation settings
+func (l *DockerIpfs) ShapeLinks(ctx context.Context, peers []testbedi.LinkPeer) error {
+	ifn, err := l.getInterfaceName()
+	if err != nil {
+		return err
+	}
+
+	return l.netem.ShapeLinks(ifn, peers)
+}
+
 func (l *DockerIpfs) StderrReader() (io.ReadCloser, error) {
-	return nil, fmt.Errorf("Not implemented")
+	id, err := l.getID()
+	if err != nil {
+		return nil, err
+	}
+
+	return l.client.logs(context.TODO(), id, true, false)
 }
 
 func (l *DockerIpfs) StdoutReader() (io.ReadCloser, error) {
-	return nil, fmt.Errorf("Not implemented")
+	id, err := l.getID()
+	if err != nil {
+		return nil, err
+	}
+
+	return l.client.logs(context.TODO(), id, false, false)
 }
 
 func (l *DockerIpfs) Config() (interface{}, error) {
-	return serial.Load(filepath.Join(l.dir, "config"))
+	return ipfs.ReadConfig(l.dir)
 }
 
 func (l *DockerIpfs) WriteConfig(cfg interface{}) error {
-	return serial.WriteConfigFile(filepath.Join(l.dir, "config"), cfg)
+	return ipfs.WriteConfig(l.dir, cfg)
 }
 
 func (l *DockerIpfs) Type() string {
@@ -464,8 +777,110 @@ func (l *DockerIpfs) getID() (string, error) {
 	return string(b), nil
 }
 
+// containerName returns the name of the container, iptb-<testbed>-<index>
+func (l *DockerIpfs) containerName() string {
+	return dockerName("iptb", l.testbed, l.index)
+}
+
+func (l *DockerIpfs) labels() map[string]string {
+	return map[string]string{
+		labelTestbed: l.testbed,
+		labelNode:    l.index,
+		labelPlugin:  PluginName,
+		labelDir:     l.dir,
+	}
+}
+
+// ensureNetwork creates the testbed network if it does not exist yet
+func (l *DockerIpfs) ensureNetwork(ctx context.Context) error {
+	_, err := l.client.inspectNetwork(ctx, l.network)
+	if !isNotFound(err) {
+		return err
+	}
+
+	return l.client.createNetwork(ctx, l.network, map[string]string{
+		labelTestbed: l.testbed,
+		labelPlugin:  PluginName,
+	})
+}
+
+// containerIP returns the address of the container on the testbed network
+func (l *DockerIpfs) containerIP() (string, error) {
+	id, err := l.getID()
+	if err != nil {
+		return "", err
+	}
+
+	info, err := l.client.inspectContainer(context.TODO(), id)
+	if err != nil {
+		return "", err
+	}
+
+	ep, ok := info.NetworkSettings.Networks[l.network]
+	if !ok || ep.IPAddress == "" {
+		return "", fmt.Errorf("container %s is not attached to network %s", l.containerName(), l.network)
+	}
+
+	return ep.IPAddress, nil
+}
+
+// dockerName joins parts into a valid container or network name, characters
+// docker does not allow are replaced with a dash
+func dockerName(parts ...string) string {
+	name := []byte(strings.Join(parts, "-"))
+	for i, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '_', c == '.', c == '-':
+		default:
+			name[i] = '-'
+		}
+	}
+
+	return string(name)
+}
+
 func (l *DockerIpfs) isAlive() (bool, error) {
-	return false, nil
+	id, err := l.getID()
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	info, err := l.client.inspectContainer(context.TODO(), id)
+	if isNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return info.State.Running, nil
+}
+
+// waitOnDaemon waits for the daemon to accept commands, failing early if
+// the container exits in the meantime
+func (l *DockerIpfs) waitOnDaemon(ctx context.Context, events <-chan dockerEvent) error {
+	for i := 0; i < 50; i++ {
+		out, err := l.RunCmd(ctx, nil, "ipfs", "id")
+		if err == nil && out.ExitCode() == 0 {
+			return nil
+		}
+
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				events = nil
+			} else if ev.Action == "die" {
+				return fmt.Errorf("container %s exited with code %s", l.id, ev.Actor.Attributes["exitCode"])
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Millisecond * 400):
+		}
+	}
+
+	return fmt.Errorf("node %s failed to come online in given time period", l.id)
 }
 
 func (l *DockerIpfs) env() ([]string, error) {
@@ -486,125 +901,171 @@ func (l *DockerIpfs) killContainer() error {
 	if err != nil {
 		return err
 	}
-	out, err := exec.Command("docker", "kill", "--signal=INT", id).CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("%s: %s", err, string(out))
-	}
-	return nil
+
+	return l.client.killContainer(context.TODO(), id, "SIGINT")
 }
 
-func (l *DockerIpfs) getInterfaceName() (string, error) {
-	out, err := l.RunCmd(context.TODO(), nil, "ip", "link")
+// limit returns the effective limit attr of the running container, or the
+// configured one when the node is not running
+func (l *DockerIpfs) limit(attr string) (string, error) {
+	alive, err := l.isAlive()
 	if err != nil {
 		return "", err
 	}
 
-	stdout, err := ioutil.ReadAll(out.Stdout())
+	if !alive {
+		return l.limits.Get(attr)
+	}
+
+	id, err := l.getID()
 	if err != nil {
 		return "", err
 	}
 
-	var cside string
-	for _, l := range strings.Split(string(stdout), "\n") {
-		if strings.Contains(l, "@if") {
-			ifnum := strings.Split(strings.Split(l, " ")[1], "@")[1]
-			cside = ifnum[2 : len(ifnum)-1]
-			break
-		}
+	info, err := l.client.inspectContainer(context.TODO(), id)
+	if err != nil {
+		return "", err
 	}
 
-	if cside == "" {
-		return "", fmt.Errorf("container-side interface not found")
+	return resourcesToLimits(info.HostConfig.resources).Get(attr)
+}
+
+// setLimit changes a limit of the node, updating the running container.
+// Docker only allows changing the cpu and memory limits of a running container.
+func (l *DockerIpfs) setLimit(attr, val string) error {
+	limits := l.limits
+	if err := limits.Set(attr, val); err != nil {
+		return err
 	}
 
-	localout, err := exec.Command("ip", "link").CombinedOutput()
+	alive, err := l.isAlive()
 	if err != nil {
-		return "", fmt.Errorf("%s: %s", err, localout)
+		return err
 	}
 
-	for _, l := range strings.Split(string(localout), "\n") {
-		if strings.HasPrefix(l, cside+": ") {
-			return strings.Split(strings.Fields(l)[1], "@")[0], nil
-		}
+	// A stopped node picks the limit up on start, when saved to the spec
+	if !alive {
+		l.limits = limits
+		return nil
 	}
 
-	return "", fmt.Errorf("could not determine interface")
-}
+	if attr != ipfs.AttrCPU && attr != ipfs.AttrMemory {
+		return fmt.Errorf("%s can only be changed while the node is stopped", attr)
+	}
 
-func (l *DockerIpfs) setLatency(val string) error {
-	dur, err := time.ParseDuration(val)
-	if err != nil {
-		return err
+	res := limitsToResources(limits)
+	res.PidsLimit, res.Ulimits = 0, nil
+
+	// Zero values are ignored by docker, -1 removes the limit
+	if limits.CPU == 0 {
+		res.CPUPeriod, res.CPUQuota = cpuPeriod, -1
 	}
 
-	ifn, err := l.getInterfaceName()
+	if limits.Memory == 0 {
+		res.Memory, res.MemorySwap = -1, -1
+	}
+
+	id, err := l.getID()
 	if err != nil {
 		return err
 	}
 
-	settings := &cnet.LinkSettings{
-		Latency: uint(dur.Nanoseconds() / 1000000),
+	if err := l.client.updateContainer(context.TODO(), id, res); err != nil {
+		return err
 	}
 
-	return cnet.SetLink(ifn, settings)
+	l.limits = limits
+	return nil
 }
 
-func (l *DockerIpfs) setJitter(val string) error {
-	dur, err := time.ParseDuration(val)
-	if err != nil {
-		return err
+// cpuPeriod is the cfs scheduler period, in microseconds, used to express cpu limits
+const cpuPeriod = 100000
+
+func limitsToResources(limits ipfs.Limits) resources {
+	var res resources
+
+	if limits.CPU != 0 {
+		res.CPUPeriod = cpuPeriod
+		res.CPUQuota = int64(limits.CPU * cpuPeriod)
 	}
 
-	ifn, err := l.getInterfaceName()
-	if err != nil {
-		return err
+	if limits.Memory != 0 {
+		// Swap is disabled, so the memory limit bounds the daemon
+		res.Memory = limits.Memory
+		res.MemorySwap = limits.Memory
 	}
 
-	settings := &cnet.LinkSettings{
-		Jitter: uint(dur.Nanoseconds() / 1000000),
+	res.PidsLimit = limits.Pids
+
+	if limits.NoFile != 0 {
+		res.Ulimits = []ulimit{{
+			Name: "nofile",
+			Soft: int64(limits.NoFile),
+			Hard: int64(limits.NoFile),
+		}}
 	}
 
-	return cnet.SetLink(ifn, settings)
+	return res
 }
 
-// set bandwidth (expects Mbps)
-func (l *DockerIpfs) setBandwidth(val string) error {
-	bw, err := strconv.ParseFloat(val, 32)
-	if err != nil {
-		return err
+func resourcesToLimits(res resources) ipfs.Limits {
+	var limits ipfs.Limits
+
+	if res.CPUPeriod > 0 && res.CPUQuota > 0 {
+		limits.CPU = float64(res.CPUQuota) / float64(res.CPUPeriod)
 	}
 
-	ifn, err := l.getInterfaceName()
-	if err != nil {
-		return err
+	if res.Memory > 0 {
+		limits.Memory = res.Memory
+	}
+
+	if res.PidsLimit > 0 {
+		limits.Pids = res.PidsLimit
 	}
 
-	settings := &cnet.LinkSettings{
-		Bandwidth: uint(bw * 1000000),
+	for _, u := range res.Ulimits {
+		if u.Name == "nofile" && u.Soft > 0 {
+			limits.NoFile = uint64(u.Soft)
+		}
 	}
 
-	return cnet.SetLink(ifn, settings)
+	return limits
 }
 
-// set packet loss percentage (dropped / total)
-func (l *DockerIpfs) setPacketLoss(val string) error {
-	ratio, err := strconv.ParseUint(val, 10, 8)
+func (l *DockerIpfs) getInterfaceName() (string, error) {
+	out, err := l.RunCmd(context.TODO(), nil, "ip", "link")
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	ifn, err := l.getInterfaceName()
+	stdout, err := ioutil.ReadAll(out.Stdout())
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	settings := &cnet.LinkSettings{
-		PacketLoss: uint8(ratio),
+	var cside string
+	for _, l := range strings.Split(string(stdout), "\n") {
+		if strings.Contains(l, "@if") {
+			ifnum := strings.Split(strings.Split(l, " ")[1], "@")[1]
+			cside = ifnum[2 : len(ifnum)-1]
+			break
+		}
 	}
 
-	return cnet.SetLink(ifn, settings)
-}
+	if cside == "" {
+		return "", fmt.Errorf("container-side interface not found")
+	}
 
-func combineErrors(err1, err2 error) error {
-	return fmt.Errorf("%v\nwhile handling the above error, the following error occurred:\n%v", err1, err2)
+	localout, err := exec.Command("ip", "link").CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", err, localout)
+	}
+
+	for _, l := range strings.Split(string(localout), "\n") {
+		if strings.HasPrefix(l, cside+": ") {
+			return strings.Split(strings.Fields(l)[1], "@")[0], nil
+		}
+	}
+
+	return "", fmt.Errorf("could not determine interface")
 }
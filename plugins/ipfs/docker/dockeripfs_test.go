@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ipfs/iptb/plugins/ipfs"
+)
+
+func TestDockerName(t *testing.T) {
+	cases := map[string][]string{
+		"iptb-default-0":  {"iptb", "default", "0"},
+		"iptb-my-bed-12":  {"iptb", "my bed", "12"},
+		"iptb-bed.v2_a-3": {"iptb", "bed.v2_a", "3"},
+		"iptb-b-d--1":     {"iptb", "b/d:", "1"},
+		"iptb-default":    {"iptb", "default"},
+	}
+
+	for expected, parts := range cases {
+		if name := dockerName(parts...); name != expected {
+			t.Errorf("dockerName(%q): expected %s, got %s", parts, expected, name)
+		}
+	}
+}
+
+func TestLimitsResources(t *testing.T) {
+	limits := ipfs.Limits{CPU: 1.5, Memory: 512 << 20, Pids: 100, NoFile: 4096}
+
+	res := limitsToResources(limits)
+	if res.CPUPeriod != cpuPeriod || res.CPUQuota != 150000 {
+		t.Fatalf("unexpected cpu resources: %+v", res)
+	}
+
+	if res.Memory != 512<<20 || res.MemorySwap != res.Memory || res.PidsLimit != 100 {
+		t.Fatalf("unexpected resources: %+v", res)
+	}
+
+	if got := resourcesToLimits(res); got != limits {
+		t.Fatalf("expected %+v, got %+v", limits, got)
+	}
+
+	if got := resourcesToLimits(limitsToResources(ipfs.Limits{})); !got.IsZero() {
+		t.Fatalf("expected no limits, got %+v", got)
+	}
+}
@@ -0,0 +1,152 @@
+package ipfs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ipfs/iptb/util"
+)
+
+// Resource limit attributes
+const (
+	AttrCPU    = "cpu"
+	AttrMemory = "memory"
+	AttrPids   = "pids"
+	AttrNoFile = "nofile"
+)
+
+// Unlimited is reported for limits which are not set
+const Unlimited = "max"
+
+// Limits are the resource limits of a node, a zero value means unlimited
+type Limits struct {
+	// CPU is the number of cpus the node may use, fractions are allowed
+	CPU float64
+	// Memory is the maximum memory usage in bytes
+	Memory int64
+	// Pids is the maximum number of processes and threads
+	Pids int64
+	// NoFile is the maximum number of open file descriptors
+	NoFile uint64
+}
+
+// LimitAttrList returns the resource limit attributes
+func LimitAttrList() []string {
+	return []string{AttrCPU, AttrMemory, AttrPids, AttrNoFile}
+}
+
+// IsLimitAttr reports whether attr is a resource limit attribute
+func IsLimitAttr(attr string) bool {
+	for _, a := range LimitAttrList() {
+		if a == attr {
+			return true
+		}
+	}
+
+	return false
+}
+
+// LimitAttrDesc returns the description of the resource limit attribute attr
+func LimitAttrDesc(attr string) (string, error) {
+	switch attr {
+	case AttrCPU:
+		return "number of cpus the node may use (e.g. 0.5, 2)", nil
+	case AttrMemory:
+		return "memory limit (e.g. 512m, 1GiB)", nil
+	case AttrPids:
+		return "maximum number of processes and threads", nil
+	case AttrNoFile:
+		return "maximum number of open file descriptors", nil
+	}
+
+	return "", fmt.Errorf("unrecognized attribute")
+}
+
+// ParseLimits reads the resource limit attributes from attrs
+func ParseLimits(attrs map[string]string) (Limits, error) {
+	var l Limits
+	for _, attr := range LimitAttrList() {
+		v, ok := attrs[attr]
+		if !ok {
+			continue
+		}
+
+		if err := l.Set(attr, v); err != nil {
+			return l, err
+		}
+	}
+
+	return l, nil
+}
+
+// Set parses val and sets the limit attr. An empty value, `0` or `max`
+// removes the limit.
+func (l *Limits) Set(attr, val string) error {
+	val = strings.TrimSpace(val)
+	if val == "" || val == Unlimited {
+		val = "0"
+	}
+
+	var err error
+	switch attr {
+	case AttrCPU:
+		var cpu float64
+		cpu, err = strconv.ParseFloat(val, 64)
+		if err == nil && cpu < 0 {
+			err = fmt.Errorf("must not be negative")
+		}
+		l.CPU = cpu
+	case AttrMemory:
+		l.Memory, err = iptbutil.ParseBytes(val)
+	case AttrPids:
+		var pids int64
+		pids, err = strconv.ParseInt(val, 10, 64)
+		if err == nil && pids < 0 {
+			err = fmt.Errorf("must not be negative")
+		}
+		l.Pids = pids
+	case AttrNoFile:
+		l.NoFile, err = strconv.ParseUint(val, 10, 64)
+	default:
+		return fmt.Errorf("no limit named %s", attr)
+	}
+
+	if err != nil {
+		return fmt.Errorf("invalid %s limit %q: %s", attr, val, err)
+	}
+
+	return nil
+}
+
+// Get returns the limit attr formatted as an attribute value
+func (l Limits) Get(attr string) (string, error) {
+	switch attr {
+	case AttrCPU:
+		if l.CPU == 0 {
+			return Unlimited, nil
+		}
+		return strconv.FormatFloat(l.CPU, 'f', -1, 64), nil
+	case AttrMemory:
+		return formatLimit(l.Memory), nil
+	case AttrPids:
+		return formatLimit(l.Pids), nil
+	case AttrNoFile:
+		return formatLimit(int64(l.NoFile)), nil
+	}
+
+	return "", fmt.Errorf("no limit named %s", attr)
+}
+
+// IsZero reports whether no limit is set
+func (l Limits) IsZero() bool {
+	return l == Limits{}
+}
+
+func formatLimit(v int64) string {
+	if v <= 0 {
+		return Unlimited
+	}
+
+	return strconv.FormatInt(v, 10)
+}
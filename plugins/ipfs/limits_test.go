@@ -0,0 +1,46 @@
+package ipfs
+
+import (
+	"testing"
+)
+
+func TestParseLimits(t *testing.T) {
+	l, err := ParseLimits(map[string]string{
+		AttrCPU:    "1.5",
+		AttrMemory: "256m",
+		AttrNoFile: "4096",
+		"other":    "ignored",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Limits{CPU: 1.5, Memory: 256 << 20, NoFile: 4096}
+	if l != expected {
+		t.Fatalf("expected %+v, got %+v", expected, l)
+	}
+
+	for attr, val := range map[string]string{
+		AttrCPU:    "1.5",
+		AttrMemory: "268435456",
+		AttrPids:   Unlimited,
+		AttrNoFile: "4096",
+	} {
+		got, err := l.Get(attr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != val {
+			t.Errorf("%s: expected %s, got %s", attr, val, got)
+		}
+	}
+
+	if err := l.Set(AttrMemory, Unlimited); err != nil || l.Memory != 0 {
+		t.Fatalf("expected memory limit to be removed: %v", err)
+	}
+
+	if _, err := ParseLimits(map[string]string{AttrPids: "-1"}); err == nil {
+		t.Fatal("expected an error for a negative limit")
+	}
+}
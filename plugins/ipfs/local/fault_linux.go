@@ -0,0 +1,116 @@
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// pauseProcess stops the process p until resumeProcess
+func pauseProcess(p *os.Process) error {
+	return p.Signal(syscall.SIGSTOP)
+}
+
+// resumeProcess continues the process p after pauseProcess
+func resumeProcess(p *os.Process) error {
+	return p.Signal(syscall.SIGCONT)
+}
+
+// processStopped reports whether the process pid is stopped by a signal
+func processStopped(pid int) (bool, error) {
+	b, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false, err
+	}
+
+	// The state follows the command name, which is in parentheses and may
+	// contain spaces
+	i := strings.LastIndex(string(b), ")")
+	if i < 0 {
+		return false, fmt.Errorf("unexpected /proc/%d/stat", pid)
+	}
+
+	fields := strings.Fields(string(b[i+1:]))
+	if len(fields) == 0 {
+		return false, fmt.Errorf("unexpected /proc/%d/stat", pid)
+	}
+
+	// T is stopped by a signal, t stopped by a tracer
+	return fields[0] == "T", nil
+}
+
+// freezeDisk throttles the reads and writes of the daemon pid to the disk
+// holding the node directory down to one operation per second, through the
+// io controller of the cgroup of the node. Unfreezing removes the throttling.
+func (l *LocalIpfs) freezeDisk(pid int, freeze bool) error {
+	dev, err := diskDevice(l.dir)
+	if err != nil {
+		return err
+	}
+
+	dir, err := l.setupCgroup("+io")
+	if err != nil {
+		return err
+	}
+
+	max := fmt.Sprintf("%s rbps=1 wbps=1 riops=1 wiops=1", dev)
+	if !freeze {
+		max = fmt.Sprintf("%s rbps=max wbps=max riops=max wiops=max", dev)
+	}
+
+	if err := writeCgroupFile(dir, "io.max", max); err != nil {
+		return err
+	}
+
+	return writeCgroupFile(dir, "cgroup.procs", strconv.Itoa(pid))
+}
+
+// diskFrozen reports whether the disk of the node is frozen
+func (l *LocalIpfs) diskFrozen() (bool, error) {
+	dev, err := diskDevice(l.dir)
+	if err != nil {
+		return false, nil
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(l.cgroupPath(), "io.max"))
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	// Devices without limits are not listed
+	for _, line := range strings.Split(string(b), "\n") {
+		if strings.HasPrefix(line, dev+" ") {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// diskDevice returns the major:minor number of the block device holding dir
+func diskDevice(dir string) (string, error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(dir, &st); err != nil {
+		return "", err
+	}
+
+	dev := uint64(st.Dev)
+	major := (dev>>8)&0xfff | (dev>>32)&^0xfff
+	minor := dev&0xff | (dev>>12)&^0xff
+
+	// Virtual filesystems, such as tmpfs or overlay, use major 0 and
+	// can not be throttled
+	if major == 0 {
+		return "", fmt.Errorf("%s is not on a block device", dir)
+	}
+
+	return fmt.Sprintf("%d:%d", major, minor), nil
+}
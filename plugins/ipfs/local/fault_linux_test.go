@@ -0,0 +1,25 @@
+// +build linux
+
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestProcessStopped(t *testing.T) {
+	stopped, err := processStopped(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stopped {
+		t.Fatal("expected running process not to be stopped")
+	}
+}
+
+func TestDiskDeviceVirtual(t *testing.T) {
+	if _, err := diskDevice("/proc"); err == nil {
+		t.Fatal("expected an error for a virtual filesystem")
+	}
+}
@@ -0,0 +1,37 @@
+// +build !linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// pauseProcess reports an error, pausing processes is only supported on linux
+func pauseProcess(p *os.Process) error {
+	return fmt.Errorf("pausing nodes is only supported on linux")
+}
+
+// resumeProcess reports an error, resuming processes is only supported on
+// linux
+func resumeProcess(p *os.Process) error {
+	return fmt.Errorf("resuming nodes is only supported on linux")
+}
+
+// processStopped reports false, the state of processes is only read on linux
+func processStopped(pid int) (bool, error) {
+	return false, nil
+}
+
+// freezeDisk reports an error, freezing disks is only supported on linux
+func (l *LocalIpfs) freezeDisk(pid int, freeze bool) error {
+	if !freeze {
+		return nil
+	}
+
+	return fmt.Errorf("freezing disks is only supported on linux")
+}
+
+func (l *LocalIpfs) diskFrozen() (bool, error) {
+	return false, nil
+}
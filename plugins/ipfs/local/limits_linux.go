@@ -0,0 +1,304 @@
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/ipfs/iptb/plugins/ipfs"
+)
+
+const (
+	cgroupFS          = "/sys/fs/cgroup"
+	defaultCgroupRoot = "/sys/fs/cgroup/iptb"
+
+	// cpuPeriod is the period, in microseconds, used to express cpu limits
+	cpuPeriod = 100000
+)
+
+// applyLimits applies limits to the running daemon pid, prev are the limits
+// previously applied, if any. The open files limit is set with prlimit, the
+// cpu, memory and pids limits through the cgroup v2 subtree of the node.
+func (l *LocalIpfs) applyLimits(pid int, prev, limits ipfs.Limits) error {
+	if limits.NoFile != prev.NoFile {
+		if err := setNoFile(pid, limits.NoFile); err != nil {
+			return fmt.Errorf("setting open files limit: %s", err)
+		}
+	}
+
+	if !needsCgroup(limits) && !needsCgroup(prev) {
+		return nil
+	}
+
+	dir, err := l.setupCgroup("+cpu +memory +pids")
+	if err != nil {
+		return err
+	}
+
+	if err := writeCgroupLimits(dir, limits); err != nil {
+		return err
+	}
+
+	return writeCgroupFile(dir, "cgroup.procs", strconv.Itoa(pid))
+}
+
+// effectiveLimits returns the limits the daemon pid runs with. Limits of
+// the cgroups above the one of the node apply too, the lowest one is returned.
+func (l *LocalIpfs) effectiveLimits(pid int) (ipfs.Limits, error) {
+	var limits ipfs.Limits
+
+	var rlim syscall.Rlimit
+	if err := prlimit(pid, syscall.RLIMIT_NOFILE, nil, &rlim); err != nil {
+		return limits, err
+	}
+
+	if rlim.Cur != rlimInfinity {
+		limits.NoFile = rlim.Cur
+	}
+
+	dir, err := processCgroup(pid)
+	if err != nil {
+		// Without cgroup v2 only the open files limit applies
+		return limits, nil
+	}
+
+	for ; strings.HasPrefix(dir, cgroupFS+"/"); dir = filepath.Dir(dir) {
+		cg, err := readCgroupLimits(dir)
+		if err != nil {
+			return limits, err
+		}
+
+		limits.CPU = lowest(limits.CPU, cg.CPU)
+		limits.Memory = int64(lowest(float64(limits.Memory), float64(cg.Memory)))
+		limits.Pids = int64(lowest(float64(limits.Pids), float64(cg.Pids)))
+	}
+
+	return limits, nil
+}
+
+// removeCgroup removes the cgroup of the node once the daemon has exited
+func (l *LocalIpfs) removeCgroup() error {
+	err := os.Remove(l.cgroupPath())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// cgroupPath returns the cgroup of the node, <cgroup root>/<testbed>-<hash>-<index>,
+// the hash of the testbed dir telling apart testbeds of the same name under
+// different roots
+func (l *LocalIpfs) cgroupPath() string {
+	testbed := filepath.Dir(l.dir)
+
+	h := fnv.New32a()
+	h.Write([]byte(testbed))
+
+	name := fmt.Sprintf("%s-%08x-%s", filepath.Base(testbed), h.Sum32(), filepath.Base(l.dir))
+	return filepath.Join(l.cgroupRoot, name)
+}
+
+// setupCgroup creates the cgroup of the node, enabling controllers, such as
+// "+cpu +memory", on the way down from the cgroup v2 mount
+func (l *LocalIpfs) setupCgroup(controllers string) (string, error) {
+	if _, err := os.Stat(filepath.Join(cgroupFS, "cgroup.controllers")); err != nil {
+		return "", fmt.Errorf("cgroup v2 must be mounted at %s", cgroupFS)
+	}
+
+	root, err := filepath.Rel(cgroupFS, l.cgroupRoot)
+	if err != nil || strings.HasPrefix(root, "..") {
+		return "", fmt.Errorf("cgroup root %s is not below %s", l.cgroupRoot, cgroupFS)
+	}
+
+	dirs := []string{cgroupFS}
+	for _, part := range strings.Split(root, string(filepath.Separator)) {
+		if part != "." {
+			dirs = append(dirs, filepath.Join(dirs[len(dirs)-1], part))
+		}
+	}
+
+	for _, dir := range dirs {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return "", fmt.Errorf("creating cgroup: %s", err)
+		}
+
+		if err := writeCgroupFile(dir, "cgroup.subtree_control", controllers); err != nil {
+			return "", err
+		}
+	}
+
+	node := l.cgroupPath()
+	if err := os.MkdirAll(node, 0755); err != nil {
+		return "", fmt.Errorf("creating cgroup: %s", err)
+	}
+
+	return node, nil
+}
+
+func needsCgroup(limits ipfs.Limits) bool {
+	return limits.CPU != 0 || limits.Memory != 0 || limits.Pids != 0
+}
+
+func writeCgroupLimits(dir string, limits ipfs.Limits) error {
+	cpu := ipfs.Unlimited
+	if limits.CPU != 0 {
+		cpu = strconv.FormatInt(int64(limits.CPU*cpuPeriod), 10)
+	}
+
+	if err := writeCgroupFile(dir, "cpu.max", fmt.Sprintf("%s %d", cpu, cpuPeriod)); err != nil {
+		return err
+	}
+
+	memory, _ := limits.Get(ipfs.AttrMemory)
+	if err := writeCgroupFile(dir, "memory.max", memory); err != nil {
+		return err
+	}
+
+	// Swap is disabled, so the memory limit bounds the daemon. Swap accounting
+	// may not be enabled, in which case the file does not exist.
+	swap := "0"
+	if limits.Memory == 0 {
+		swap = ipfs.Unlimited
+	}
+
+	if err := writeCgroupFile(dir, "memory.swap.max", swap); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	pids, _ := limits.Get(ipfs.AttrPids)
+	return writeCgroupFile(dir, "pids.max", pids)
+}
+
+func readCgroupLimits(dir string) (ipfs.Limits, error) {
+	var limits ipfs.Limits
+
+	cpu, err := readCgroupFile(dir, "cpu.max")
+	if err != nil {
+		return limits, err
+	}
+
+	if f := strings.Fields(cpu); len(f) == 2 && f[0] != ipfs.Unlimited {
+		quota, qerr := strconv.ParseFloat(f[0], 64)
+		period, perr := strconv.ParseFloat(f[1], 64)
+		if qerr != nil || perr != nil || period == 0 {
+			return limits, fmt.Errorf("unexpected cpu.max %q in %s", cpu, dir)
+		}
+
+		limits.CPU = quota / period
+	}
+
+	for attr, file := range map[string]string{
+		ipfs.AttrMemory: "memory.max",
+		ipfs.AttrPids:   "pids.max",
+	} {
+		val, err := readCgroupFile(dir, file)
+		if err != nil {
+			return limits, err
+		}
+
+		if err := limits.Set(attr, val); err != nil {
+			return limits, err
+		}
+	}
+
+	return limits, nil
+}
+
+// readCgroupFile reads a cgroup interface file, a controller which is not
+// enabled for the cgroup is reported as unlimited
+func readCgroupFile(dir, file string) (string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, file))
+	if os.IsNotExist(err) {
+		return ipfs.Unlimited, nil
+	} else if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(b)), nil
+}
+
+func writeCgroupFile(dir, file, val string) error {
+	f, err := os.OpenFile(filepath.Join(dir, file), os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(val); err != nil {
+		return fmt.Errorf("writing %q to %s: %s", val, filepath.Join(dir, file), err)
+	}
+
+	return nil
+}
+
+// processCgroup returns the cgroup v2 directory of the process pid
+func processCgroup(pid int) (string, error) {
+	b, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return "", err
+	}
+
+	for _, line := range strings.Split(string(b), "\n") {
+		if strings.HasPrefix(line, "0::") {
+			return filepath.Join(cgroupFS, strings.TrimPrefix(line, "0::")), nil
+		}
+	}
+
+	return "", fmt.Errorf("process %d is not in a cgroup v2 hierarchy", pid)
+}
+
+// lowest returns the lowest of two limits, where zero is unlimited
+func lowest(a, b float64) float64 {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+
+	return a
+}
+
+const rlimInfinity = ^uint64(0)
+
+// setNoFile sets the soft open files limit of the process pid, zero restores
+// the limit iptb runs with. Raising it above the hard limit requires privileges.
+func setNoFile(pid int, n uint64) error {
+	var rlim syscall.Rlimit
+	if err := prlimit(pid, syscall.RLIMIT_NOFILE, nil, &rlim); err != nil {
+		return err
+	}
+
+	if n == 0 {
+		var own syscall.Rlimit
+		if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &own); err != nil {
+			return err
+		}
+
+		n = own.Cur
+	}
+
+	rlim.Cur = n
+	if n > rlim.Max {
+		rlim.Max = n
+	}
+
+	return prlimit(pid, syscall.RLIMIT_NOFILE, &rlim, nil)
+}
+
+// prlimit gets and sets the resource limits of another process
+func prlimit(pid int, resource int, limit, old *syscall.Rlimit) error {
+	_, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(pid), uintptr(resource),
+		uintptr(unsafe.Pointer(limit)), uintptr(unsafe.Pointer(old)), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
@@ -0,0 +1,29 @@
+// +build !linux
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/ipfs/iptb/plugins/ipfs"
+)
+
+const defaultCgroupRoot = ""
+
+// applyLimits reports an error, resource limits are only supported on linux
+func (l *LocalIpfs) applyLimits(pid int, prev, limits ipfs.Limits) error {
+	if limits.IsZero() && prev.IsZero() {
+		return nil
+	}
+
+	return fmt.Errorf("resource limits are only supported on linux")
+}
+
+// effectiveLimits returns no limits, as none can be applied
+func (l *LocalIpfs) effectiveLimits(pid int) (ipfs.Limits, error) {
+	return ipfs.Limits{}, nil
+}
+
+func (l *LocalIpfs) removeCgroup() error {
+	return nil
+}
@@ -10,12 +10,11 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/ipfs/go-cid"
-	config "github.com/ipfs/go-ipfs-config"
-	serial "github.com/ipfs/go-ipfs-config/serialize"
 	"github.com/ipfs/iptb/plugins/ipfs"
 	"github.com/ipfs/iptb/testbed/interfaces"
 	"github.com/ipfs/iptb/util"
@@ -27,32 +26,68 @@ var errTimeout = errors.New("timeout")
 
 var PluginName = "localipfs"
 
+const (
+	attrBinary  = "binary"
+	attrVersion = "version"
+	attrNetns   = "netns"
+	attrIfName  = "ifname"
+	attrIP      = "ip"
+)
+
 type LocalIpfs struct {
-	dir       string
-	peerid    *cid.Cid
-	apiaddr   multiaddr.Multiaddr
-	swarmaddr multiaddr.Multiaddr
-	mdns      bool
+	dir         string
+	binary      string
+	peerid      *cid.Cid
+	peeridLk    sync.Mutex
+	apiaddr     multiaddr.Multiaddr
+	swarmaddrs  []string
+	transport   string
+	gatewayaddr multiaddr.Multiaddr
+	swarmkey    string
+	profile     string
+	configpatch string
+	mdns        bool
+	limits      ipfs.Limits
+	cgroupRoot  string
+	netns       *netns
+	netem       *ipfs.Netem
+	spec        map[string]string
 }
 
 var NewNode testbedi.NewNodeFunc
 var GetAttrDesc testbedi.GetAttrDescFunc
 var GetAttrList testbedi.GetAttrListFunc
+var HostPorts testbedi.HostPortsFunc
 
 func init() {
 	NewNode = func(dir string, attrs map[string]string) (testbedi.Core, error) {
 		mdns := false
 
-		if _, err := exec.LookPath("ipfs"); err != nil {
+		binary, err := resolveBinary(attrs[attrBinary])
+		if err != nil {
 			return nil, err
 		}
 
-		apiaddr, err := multiaddr.NewMultiaddr("/ip4/127.0.0.1/tcp/0")
-		if err != nil {
-			return nil, err
+		// In a network namespace the daemon listens on the address of the node
+		// on the testbed bridge, ports do not collide with other nodes
+		host, apiport, swarmport := "127.0.0.1", "0", "0"
+
+		var ns *netns
+		if v, ok := attrs[attrNetns]; ok {
+			var subnet string
+			if v != "true" {
+				subnet = v
+			}
+
+			ns, err = newNetns(dir, subnet)
+			if err != nil {
+				return nil, err
+			}
+
+			host, apiport, swarmport = ns.addr.String(), "5001", "4001"
 		}
 
-		swarmaddr, err := multiaddr.NewMultiaddr("/ip4/127.0.0.1/tcp/0")
+		apiaddr, err := multiaddr.NewMultiaddr("/ip4/" + host + "/tcp/" + apiport)
 		if err != nil {
 			return nil, err
 		}
@@ -66,36 +101,114 @@ func init() {
 			}
 		}
 
-		if swarmaddrstr, ok := attrs["swarmaddr"]; ok {
-			var err error
-			swarmaddr, err = multiaddr.NewMultiaddr(swarmaddrstr)
+		swarmaddrs, err := ipfs.SwarmListenAddrs(attrs, "/ip4/"+host+"/tcp/"+swarmport)
+		if err != nil {
+			return nil, err
+		}
 
-			if err != nil {
-				return nil, err
-			}
+		transport := ipfs.DefaultTransport
+		if v, ok := attrs["transport"]; ok {
+			transport = v
 		}
 
 		if _, ok := attrs["mdns"]; ok {
 			mdns = true
 		}
 
+		if err := ipfs.CheckProfile(attrs["profile"]); err != nil {
+			return nil, err
+		}
+
+		limits, err := ipfs.ParseLimits(attrs)
+		if err != nil {
+			return nil, err
+		}
+
+		netem, err := ipfs.LoadNetem(dir, attrs)
+		if err != nil {
+			return nil, err
+		}
+
+		if ns == nil && !netem.IsZero() {
+			return nil, fmt.Errorf("network emulation requires the node to run in a network namespace, see the netns attribute")
+		}
+
+		cgroupRoot := defaultCgroupRoot
+		if v := os.Getenv("IPTB_CGROUP_ROOT"); v != "" {
+			cgroupRoot = v
+		}
+
+		var gatewayaddr multiaddr.Multiaddr
+		if gatewayaddrstr, ok := attrs["gatewayaddr"]; ok {
+			var err error
+			gatewayaddr, err = multiaddr.NewMultiaddr(gatewayaddrstr)
+
+			if err != nil {
+				return nil, err
+			}
+		}
+
 		return &LocalIpfs{
-			dir:       dir,
-			apiaddr:   apiaddr,
-			swarmaddr: swarmaddr,
-			mdns:      mdns,
+			dir:         dir,
+			binary:      binary,
+			apiaddr:     apiaddr,
+			swarmaddrs:  swarmaddrs,
+			transport:   transport,
+			gatewayaddr: gatewayaddr,
+			swarmkey:    attrs["swarmkey"],
+			profile:     attrs["profile"],
+			configpatch: attrs["configpatch"],
+			mdns:        mdns,
+			limits:      limits,
+			cgroupRoot:  cgroupRoot,
+			netns:       ns,
+			netem:       netem,
+			spec:        attrs,
 		}, nil
 
 	}
 
 	GetAttrList = func() []string {
-		return ipfs.GetAttrList()
+		attrs := append(ipfs.GetAttrList(), attrBinary, attrVersion, attrNetns, attrIfName, attrIP)
+		attrs = append(attrs, ipfs.LimitAttrList()...)
+		attrs = append(attrs, ipfs.LinkAttrList()...)
+		return append(attrs, ipfs.SpecAttrList()...)
 	}
 
 	GetAttrDesc = func(attr string) (string, error) {
+		switch attr {
+		case attrBinary:
+			return "resolved path of the ipfs binary", nil
+		case attrVersion:
+			return "version reported by the ipfs binary", nil
+		case attrNetns:
+			return "network namespace of the node", nil
+		case attrIfName:
+			return "host side interface of the network namespace", nil
+		case attrIP:
+			return "address of the node on the testbed bridge", nil
+		}
+
+		if ipfs.IsLinkAttr(attr) {
+			return ipfs.LinkAttrDesc(attr)
+		}
+
+		if ipfs.IsLimitAttr(attr) {
+			return ipfs.LimitAttrDesc(attr)
+		}
+
+		if ipfs.IsSpecAttr(attr) {
+			return ipfs.SpecAttrDesc(attr)
+		}
+
 		return ipfs.GetAttrDesc(attr)
 	}
 
+	// Nodes in a network namespace listen on their address on the bridge
+	HostPorts = func(attrs map[string]string) bool {
+		_, ok := attrs[attrNetns]
+		return !ok
+	}
 }
 
 func GetMetricList() []string {
@@ -109,30 +222,49 @@ func GetMetricDesc(attr string) (string, error) {
 /// TestbedNode Interface
 
 func (l *LocalIpfs) Init(ctx context.Context, agrs ...string) (testbedi.Output, error) {
-	agrs = append([]string{"ipfs", "init"}, agrs...)
+	initargs := []string{"ipfs", "init"}
+	if l.profile != "" {
+		initargs = append(initargs, "--profile="+l.profile)
+	}
+
+	agrs = append(initargs, agrs...)
 	output, oerr := l.RunCmd(ctx, nil, agrs...)
 	if oerr != nil {
 		return nil, oerr
 	}
 
-	icfg, err := l.Config()
+	cfg, err := ipfs.ReadConfig(l.dir)
 	if err != nil {
 		return nil, err
 	}
 
-	lcfg := icfg.(*config.Config)
+	gatewayaddr := ""
+	if l.gatewayaddr != nil {
+		gatewayaddr = l.gatewayaddr.String()
+	}
 
-	lcfg.Bootstrap = nil
-	lcfg.Addresses.Swarm = []string{l.swarmaddr.String()}
-	lcfg.Addresses.API = l.apiaddr.String()
-	lcfg.Addresses.Gateway = ""
-	lcfg.Discovery.MDNS.Enabled = l.mdns
+	err = ipfs.InitConfig(cfg, l.swarmaddrs, l.apiaddr.String(), gatewayaddr, l.mdns)
+	if err != nil {
+		return nil, err
+	}
 
-	err = l.WriteConfig(lcfg)
+	err = l.WriteConfig(cfg)
 	if err != nil {
 		return nil, err
 	}
 
+	if l.swarmkey != "" {
+		if err := ipfs.InstallSwarmKey(l.swarmkey, l.dir); err != nil {
+			return nil, err
+		}
+	}
+
+	if l.configpatch != "" {
+		if err := ipfs.ApplyConfigPatch(l, l.configpatch); err != nil {
+			return nil, err
+		}
+	}
+
 	return output, oerr
 }
 
@@ -148,7 +280,25 @@ func (l *LocalIpfs) Start(ctx context.Context, wait bool, args ...string) (testb
 
 	dir := l.dir
 	dargs := append([]string{"daemon"}, args...)
-	cmd := exec.Command("ipfs", dargs...)
+	cmd := exec.Command(l.binary, dargs...)
+	if l.netns != nil {
+		if err := l.netns.setup(); err != nil {
+			return nil, err
+		}
+
+		cmd = l.netns.command(l.binary, dargs...)
+
+		if err := l.netem.Restarted(); err != nil {
+			return nil, err
+		}
+
+		if !l.netem.IsZero() {
+			if err := l.netem.Apply(l.netns.veth); err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	cmd.Dir = dir
 
 	cmd.Env, err = l.env()
@@ -178,6 +328,12 @@ func (l *LocalIpfs) Start(ctx context.Context, wait bool, args ...string) (testb
 
 	pid := cmd.Process.Pid
 
+	if err := l.applyLimits(pid, ipfs.Limits{}, l.limits); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil, err
+	}
+
 	err = ioutil.WriteFile(filepath.Join(dir, "daemon.pid"), []byte(fmt.Sprint(pid)), 0666)
 	if err != nil {
 		return nil, err
@@ -212,8 +368,14 @@ func (l *LocalIpfs) Stop(ctx context.Context) error {
 		if err != nil && !os.IsNotExist(err) {
 			panic(fmt.Errorf("error removing pid file for daemon at %s: %s", l.dir, err))
 		}
+
+		// Fails if the daemon is still running, the cgroup is reused on start
+		l.removeCgroup()
 	}()
 
+	// A paused daemon only handles the signals below once resumed
+	resumeProcess(p)
+
 	if err := l.signalAndWait(p, waitch, syscall.SIGTERM, 1*time.Second); err != errTimeout {
 		return err
 	}
@@ -233,6 +395,126 @@ func (l *LocalIpfs) Stop(ctx context.Context) error {
 	return fmt.Errorf("Could not stop localipfs node with pid %d", pid)
 }
 
+// Destroy stops the daemon and removes the network namespace of the node,
+// and the testbed bridge once no other node is connected to it
+func (l *LocalIpfs) Destroy(ctx context.Context) error {
+	alive, err := l.isAlive()
+	if err != nil {
+		return err
+	}
+
+	if alive {
+		if err := l.Stop(ctx); err != nil {
+			return err
+		}
+	}
+
+	if l.netns == nil {
+		return nil
+	}
+
+	return l.netns.teardown()
+}
+
+// Fault pauses and resumes the daemon with SIGSTOP and SIGCONT, kills it
+// with SIGKILL, or freezes its disk by throttling it through the cgroup of
+// the node
+func (l *LocalIpfs) Fault(ctx context.Context, fault string) error {
+	pid, err := l.getPID()
+	if os.IsNotExist(err) {
+		return fmt.Errorf("node is not running")
+	} else if err != nil {
+		return err
+	}
+
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+
+	switch fault {
+	case testbedi.FaultPause:
+		return pauseProcess(p)
+	case testbedi.FaultResume:
+		frozen, err := l.diskFrozen()
+		if err != nil {
+			return err
+		}
+
+		if frozen {
+			if err := l.freezeDisk(pid, false); err != nil {
+				return err
+			}
+		}
+
+		return resumeProcess(p)
+	case testbedi.FaultKill:
+		if err := p.Signal(syscall.SIGKILL); err != nil {
+			return err
+		}
+
+		for i := 0; i < 50; i++ {
+			if alive, _ := l.isAlive(); !alive {
+				if err := os.Remove(filepath.Join(l.dir, "daemon.pid")); err != nil && !os.IsNotExist(err) {
+					return err
+				}
+
+				return l.removeCgroup()
+			}
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+
+		return fmt.Errorf("daemon with pid %d did not exit", pid)
+	case testbedi.FaultFreezeDisk:
+		return l.freezeDisk(pid, true)
+	}
+
+	return fmt.Errorf("unsupported fault %s", fault)
+}
+
+// Status reports whether the daemon is running, stopped, paused, or has its
+// disk frozen
+func (l *LocalIpfs) Status(ctx context.Context) (string, error) {
+	alive, err := l.isAlive()
+	if err != nil {
+		return "", err
+	}
+
+	if !alive {
+		return testbedi.StatusStopped, nil
+	}
+
+	pid, err := l.getPID()
+	if err != nil {
+		return "", err
+	}
+
+	stopped, err := processStopped(pid)
+	if err != nil {
+		return "", err
+	}
+
+	if stopped {
+		return testbedi.StatusPaused, nil
+	}
+
+	frozen, err := l.diskFrozen()
+	if err != nil {
+		return "", err
+	}
+
+	if frozen {
+		return testbedi.StatusDiskFrozen, nil
+	}
+
+	return testbedi.StatusRunning, nil
+}
+
 func (l *LocalIpfs) RunCmd(ctx context.Context, stdin io.Reader, args ...string) (testbedi.Output, error) {
 	env, err := l.env()
 
@@ -240,7 +522,13 @@ func (l *LocalIpfs) RunCmd(ctx context.Context, stdin io.Reader, args ...string)
 		return nil, fmt.Errorf("error getting env: %s", err)
 	}
 
-	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	// Commands invoking ipfs use the binary selected for the node
+	name := args[0]
+	if name == "ipfs" {
+		name = l.binary
+	}
+
+	cmd := exec.CommandContext(ctx, name, args[1:]...)
 	cmd.Env = env
 	cmd.Stdin = stdin
 
@@ -291,27 +579,16 @@ func (l *LocalIpfs) RunCmd(ctx context.Context, stdin io.Reader, args ...string)
 }
 
 func (l *LocalIpfs) Connect(ctx context.Context, tbn testbedi.Core) error {
-	swarmaddrs, err := tbn.SwarmAddrs()
-	if err != nil {
-		return err
-	}
-
-	output, err := l.RunCmd(ctx, nil, "ipfs", "swarm", "connect", swarmaddrs[0])
-
-	if err != nil {
-		return err
-	}
-
-	if output.ExitCode() != 0 {
-		out, err := ioutil.ReadAll(output.Stderr())
-		if err != nil {
-			return err
-		}
+	_, err := l.ConnectWith(ctx, tbn, testbedi.ConnectOptions{})
+	return err
+}
 
-		return fmt.Errorf("%s", string(out))
+func (l *LocalIpfs) ConnectWith(ctx context.Context, tbn testbedi.Core, opts testbedi.ConnectOptions) (testbedi.Output, error) {
+	if opts.Transport == "" {
+		opts.Transport = l.transport
 	}
 
-	return nil
+	return ipfs.Connect(ctx, l, tbn, opts)
 }
 
 func (l *LocalIpfs) Shell(ctx context.Context, nodes []testbedi.Core) error {
@@ -360,7 +637,8 @@ func (l *LocalIpfs) APIAddr() (string, error) {
 }
 
 func (l *LocalIpfs) SwarmAddrs() ([]string, error) {
-	return ipfs.SwarmAddrs(l)
+	// Loopback addresses of a node in a network namespace are its own
+	return ipfs.SwarmAddrs(l, l.netns == nil)
 }
 
 func (l *LocalIpfs) Dir() string {
@@ -368,6 +646,10 @@ func (l *LocalIpfs) Dir() string {
 }
 
 func (l *LocalIpfs) PeerID() (string, error) {
+	// Nodes are shared by the goroutines of commands such as churn
+	l.peeridLk.Lock()
+	defer l.peeridLk.Unlock()
+
 	if l.peerid != nil {
 		return l.peerid.String(), nil
 	}
@@ -418,14 +700,68 @@ func (l *LocalIpfs) GetAttrDesc(attr string) (string, error) {
 	return GetAttrDesc(attr)
 }
 
-func (l *LocalIpfs) GetAttr(attr string) (string, error) {
+func (l *LocalIpfs) Attr(attr string) (string, error) {
+	switch attr {
+	case attrBinary:
+		return l.binary, nil
+	case attrVersion:
+		return l.version()
+	case attrNetns, attrIfName, attrIP:
+		if l.netns == nil {
+			return "", fmt.Errorf("node does not run in a network namespace")
+		}
+
+		switch attr {
+		case attrNetns:
+			return l.netns.name, nil
+		case attrIfName:
+			return l.netns.veth, nil
+		default:
+			return l.netns.addr.String(), nil
+		}
+	}
+
+	if ipfs.IsLimitAttr(attr) {
+		return l.limit(attr)
+	}
+
+	if ipfs.IsLinkAttr(attr) {
+		return l.netem.Get(attr)
+	}
+
+	if ipfs.IsSpecAttr(attr) {
+		return ipfs.SpecAttr(l.spec, attr)
+	}
+
 	return ipfs.GetAttr(l, attr)
 }
 
-func (l *LocalIpfs) SetAttr(string, string) error {
+func (l *LocalIpfs) SetAttr(attr string, val string) error {
+	if ipfs.IsLimitAttr(attr) {
+		return l.setLimit(attr, val)
+	}
+
+	if ipfs.IsLinkAttr(attr) {
+		if l.netns == nil {
+			return fmt.Errorf("%s requires the node to run in a network namespace, see the netns attribute", attr)
+		}
+
+		return l.netem.Set(l.netns.veth, attr, val)
+	}
+
 	return fmt.Errorf("no attribute to set")
 }
 
+// ShapeLinks shapes the traffic the node receives from each of peers, on top
+// of its network emulation settings
+func (l *LocalIpfs) ShapeLinks(ctx context.Context, peers []testbedi.LinkPeer) error {
+	if l.netns == nil {
+		return fmt.Errorf("links require the node to run in a network namespace, see the netns attribute")
+	}
+
+	return l.netem.ShapeLinks(l.netns.veth, peers)
+}
+
 func (l *LocalIpfs) StderrReader() (io.ReadCloser, error) {
 	return l.readerFor("daemon.stderr")
 }
@@ -435,11 +771,11 @@ func (l *LocalIpfs) StdoutReader() (io.ReadCloser, error) {
 }
 
 func (l *LocalIpfs) Config() (interface{}, error) {
-	return serial.Load(filepath.Join(l.dir, "config"))
+	return ipfs.ReadConfig(l.dir)
 }
 
 func (l *LocalIpfs) WriteConfig(cfg interface{}) error {
-	return serial.WriteConfigFile(filepath.Join(l.dir, "config"), cfg)
+	return ipfs.WriteConfig(l.dir, cfg)
 }
 
 func (l *LocalIpfs) Type() string {
@@ -498,6 +834,127 @@ func (l *LocalIpfs) isAlive() (bool, error) {
 	return false, nil
 }
 
+// limit returns the effective limit attr of the running daemon, or the
+// configured one when the node is not running
+func (l *LocalIpfs) limit(attr string) (string, error) {
+	alive, err := l.isAlive()
+	if err != nil {
+		return "", err
+	}
+
+	if !alive {
+		return l.limits.Get(attr)
+	}
+
+	pid, err := l.getPID()
+	if err != nil {
+		return "", err
+	}
+
+	limits, err := l.effectiveLimits(pid)
+	if err != nil {
+		return "", err
+	}
+
+	return limits.Get(attr)
+}
+
+// setLimit changes a limit of the node, applying it to the running daemon
+func (l *LocalIpfs) setLimit(attr, val string) error {
+	limits := l.limits
+	if err := limits.Set(attr, val); err != nil {
+		return err
+	}
+
+	alive, err := l.isAlive()
+	if err != nil {
+		return err
+	}
+
+	// A stopped node picks the limit up on start, when saved to the spec
+	if !alive {
+		l.limits = limits
+		return nil
+	}
+
+	pid, err := l.getPID()
+	if err != nil {
+		return err
+	}
+
+	if err := l.applyLimits(pid, l.limits, limits); err != nil {
+		return err
+	}
+
+	l.limits = limits
+	return nil
+}
+
+func (l *LocalIpfs) version() (string, error) {
+	out, err := l.RunCmd(context.TODO(), nil, "ipfs", "version", "--number")
+	if err != nil {
+		return "", err
+	}
+
+	stdout, err := ioutil.ReadAll(out.Stdout())
+	if err != nil {
+		return "", err
+	}
+
+	if out.ExitCode() != 0 {
+		return "", fmt.Errorf("could not determine version of %s", l.binary)
+	}
+
+	return strings.TrimSpace(string(stdout)), nil
+}
+
+var (
+	resolvedLk sync.Mutex
+	// resolved caches the binaries found by resolveBinary, as the nodes of
+	// a testbed usually share them
+	resolved = make(map[string]string)
+)
+
+// resolveBinary finds the ipfs binary to use. A name containing a path
+// separator is used as is, `testbed create` records such paths as absolute.
+// Other names are looked up in the binaries directory under IPTB_ROOT, and
+// finally in PATH.
+func resolveBinary(name string) (string, error) {
+	if name == "" {
+		name = "ipfs"
+	}
+
+	if strings.ContainsRune(name, filepath.Separator) {
+		return filepath.Abs(name)
+	}
+
+	resolvedLk.Lock()
+	defer resolvedLk.Unlock()
+
+	if bin, ok := resolved[name]; ok {
+		return bin, nil
+	}
+
+	bin, err := lookupBinary(name)
+	if err != nil {
+		return "", err
+	}
+
+	resolved[name] = bin
+	return bin, nil
+}
+
+func lookupBinary(name string) (string, error) {
+	if root := os.Getenv("IPTB_ROOT"); root != "" {
+		bin := filepath.Join(root, "binaries", name)
+		if _, err := os.Stat(bin); err == nil {
+			return bin, nil
+		}
+	}
+
+	return exec.LookPath(name)
+}
+
 func (l *LocalIpfs) env() ([]string, error) {
 	envs := os.Environ()
 	ipfspath := "IPFS_PATH=" + l.dir
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// defaultNetnsSubnet returns the subnet of the bridge of the testbed with
+// hash sum, used unless one is given. Testbeds get distinct subnets in
+// 10.100.0.0/16 to 10.227.0.0/16, the bridge takes the first address and
+// node n the address n+2.
+func defaultNetnsSubnet(sum uint32) string {
+	return fmt.Sprintf("10.%d.0.0/16", 100+sum%128)
+}
+
+// netns is the network namespace a node runs in. The namespace is connected
+// through a veth pair to a bridge shared by the nodes of the testbed, traffic
+// to the node is shaped on the host side of the pair.
+type netns struct {
+	name    string
+	bridge  string
+	veth    string
+	subnet  string
+	addr    net.IP
+	gateway net.IP
+	prefix  int
+}
+
+// newNetns returns the network namespace of the node in dir, the nodes of a
+// testbed share the subnet. An empty subnet picks the one of the testbed.
+func newNetns(dir string, subnet string) (*netns, error) {
+	if runtime.GOOS != "linux" {
+		return nil, fmt.Errorf("network namespaces are only supported on linux")
+	}
+
+	index, err := strconv.Atoi(filepath.Base(dir))
+	if err != nil {
+		return nil, fmt.Errorf("could not determine node index of %s", dir)
+	}
+
+	// Interface names are limited to 15 characters, the testbed is identified
+	// by a hash of its directory. Namespaces use it too so testbeds of the
+	// same name under different roots do not share them.
+	h := fnv.New32a()
+	h.Write([]byte(filepath.Dir(dir)))
+	id := fmt.Sprintf("%08x", h.Sum32())
+
+	if subnet == "" {
+		subnet = defaultNetnsSubnet(h.Sum32())
+	}
+
+	ip, ipnet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return nil, err
+	}
+
+	base := ip.Mask(ipnet.Mask).To4()
+	if base == nil {
+		return nil, fmt.Errorf("subnet %s is not an ipv4 subnet", subnet)
+	}
+
+	prefix, bits := ipnet.Mask.Size()
+	if index+2 >= 1<<uint(bits-prefix)-1 {
+		return nil, fmt.Errorf("subnet %s is too small for node %d", subnet, index)
+	}
+
+	return &netns{
+		name:    fmt.Sprintf("iptb%s-%d", id, index),
+		bridge:  "iptb" + id,
+		veth:    fmt.Sprintf("iv%s.%d", id[:6], index),
+		subnet:  fmt.Sprintf("%s/%d", base, prefix),
+		addr:    offsetIP(base, index+2),
+		gateway: offsetIP(base, 1),
+		prefix:  prefix,
+	}, nil
+}
+
+func offsetIP(base net.IP, n int) net.IP {
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, binary.BigEndian.Uint32(base)+uint32(n))
+	return ip
+}
+
+// setup creates the bridge, the namespace and the veth pair connecting them,
+// parts which already exist are left as they are
+func (ns *netns) setup() error {
+	if !linkExists(ns.bridge) {
+		if err := ns.checkSubnet(); err != nil {
+			return err
+		}
+
+		err := runIP(
+			[]string{"link", "add", ns.bridge, "type", "bridge"},
+			[]string{"addr", "add", fmt.Sprintf("%s/%d", ns.gateway, ns.prefix), "dev", ns.bridge},
+			[]string{"link", "set", ns.bridge, "up"},
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join("/var/run/netns", ns.name)); os.IsNotExist(err) {
+		if err := runIP([]string{"netns", "add", ns.name}); err != nil {
+			return err
+		}
+	}
+
+	if linkExists(ns.veth) {
+		return nil
+	}
+
+	return runIP(
+		[]string{"link", "add", ns.veth, "type", "veth", "peer", "name", "eth0", "netns", ns.name},
+		[]string{"link", "set", ns.veth, "master", ns.bridge},
+		[]string{"link", "set", ns.veth, "up"},
+		[]string{"-n", ns.name, "addr", "add", fmt.Sprintf("%s/%d", ns.addr, ns.prefix), "dev", "eth0"},
+		[]string{"-n", ns.name, "link", "set", "eth0", "up"},
+		[]string{"-n", ns.name, "link", "set", "lo", "up"},
+	)
+}
+
+// checkSubnet fails if the subnet of the bridge overlaps with routes through
+// other interfaces, such as the bridge of another testbed
+func (ns *netns) checkSubnet() error {
+	out, err := exec.Command("ip", "-o", "route", "show", "root", ns.subnet).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ip route show: %s: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Fields(line)
+		for i := 0; i+1 < len(fields); i++ {
+			if fields[i] == "dev" && fields[i+1] != ns.bridge {
+				return fmt.Errorf("subnet %s is already routed through %s, give the netns attribute another subnet", ns.subnet, fields[i+1])
+			}
+		}
+	}
+
+	return nil
+}
+
+// teardown deletes the namespace, which removes the veth pair with it, and
+// the bridge once no other node is connected to it
+func (ns *netns) teardown() error {
+	if _, err := os.Stat(filepath.Join("/var/run/netns", ns.name)); err == nil {
+		if err := runIP([]string{"netns", "delete", ns.name}); err != nil {
+			return err
+		}
+	}
+
+	if !linkExists(ns.bridge) {
+		return nil
+	}
+
+	ports, err := ioutil.ReadDir(filepath.Join("/sys/class/net", ns.bridge, "brif"))
+	if err != nil || len(ports) != 0 {
+		return err
+	}
+
+	return runIP([]string{"link", "delete", ns.bridge})
+}
+
+// command returns a command running name in the namespace
+func (ns *netns) command(name string, args ...string) *exec.Cmd {
+	return exec.Command("ip", append([]string{"netns", "exec", ns.name, name}, args...)...)
+}
+
+func linkExists(name string) bool {
+	_, err := os.Stat(filepath.Join("/sys/class/net", name))
+	return err == nil
+}
+
+// runIP runs each set of arguments with the ip command, in order
+func runIP(cmds ...[]string) error {
+	for _, args := range cmds {
+		out, err := exec.Command("ip", args...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("ip %s: %s: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestNewNetns(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("network namespaces are only supported on linux")
+	}
+
+	ns, err := newNetns("/root/testbed/testbeds/default/3", "10.99.0.0/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ns.name != fmt.Sprintf("iptb%08x-3", fnvSum("/root/testbed/testbeds/default")) {
+		t.Errorf("unexpected namespace name %s", ns.name)
+	}
+
+	// Testbeds of the same name under another root get their own namespaces
+	elsewhere, err := newNetns("/tmp/testbed/testbeds/default/3", "10.99.0.0/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if elsewhere.name == ns.name {
+		t.Errorf("namespace %s is shared by testbeds of different roots", ns.name)
+	}
+
+	if ns.addr.String() != "10.99.0.5" || ns.gateway.String() != "10.99.0.1" || ns.prefix != 16 {
+		t.Errorf("unexpected addresses %s via %s/%d", ns.addr, ns.gateway, ns.prefix)
+	}
+
+	if len(ns.bridge) > 15 || len(ns.veth) > 15 {
+		t.Errorf("interface names %s and %s are too long", ns.bridge, ns.veth)
+	}
+
+	// Nodes of a testbed share the bridge
+	other, err := newNetns("/root/testbed/testbeds/default/300", "10.99.0.0/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if other.bridge != ns.bridge || other.addr.String() != "10.99.1.46" {
+		t.Errorf("unexpected bridge %s or address %s", other.bridge, other.addr)
+	}
+
+	if !strings.HasSuffix(other.veth, ".300") || other.veth == ns.veth {
+		t.Errorf("unexpected veth name %s", other.veth)
+	}
+
+	if _, err := newNetns("/root/testbed/testbeds/default/300", "10.99.0.0/24"); err == nil {
+		t.Error("expected an error for a subnet too small")
+	}
+
+	// Testbeds get their own subnet unless one is given
+	a, err := newNetns("/root/testbed/testbeds/a/0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := newNetns("/root/testbed/testbeds/b/0", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.subnet == b.subnet || a.subnet != defaultNetnsSubnet(fnvSum("/root/testbed/testbeds/a")) {
+		t.Errorf("expected distinct testbed subnets, got %s and %s", a.subnet, b.subnet)
+	}
+}
+
+func fnvSum(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
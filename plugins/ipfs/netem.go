@@ -0,0 +1,248 @@
+package ipfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ipfs/iptb/testbed/interfaces"
+)
+
+// Network emulation attributes
+const (
+	AttrLatency   = "latency"
+	AttrJitter    = "jitter"
+	AttrBandwidth = "bandwidth"
+	AttrLoss      = "loss"
+
+	// AttrNetem reports all the network emulation settings of a node, setting
+	// it to `off` removes them
+	AttrNetem = "netem"
+)
+
+// LinkAttrList returns the network emulation attributes
+func LinkAttrList() []string {
+	return []string{AttrLatency, AttrJitter, AttrBandwidth, AttrLoss, AttrNetem}
+}
+
+// IsLinkAttr reports whether attr is a network emulation attribute
+func IsLinkAttr(attr string) bool {
+	for _, a := range LinkAttrList() {
+		if a == attr {
+			return true
+		}
+	}
+
+	return false
+}
+
+// LinkAttrDesc returns the description of the network emulation attribute attr
+func LinkAttrDesc(attr string) (string, error) {
+	switch attr {
+	case AttrLatency:
+		return "latency added to the traffic of the node (e.g. 50ms)", nil
+	case AttrJitter:
+		return "latency variation of the traffic of the node (e.g. 10ms)", nil
+	case AttrBandwidth:
+		return "bandwidth of the node in Mbps", nil
+	case AttrLoss:
+		return "packet loss percentage of the traffic of the node", nil
+	case AttrNetem:
+		return "all network emulation settings, set to `off` to remove them", nil
+	}
+
+	return "", fmt.Errorf("unrecognized attribute")
+}
+
+// LinkState are the network emulation settings of a node, zero values are
+// not shaped. They are the conditions of the links set with `iptb link`, and
+// are applied on the same tc tree.
+type LinkState struct {
+	testbedi.LinkSettings
+}
+
+// LoadLinkState returns the network emulation settings given as attributes
+// in the spec of a node
+func LoadLinkState(attrs map[string]string) (LinkState, error) {
+	var s LinkState
+
+	for _, attr := range []string{AttrLatency, AttrJitter, AttrBandwidth, AttrLoss} {
+		v, ok := attrs[attr]
+		if !ok {
+			continue
+		}
+
+		if err := s.Set(attr, v); err != nil {
+			return s, err
+		}
+	}
+
+	return s, nil
+}
+
+// Set parses val and sets the network emulation attribute attr
+func (s *LinkState) Set(attr, val string) error {
+	switch attr {
+	case AttrLatency, AttrJitter, AttrBandwidth, AttrLoss:
+		return s.LinkSettings.Set(attr, val)
+	case AttrNetem:
+		if val != "off" {
+			return fmt.Errorf("%s can only be set to off", attr)
+		}
+
+		*s = LinkState{}
+		return nil
+	}
+
+	return fmt.Errorf("no attribute named: %s", attr)
+}
+
+// Get returns the network emulation attribute attr
+func (s LinkState) Get(attr string) (string, error) {
+	switch attr {
+	case AttrLatency:
+		return s.Latency.String(), nil
+	case AttrJitter:
+		return s.Jitter.String(), nil
+	case AttrBandwidth:
+		return strconv.FormatFloat(s.Bandwidth, 'f', -1, 64), nil
+	case AttrLoss:
+		return strconv.FormatFloat(s.Loss, 'f', -1, 64), nil
+	case AttrNetem:
+		if s.IsZero() {
+			return "off", nil
+		}
+
+		var parts []string
+		for _, a := range []string{AttrLatency, AttrJitter, AttrBandwidth, AttrLoss} {
+			v, _ := s.Get(a)
+			parts = append(parts, a+"="+v)
+		}
+
+		return strings.Join(parts, " "), nil
+	}
+
+	return "", fmt.Errorf("no attribute named: %s", attr)
+}
+
+// NetemStateFile is the file of a node directory recording the network
+// emulation applied to the node since it started
+const NetemStateFile = "netem-state.json"
+
+// Netem is the network emulation of a node: the settings of its spec, applied
+// when it starts, then the settings and links applied to it since. These are
+// recorded in NetemStateFile, so settings accumulate across invocations.
+type Netem struct {
+	dir   string
+	spec  LinkState
+	state netemState
+}
+
+type netemState struct {
+	LinkState
+	// Peers are the links shaped on top of the settings
+	Peers []testbedi.LinkPeer `json:",omitempty"`
+}
+
+// LoadNetem returns the network emulation of the node in dir, whose spec has
+// the attributes attrs
+func LoadNetem(dir string, attrs map[string]string) (*Netem, error) {
+	spec, err := LoadLinkState(attrs)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &Netem{dir: dir, spec: spec}
+	n.state.LinkState = spec
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, NetemStateFile))
+	if os.IsNotExist(err) {
+		return n, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &n.state); err != nil {
+		return nil, fmt.Errorf("parsing %s: %s", NetemStateFile, err)
+	}
+
+	return n, nil
+}
+
+// IsZero reports whether nothing is to be shaped
+func (n *Netem) IsZero() bool {
+	return n.state.IsZero() && len(n.state.Peers) == 0
+}
+
+// Get returns the network emulation attribute attr of the settings applied
+func (n *Netem) Get(attr string) (string, error) {
+	return n.state.Get(attr)
+}
+
+// Set changes the network emulation attribute attr of the node and shapes
+// ifname, its interface, accordingly. The settings are cumulative, and the
+// links of the node are kept. They last until the node restarts.
+func (n *Netem) Set(ifname, attr, val string) error {
+	next := n.state
+	if err := next.Set(attr, val); err != nil {
+		return err
+	}
+
+	if err := RunTcCommands(TcCommands(ifname, next.LinkSettings, next.Peers)); err != nil {
+		return err
+	}
+
+	n.state = next
+	return n.save()
+}
+
+// ShapeLinks shapes the traffic received on ifname from each of peers, on
+// top of the settings applied
+func (n *Netem) ShapeLinks(ifname string, peers []testbedi.LinkPeer) error {
+	if err := RunTcCommands(TcCommands(ifname, n.state.LinkSettings, peers)); err != nil {
+		return err
+	}
+
+	n.state.Peers = peers
+	return n.save()
+}
+
+// Restarted drops what was applied to the node before it restarted, its
+// interface is new. The settings of the spec apply again.
+func (n *Netem) Restarted() error {
+	n.state = netemState{LinkState: n.spec}
+	return n.save()
+}
+
+// Apply shapes ifname, the interface of the node, with the settings and
+// links applied
+func (n *Netem) Apply(ifname string) error {
+	return RunTcCommands(TcCommands(ifname, n.state.LinkSettings, n.state.Peers))
+}
+
+// save records the state, which only needs to be once it departs from the
+// spec
+func (n *Netem) save() error {
+	path := filepath.Join(n.dir, NetemStateFile)
+
+	if n.state.LinkState == n.spec && len(n.state.Peers) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		return nil
+	}
+
+	data, err := json.MarshalIndent(n.state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, data, 0664)
+}
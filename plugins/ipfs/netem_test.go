@@ -0,0 +1,123 @@
+package ipfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ipfs/iptb/testbed/interfaces"
+)
+
+func TestLinkState(t *testing.T) {
+	var s LinkState
+
+	// Settings accumulate
+	for attr, val := range map[string]string{AttrLatency: "80ms", AttrLoss: "2", AttrBandwidth: "1.5"} {
+		if err := s.Set(attr, val); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := LinkState{testbedi.LinkSettings{Latency: 80 * time.Millisecond, Loss: 2, Bandwidth: 1.5}}
+	if s != expected {
+		t.Fatalf("expected %+v, got %+v", expected, s)
+	}
+
+	netem, err := s.Get(AttrNetem)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if netem != "latency=80ms jitter=0s bandwidth=1.5 loss=2" {
+		t.Fatalf("unexpected netem %q", netem)
+	}
+
+	if err := s.Set(AttrLoss, "0.5"); err != nil || s.Loss != 0.5 {
+		t.Fatalf("expected a fractional loss, got %v (%v)", s.Loss, err)
+	}
+
+	if err := s.Set(AttrLoss, "101"); err == nil {
+		t.Fatal("expected an error for a loss above 100")
+	}
+
+	if err := s.Set(AttrNetem, "on"); err == nil {
+		t.Fatal("expected an error setting netem to on")
+	}
+
+	if err := s.Set(AttrNetem, "off"); err != nil || !s.IsZero() {
+		t.Fatalf("expected settings to be removed: %v", err)
+	}
+
+	if netem, _ := s.Get(AttrNetem); netem != "off" {
+		t.Fatalf("expected netem to be off, got %q", netem)
+	}
+}
+
+func TestLoadLinkState(t *testing.T) {
+	attrs := map[string]string{AttrLatency: "10ms", AttrJitter: "1ms", "binary": "ipfs"}
+
+	s, err := LoadLinkState(attrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Latency != 10*time.Millisecond || s.Jitter != time.Millisecond {
+		t.Fatalf("expected settings from the attributes, got %+v", s)
+	}
+}
+
+func TestNetemState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "iptb-netem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	attrs := map[string]string{AttrLatency: "10ms"}
+
+	n, err := LoadNetem(dir, attrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v, _ := n.Get(AttrLatency); v != "10ms" {
+		t.Fatalf("expected the settings of the spec, got latency %s", v)
+	}
+
+	// Settings applied since the node started are read back by the next
+	// invocation
+	n.state.Loss = 2
+	n.state.Peers = []testbedi.LinkPeer{{IP: "10.0.0.2", LinkSettings: testbedi.LinkSettings{Latency: time.Second}}}
+	if err := n.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err = LoadNetem(dir, attrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v, _ := n.Get(AttrNetem); v != "latency=10ms jitter=0s bandwidth=0 loss=2" || len(n.state.Peers) != 1 {
+		t.Fatalf("expected the settings applied, got %s with %v", v, n.state.Peers)
+	}
+
+	// A restart drops them
+	if err := n.Restarted(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, NetemStateFile)); !os.IsNotExist(err) {
+		t.Fatalf("expected %s to be removed", NetemStateFile)
+	}
+
+	n, err = LoadNetem(dir, attrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v, _ := n.Get(AttrNetem); v != "latency=10ms jitter=0s bandwidth=0 loss=0" {
+		t.Fatalf("expected the settings of the spec, got %s", v)
+	}
+}
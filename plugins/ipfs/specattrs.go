@@ -0,0 +1,55 @@
+package ipfs
+
+import (
+	"fmt"
+)
+
+var specAttrDesc = map[string]string{
+	"apiaddr":     "api multiaddr of the node",
+	"gatewayaddr": "gateway multiaddr of the node",
+	"swarmaddr":   "swarm multiaddr the transports are derived from",
+	"swarmaddrs":  "comma separated swarm multiaddrs, used as is",
+	"transports":  "comma separated transports to listen on (tcp, ws, quic)",
+	"ip6":         "also listen on the ip6 equivalent of the swarm address",
+	"transport":   "transport dialed when connecting to other nodes",
+	"swarmkey":    "swarm key file of the private network of the node",
+	"privnet":     "the testbed runs a private network",
+	"profile":     "configuration profile applied on init",
+	"configpatch": "configuration patch applied on init",
+	"mdns":        "enable mdns discovery",
+}
+
+// SpecAttrList returns the attributes read from the spec when a node is
+// constructed, nodes report them as given in the spec
+func SpecAttrList() []string {
+	return []string{
+		"apiaddr", "gatewayaddr", "swarmaddr", "swarmaddrs", "transports", "ip6",
+		"transport", "swarmkey", "privnet", "profile", "configpatch", "mdns",
+	}
+}
+
+// IsSpecAttr reports whether attr is read from the spec
+func IsSpecAttr(attr string) bool {
+	_, ok := specAttrDesc[attr]
+	return ok
+}
+
+// SpecAttrDesc returns the description of the spec attribute attr
+func SpecAttrDesc(attr string) (string, error) {
+	desc, ok := specAttrDesc[attr]
+	if !ok {
+		return "", fmt.Errorf("unrecognized attribute")
+	}
+
+	return desc, nil
+}
+
+// SpecAttr returns the value of the spec attribute attr in attrs
+func SpecAttr(attrs map[string]string, attr string) (string, error) {
+	v, ok := attrs[attr]
+	if !ok {
+		return "", fmt.Errorf("%s is not set in the spec of the node", attr)
+	}
+
+	return v, nil
+}
@@ -0,0 +1,122 @@
+package ipfs
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/ipfs/iptb/testbed/interfaces"
+)
+
+// TcCommands returns the tc invocations shaping the traffic received on
+// ifname, the host side interface of a node. The traffic of every peer gets
+// an htb class limiting its bandwidth and a netem qdisc adding latency and
+// loss, classified by source ipv4 or ipv6 address. Other traffic goes to the
+// default class, shaped by the conditions of the node itself. Conditions not
+// set on a peer fall back to those of the node. Without any condition, the
+// commands remove the shaping.
+func TcCommands(ifname string, node testbedi.LinkSettings, peers []testbedi.LinkPeer) [][]string {
+	cmds := [][]string{
+		{"qdisc", "del", "dev", ifname, "root"},
+	}
+
+	if node.IsZero() && len(peers) == 0 {
+		return cmds
+	}
+
+	cmds = append(cmds,
+		[]string{"qdisc", "add", "dev", ifname, "root", "handle", "1:", "htb", "default", "1"},
+		[]string{"class", "add", "dev", ifname, "parent", "1:", "classid", "1:1", "htb", "rate", rate(node)},
+	)
+
+	if node.Latency != 0 || node.Jitter != 0 || node.Loss != 0 {
+		netem := []string{"qdisc", "add", "dev", ifname, "parent", "1:1", "handle", "2:", "netem"}
+		cmds = append(cmds, append(netem, netemArgs(node)...))
+	}
+
+	for i, p := range peers {
+		class := fmt.Sprintf("1:%x", i+10)
+		handle := fmt.Sprintf("%x:", i+10)
+
+		ls := withDefaults(p.LinkSettings, node)
+		netem := []string{"qdisc", "add", "dev", ifname, "parent", class, "handle", handle, "netem"}
+
+		cmds = append(cmds,
+			[]string{"class", "add", "dev", ifname, "parent", "1:", "classid", class, "htb", "rate", rate(ls)},
+			append(netem, netemArgs(ls)...),
+			srcFilter(ifname, p.IP, class),
+		)
+	}
+
+	return cmds
+}
+
+// srcFilter returns the filter classifying the traffic from ip into class.
+// Filters of a priority share a protocol, ipv6 ones get their own.
+func srcFilter(ifname, ip, class string) []string {
+	proto, prio, match, bits := "ip", "1", "ip", "/32"
+	if strings.Contains(ip, ":") {
+		proto, prio, match, bits = "ipv6", "2", "ip6", "/128"
+	}
+
+	return []string{"filter", "add", "dev", ifname, "parent", "1:", "protocol", proto, "prio", prio,
+		"u32", "match", match, "src", ip + bits, "flowid", class}
+}
+
+// RunTcCommands runs the commands returned by TcCommands
+func RunTcCommands(cmds [][]string) error {
+	for i, args := range cmds {
+		out, err := exec.Command("tc", args...).CombinedOutput()
+
+		// The first command clears the interface, which may not be shaped yet
+		if err != nil && i != 0 {
+			return fmt.Errorf("tc %s: %s: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+		}
+	}
+
+	return nil
+}
+
+// withDefaults returns ls with the conditions it does not set taken from def
+func withDefaults(ls, def testbedi.LinkSettings) testbedi.LinkSettings {
+	if ls.Latency == 0 && ls.Jitter == 0 {
+		ls.Latency, ls.Jitter = def.Latency, def.Jitter
+	}
+
+	if ls.Loss == 0 {
+		ls.Loss = def.Loss
+	}
+
+	if ls.Bandwidth == 0 {
+		ls.Bandwidth = def.Bandwidth
+	}
+
+	return ls
+}
+
+// rate returns the htb rate limiting the bandwidth
+func rate(ls testbedi.LinkSettings) string {
+	if ls.Bandwidth == 0 {
+		return "10gbit"
+	}
+
+	return fmt.Sprintf("%gmbit", ls.Bandwidth)
+}
+
+// netemArgs returns the netem options adding the latency and loss
+func netemArgs(ls testbedi.LinkSettings) []string {
+	var args []string
+	if ls.Latency != 0 || ls.Jitter != 0 {
+		args = append(args, "delay", fmt.Sprintf("%dus", ls.Latency/time.Microsecond))
+		if ls.Jitter != 0 {
+			args = append(args, fmt.Sprintf("%dus", ls.Jitter/time.Microsecond))
+		}
+	}
+
+	if ls.Loss != 0 {
+		args = append(args, "loss", fmt.Sprintf("%g%%", ls.Loss))
+	}
+
+	return args
+}
@@ -0,0 +1,76 @@
+package ipfs
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs/iptb/testbed/interfaces"
+)
+
+func TestTcCommands(t *testing.T) {
+	cmds := TcCommands("veth0", testbedi.LinkSettings{}, nil)
+	if len(cmds) != 1 || strings.Join(cmds[0], " ") != "qdisc del dev veth0 root" {
+		t.Fatalf("unexpected commands %v", cmds)
+	}
+
+	cmds = TcCommands("veth0", testbedi.LinkSettings{}, []testbedi.LinkPeer{
+		{IP: "10.0.0.2", LinkSettings: testbedi.LinkSettings{Latency: 80 * time.Millisecond, Jitter: time.Millisecond, Loss: 1}},
+		{IP: "10.0.0.3", LinkSettings: testbedi.LinkSettings{Bandwidth: 2.5}},
+		{IP: "fd00::4", LinkSettings: testbedi.LinkSettings{Loss: 2}},
+	})
+
+	var lines []string
+	for _, c := range cmds {
+		lines = append(lines, strings.Join(c, " "))
+	}
+
+	expected := []string{
+		"qdisc del dev veth0 root",
+		"qdisc add dev veth0 root handle 1: htb default 1",
+		"class add dev veth0 parent 1: classid 1:1 htb rate 10gbit",
+		"class add dev veth0 parent 1: classid 1:a htb rate 10gbit",
+		"qdisc add dev veth0 parent 1:a handle a: netem delay 80000us 1000us loss 1%",
+		"filter add dev veth0 parent 1: protocol ip prio 1 u32 match ip src 10.0.0.2/32 flowid 1:a",
+		"class add dev veth0 parent 1: classid 1:b htb rate 2.5mbit",
+		"qdisc add dev veth0 parent 1:b handle b: netem",
+		"filter add dev veth0 parent 1: protocol ip prio 1 u32 match ip src 10.0.0.3/32 flowid 1:b",
+		"class add dev veth0 parent 1: classid 1:c htb rate 10gbit",
+		"qdisc add dev veth0 parent 1:c handle c: netem loss 2%",
+		"filter add dev veth0 parent 1: protocol ipv6 prio 2 u32 match ip6 src fd00::4/128 flowid 1:c",
+	}
+
+	if !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("unexpected commands:\n%s", strings.Join(lines, "\n"))
+	}
+}
+
+func TestTcCommandsNodeSettings(t *testing.T) {
+	node := testbedi.LinkSettings{Latency: 10 * time.Millisecond, Bandwidth: 100}
+
+	cmds := TcCommands("veth0", node, []testbedi.LinkPeer{
+		{IP: "10.0.0.2", LinkSettings: testbedi.LinkSettings{Latency: 80 * time.Millisecond}},
+	})
+
+	var lines []string
+	for _, c := range cmds {
+		lines = append(lines, strings.Join(c, " "))
+	}
+
+	// The node settings shape the default class, and fill in the
+	// conditions the link does not set
+	expected := []string{
+		"qdisc del dev veth0 root",
+		"qdisc add dev veth0 root handle 1: htb default 1",
+		"class add dev veth0 parent 1: classid 1:1 htb rate 100mbit",
+		"qdisc add dev veth0 parent 1:1 handle 2: netem delay 10000us",
+		"class add dev veth0 parent 1: classid 1:a htb rate 100mbit",
+		"qdisc add dev veth0 parent 1:a handle a: netem delay 80000us",
+		"filter add dev veth0 parent 1: protocol ip prio 1 u32 match ip src 10.0.0.2/32 flowid 1:a",
+	}
+
+	if !reflect.DeepEqual(lines, expected) {
+		t.Fatalf("unexpected commands:\n%s", strings.Join(lines, "\n"))
+	}
+}
@@ -1,6 +1,7 @@
 package ipfs
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -8,16 +9,16 @@ import (
 	"io/ioutil"
 	"net/http"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"time"
 
 	"github.com/ipfs/go-cid"
-	config "github.com/ipfs/go-ipfs-config"
 	_ "github.com/libp2p/go-libp2p"
-	"github.com/multiformats/go-multiaddr"
 	"github.com/pkg/errors"
 
 	"github.com/ipfs/iptb/testbed/interfaces"
+	"github.com/ipfs/iptb/util"
 )
 
 const (
@@ -72,12 +73,22 @@ func GetPeerID(l testbedi.Config) (*cid.Cid, error) {
 		return nil, err
 	}
 
-	lcfg, ok := icfg.(*config.Config)
+	cfg, ok := icfg.(map[string]interface{})
 	if !ok {
 		return nil, fmt.Errorf("Error: GetConfig() is not an ipfs config")
 	}
 
-	pcid, err := cid.Decode(lcfg.Identity.PeerID)
+	peerID, err := iptbutil.GetPath(cfg, "Identity.PeerID")
+	if err != nil {
+		return nil, err
+	}
+
+	spid, ok := peerID.(string)
+	if !ok {
+		return nil, fmt.Errorf("Identity.PeerID is not a string")
+	}
+
+	pcid, err := cid.Decode(spid)
 	if err != nil {
 		return nil, err
 	}
@@ -116,81 +127,173 @@ func GetAttrDesc(attr string) (string, error) {
 }
 
 func ReadLogs(l testbedi.Libp2p) (io.ReadCloser, error) {
-	addrStr, err := l.APIAddr()
+	url, err := iptbutil.APIURL(l, "log/tail")
 	if err != nil {
 		return nil, err
 	}
 
-	addr, err := multiaddr.NewMultiaddr(addrStr)
+	resp, err := http.Get(url)
 	if err != nil {
 		return nil, err
 	}
 
-	//TODO(tperson) ipv6
-	ip, err := addr.ValueForProtocol(multiaddr.P_IP4)
+	return resp.Body, nil
+}
+
+type BW struct {
+	TotalIn  int
+	TotalOut int
+}
+
+func GetBW(l testbedi.Libp2p) (*BW, error) {
+	url, err := iptbutil.APIURL(l, "stats/bw")
 	if err != nil {
 		return nil, err
 	}
-	pt, err := addr.ValueForProtocol(multiaddr.P_TCP)
+
+	resp, err := http.Get(url)
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 
-	resp, err := http.Get(fmt.Sprintf("http://%s:%s/api/v0/log/tail", ip, pt))
+	var bw BW
+	err = json.NewDecoder(resp.Body).Decode(&bw)
 	if err != nil {
 		return nil, err
 	}
 
-	return resp.Body, nil
+	return &bw, nil
 }
 
-type BW struct {
-	TotalIn  int
-	TotalOut int
+func GetAPIAddrFromRepo(dir string) (string, error) {
+	out, err := ioutil.ReadFile(filepath.Join(dir, "api"))
+	return string(out), err
 }
 
-func GetBW(l testbedi.Libp2p) (*BW, error) {
-	addrStr, err := l.APIAddr()
+// ReadConfig reads the configuration of the repo at dir as raw json, so keys
+// unknown to the plugin survive being written back
+func ReadConfig(dir string) (map[string]interface{}, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, "config"))
 	if err != nil {
 		return nil, err
 	}
 
-	addr, err := multiaddr.NewMultiaddr(addrStr)
-	if err != nil {
-		return nil, err
+	var cfg map[string]interface{}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("config: %s", err)
 	}
 
-	//TODO(tperson) ipv6
-	ip, err := addr.ValueForProtocol(multiaddr.P_IP4)
+	return cfg, nil
+}
+
+// WriteConfig writes cfg as the configuration of the repo at dir
+func WriteConfig(dir string, cfg interface{}) error {
+	data, err := json.MarshalIndent(cfg, "", "  ")
 	if err != nil {
-		return nil, err
+		return err
 	}
-	pt, err := addr.ValueForProtocol(multiaddr.P_TCP)
-	if err != nil {
-		return nil, err
+
+	return ioutil.WriteFile(filepath.Join(dir, "config"), data, 0600)
+}
+
+// InitConfig overrides the settings of a freshly initialized configuration
+// which iptb manages for the node
+func InitConfig(cfg map[string]interface{}, swarmaddrs []string, apiaddr, gatewayaddr string, mdns bool) error {
+	settings := map[string]interface{}{
+		"Bootstrap":              nil,
+		"Addresses.Swarm":        swarmaddrs,
+		"Addresses.API":          apiaddr,
+		"Addresses.Gateway":      gatewayaddr,
+		"Discovery.MDNS.Enabled": mdns,
+		"Experimental.QUIC":      HasTransport(swarmaddrs, TransportQUIC),
+	}
+
+	for path, value := range settings {
+		if err := iptbutil.SetPath(cfg, path, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// profiles are the configuration profiles known to `ipfs init --profile`
+var profiles = []string{
+	"server", "local-discovery", "test", "default-networking", "lowpower",
+	"randomports", "badgerds", "default-datastore", "flatfs",
+}
+
+// CheckProfile returns an error if profile, a comma separated list of
+// configuration profiles, names an unknown profile
+func CheckProfile(profile string) error {
+	if profile == "" {
+		return nil
+	}
+
+	for _, name := range strings.Split(profile, ",") {
+		known := false
+		for _, p := range profiles {
+			known = known || p == name
+		}
+
+		if !known {
+			return fmt.Errorf("unknown configuration profile %q (%s)", name, strings.Join(profiles, ", "))
+		}
+	}
+
+	return nil
+}
+
+// ApplyConfigPatch merges the json object patch into the configuration of the
+// node. A patch starting with `@` is read from the named file.
+func ApplyConfigPatch(l testbedi.Config, patch string) error {
+	if strings.HasPrefix(patch, "@") {
+		data, err := ioutil.ReadFile(patch[1:])
+		if err != nil {
+			return err
+		}
+
+		patch = string(data)
+	}
+
+	var obj map[string]interface{}
+	if err := json.Unmarshal([]byte(patch), &obj); err != nil {
+		return fmt.Errorf("config patch is not a json object: %s", err)
 	}
 
-	resp, err := http.Get(fmt.Sprintf("http://%s:%s/api/v0/stats/bw", ip, pt))
+	cfg, err := l.Config()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer resp.Body.Close()
 
-	var bw BW
-	err = json.NewDecoder(resp.Body).Decode(&bw)
+	m, err := iptbutil.ToMap(cfg)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return &bw, nil
+	if err := iptbutil.MergePath(m, "", obj); err != nil {
+		return err
+	}
+
+	return l.WriteConfig(m)
 }
 
-func GetAPIAddrFromRepo(dir string) (string, error) {
-	out, err := ioutil.ReadFile(filepath.Join(dir, "api"))
-	return string(out), err
+// InstallSwarmKey copies the pre-shared key at keyfile into the repo at dir,
+// making the node part of the private network defined by the key
+func InstallSwarmKey(keyfile, dir string) error {
+	key, err := ioutil.ReadFile(keyfile)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(dir, "swarm.key"), key, 0600)
 }
 
-func SwarmAddrs(l testbedi.Core) ([]string, error) {
+// SwarmAddrs returns the dialable swarm addresses of the node. Empty entries,
+// unspecified and link-local addresses are always dropped, loopback addresses
+// are only kept when `loopback` is set.
+func SwarmAddrs(l testbedi.Core, loopback bool) ([]string, error) {
 	pcid, err := l.PeerID()
 	if err != nil {
 		return nil, err
@@ -210,6 +313,15 @@ func SwarmAddrs(l testbedi.Core) ([]string, error) {
 
 	var maddrs []string
 	for _, straddr := range straddrs {
+		straddr = strings.TrimSpace(straddr)
+		if straddr == "" || IsUnroutable(straddr) {
+			continue
+		}
+
+		if !loopback && IsLoopback(straddr) {
+			continue
+		}
+
 		fstraddr := fmt.Sprintf("%s/ipfs/%s", straddr, pcid)
 		maddrs = append(maddrs, fstraddr)
 	}
@@ -217,6 +329,69 @@ func SwarmAddrs(l testbedi.Core) ([]string, error) {
 	return maddrs, nil
 }
 
+// Connect connects node l to node n, dialing each swarm address of n matching
+// opts in turn until one succeeds. The returned output lists every attempt.
+func Connect(ctx context.Context, l testbedi.Core, n testbedi.Core, opts testbedi.ConnectOptions) (testbedi.Output, error) {
+	addrs, err := n.SwarmAddrs()
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Transport != "" && opts.Transport != TransportAny {
+		addrs = FilterByTransport(addrs, opts.Transport)
+	}
+
+	if opts.AddrFilter != "" {
+		re, err := regexp.Compile(opts.AddrFilter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address filter: %s", err)
+		}
+
+		var filtered []string
+		for _, a := range addrs {
+			if re.MatchString(a) {
+				filtered = append(filtered, a)
+			}
+		}
+
+		addrs = filtered
+	}
+
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no address of node %s matches transport %q and filter %q", n, opts.Transport, opts.AddrFilter)
+	}
+
+	var report bytes.Buffer
+	for _, addr := range addrs {
+		args := []string{"ipfs", "swarm", "connect", addr}
+
+		output, err := l.RunCmd(ctx, nil, args...)
+		if err == nil && output.ExitCode() != 0 {
+			out, rerr := ioutil.ReadAll(output.Stderr())
+			if rerr != nil {
+				return nil, rerr
+			}
+
+			err = fmt.Errorf("%s", strings.TrimSpace(string(out)))
+		}
+
+		if err == nil {
+			fmt.Fprintf(&report, "dial %s: ok\n", addr)
+			return iptbutil.NewOutput(args, report.Bytes(), nil, 0, nil), nil
+		}
+
+		fmt.Fprintf(&report, "dial %s: %s\n", addr, err)
+
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	err = fmt.Errorf("could not connect to %s on any of %d addresses", n, len(addrs))
+
+	return iptbutil.NewOutput(nil, report.Bytes(), nil, 1, err), err
+}
+
 func WaitOnAPI(l testbedi.Libp2p) error {
 	for i := 0; i < 50; i++ {
 		err := tryAPICheck(l)
@@ -235,27 +410,12 @@ func WaitOnAPI(l testbedi.Libp2p) error {
 }
 
 func tryAPICheck(l testbedi.Libp2p) error {
-	addrStr, err := l.APIAddr()
-	if err != nil {
-		return err
-	}
-
-	addr, err := multiaddr.NewMultiaddr(addrStr)
-	if err != nil {
-		return err
-	}
-
-	//TODO(tperson) ipv6
-	ip, err := addr.ValueForProtocol(multiaddr.P_IP4)
-	if err != nil {
-		return err
-	}
-	pt, err := addr.ValueForProtocol(multiaddr.P_TCP)
+	url, err := iptbutil.APIURL(l, "id")
 	if err != nil {
 		return err
 	}
 
-	resp, err := http.Get(fmt.Sprintf("http://%s:%s/api/v0/id", ip, pt))
+	resp, err := http.Get(url)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,62 @@
+package ipfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigKeepsUnknownKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "iptb-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := `{"Identity": {"PeerID": "QmPeer"}, "Addresses": {"API": "", "Announce": ["/ip4/1.2.3.4/tcp/4001"]}, "Unknown": {"Key": 1}}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "config"), []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := ReadConfig(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := InitConfig(cfg, []string{"/ip4/127.0.0.1/tcp/0"}, "/ip4/127.0.0.1/tcp/5001", "", true); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteConfig(dir, cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err = ReadConfig(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := cfg["Unknown"]; !ok {
+		t.Fatal("expected unknown keys to be kept")
+	}
+
+	addrs := cfg["Addresses"].(map[string]interface{})
+	if addrs["API"] != "/ip4/127.0.0.1/tcp/5001" || addrs["Announce"] == nil {
+		t.Fatalf("expected the api address to be set next to the other addresses, got %v", addrs)
+	}
+}
+
+func TestCheckProfile(t *testing.T) {
+	for _, profile := range []string{"", "server", "server,lowpower"} {
+		if err := CheckProfile(profile); err != nil {
+			t.Errorf("%q: %s", profile, err)
+		}
+	}
+
+	for _, profile := range []string{"sever", "server,", "server,nope"} {
+		if err := CheckProfile(profile); err == nil {
+			t.Errorf("%q: expected an error", profile)
+		}
+	}
+}
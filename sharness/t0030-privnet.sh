@@ -0,0 +1,49 @@
+#!/bin/sh
+
+test_description="iptb private network tests"
+
+. lib/test-lib.sh
+
+export IPTB_ROOT=.
+ln -s ../plugins $IPTB_ROOT/plugins
+
+test_expect_success "iptb testbed create works with privnet" '
+	../bin/iptb --testbed a testbed create -count 2 -type localipfs -attr privnet -init &&
+	../bin/iptb --testbed b testbed create -count 1 -type localipfs -attr privnet -init
+'
+
+test_expect_success "every node has the testbed swarm key" '
+	test_cmp testbeds/a/swarm.key testbeds/a/0/swarm.key &&
+	test_cmp testbeds/a/swarm.key testbeds/a/1/swarm.key &&
+	test_cmp testbeds/b/swarm.key testbeds/b/0/swarm.key
+'
+
+test_expect_success "testbeds use different keys" '
+	test_must_fail test_cmp testbeds/a/swarm.key testbeds/b/swarm.key
+'
+
+test_expect_success "iptb start works" '
+	../bin/iptb --testbed a start --wait &&
+	../bin/iptb --testbed b start --wait
+'
+
+test_expect_success "nodes within a testbed can connect" '
+	../bin/iptb --testbed a connect 0 1
+'
+
+test_expect_success "get address of node in other testbed" '
+	IPFS_PATH=testbeds/b/0 ipfs id -f "<addrs>\n" | head -1 > addr_b &&
+	IPFS_PATH=testbeds/b/0 ipfs id -f "<id>" > id_b
+'
+
+test_expect_success "nodes of different testbeds can not connect" '
+	../bin/iptb --testbed a run 0 -- ipfs swarm connect "$(cat addr_b)/ipfs/$(cat id_b)" > connect_out &&
+	grep "exit 1" connect_out
+'
+
+test_expect_success "iptb stop works" '
+	../bin/iptb --testbed a stop &&
+	../bin/iptb --testbed b stop
+'
+
+test_done
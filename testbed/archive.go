@@ -0,0 +1,217 @@
+package testbed
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// writeArchive writes the tree under dir to w as a tar archive, with paths
+// relative to dir. Entries for which skip returns true are left out, along
+// with their contents.
+func writeArchive(w io.Writer, dir string, skip func(rel string, fi os.FileInfo) bool) error {
+	tw := tar.NewWriter(w)
+
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		if rel == "." {
+			return nil
+		}
+
+		if skip != nil && skip(rel, fi) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		var link string
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+
+		hdr, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return err
+		}
+
+		hdr.Name = filepath.ToSlash(rel)
+		if fi.IsDir() {
+			hdr.Name += "/"
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+// extractArchive extracts the tar archive read from r into dir, restoring
+// modes and modification times. Entries escaping dir are an error, either by
+// their path, by a symlink pointing outside of dir, or by being written
+// through a symlink extracted before.
+func extractArchive(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+
+	// Directory modes and times are set once their contents are extracted
+	var dirs []*tar.Header
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		path := filepath.Join(dir, filepath.FromSlash(hdr.Name))
+		if path != dir && !strings.HasPrefix(path, filepath.Clean(dir)+string(filepath.Separator)) {
+			return fmt.Errorf("archive entry %s is outside of %s", hdr.Name, dir)
+		}
+
+		if err := checkNoSymlinks(dir, path); err != nil {
+			return err
+		}
+
+		mode := os.FileMode(hdr.Mode).Perm()
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0775); err != nil {
+				return err
+			}
+
+			dirs = append(dirs, hdr)
+		case tar.TypeReg, tar.TypeRegA:
+			if err := extractFile(tr, path, mode); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			target := filepath.Join(filepath.Dir(path), filepath.FromSlash(hdr.Linkname))
+			if filepath.IsAbs(hdr.Linkname) || !withinDir(dir, target) {
+				return fmt.Errorf("archive entry %s links to %s, outside of %s", hdr.Name, hdr.Linkname, dir)
+			}
+
+			if err := os.MkdirAll(filepath.Dir(path), 0775); err != nil {
+				return err
+			}
+
+			if err := os.Symlink(hdr.Linkname, path); err != nil {
+				return err
+			}
+
+			continue
+		default:
+			return fmt.Errorf("archive entry %s has unsupported type %c", hdr.Name, hdr.Typeflag)
+		}
+
+		if hdr.Typeflag != tar.TypeDir {
+			if err := os.Chtimes(path, hdr.ModTime, hdr.ModTime); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		path := filepath.Join(dir, filepath.FromSlash(dirs[i].Name))
+		if err := os.Chmod(path, os.FileMode(dirs[i].Mode).Perm()); err != nil {
+			return err
+		}
+
+		if err := os.Chtimes(path, dirs[i].ModTime, dirs[i].ModTime); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkNoSymlinks makes sure none of the existing components of path below
+// dir, path included, is a symlink, so nothing is written through one
+func checkNoSymlinks(dir, path string) error {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return err
+	}
+
+	cur := dir
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		if part == "." {
+			continue
+		}
+
+		cur = filepath.Join(cur, part)
+
+		fi, err := os.Lstat(cur)
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("archive entry %s is written through the symlink %s", rel, cur)
+		}
+	}
+
+	return nil
+}
+
+func extractFile(r io.Reader, path string, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0775); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	// The mode given to OpenFile is subject to the umask
+	return os.Chmod(path, mode)
+}
@@ -0,0 +1,204 @@
+package testbed
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// datastoreDirs are the directories of a node repository holding its data,
+// left out of exports without the datastore
+var datastoreDirs = map[string]bool{
+	"blocks":    true,
+	"datastore": true,
+}
+
+// ExportOptions configure Export
+type ExportOptions struct {
+	// ExcludeDatastore leaves the blocks and datastore of the node
+	// repositories out, keeping their keys, configuration and logs
+	ExcludeDatastore bool
+}
+
+// Export writes the testbed directory to w as a gzipped tar archive, which
+// Import turns back into a testbed. Snapshots are left out. Nodes
+// implementing testbedi.Faulter must be stopped.
+func (tb *BasicTestbed) Export(ctx context.Context, w io.Writer, opts ExportOptions) error {
+	specs, err := tb.Specs()
+	if err != nil {
+		return err
+	}
+
+	nodeDirs := make(map[string]bool)
+	for i, spec := range specs {
+		if !withinDir(tb.dir, spec.Dir) {
+			return fmt.Errorf("node[%d]: directory %s is outside of the testbed", i, spec.Dir)
+		}
+
+		rel, err := filepath.Rel(tb.dir, spec.Dir)
+		if err != nil {
+			return err
+		}
+
+		nodeDirs[rel] = true
+	}
+
+	if err := tb.checkStopped(ctx); err != nil {
+		return err
+	}
+
+	skip := func(rel string, fi os.FileInfo) bool {
+		if rel == SnapshotDir {
+			return true
+		}
+
+		return opts.ExcludeDatastore && fi.IsDir() && datastoreDirs[filepath.Base(rel)] && nodeDirs[filepath.Dir(rel)]
+	}
+
+	gw := gzip.NewWriter(w)
+	if err := writeArchive(gw, tb.dir, skip); err != nil {
+		return err
+	}
+
+	return gw.Close()
+}
+
+// Import extracts a testbed exported with Export into dir, which must not
+// exist. The node directories and the paths of the testbed files, such as the
+// swarm key, are rewritten for dir. The plugins and binaries of the nodes must
+// be available. On failure dir is left untouched.
+func Import(r io.Reader, dir string) (*Manifest, error) {
+	if _, err := os.Stat(dir); err == nil {
+		return nil, fmt.Errorf("%s already exists", dir)
+	}
+
+	parent := filepath.Dir(filepath.Clean(dir))
+	if err := os.MkdirAll(parent, 0775); err != nil {
+		return nil, err
+	}
+
+	// The testbed is extracted next to dir, then moved in place once it
+	// checks out
+	tmp, err := ioutil.TempDir(parent, "."+filepath.Base(dir))
+	if err != nil {
+		return nil, err
+	}
+
+	// TempDir creates the directory for the owner only
+	err = os.Chmod(tmp, 0775)
+	if err == nil {
+		err = importTo(r, tmp)
+	}
+
+	if err == nil {
+		err = os.Rename(tmp, dir)
+	}
+
+	if err != nil {
+		os.RemoveAll(tmp)
+		return nil, err
+	}
+
+	return ReadManifest(dir)
+}
+
+// importTo extracts the archive into dir and rewrites its manifest, with the
+// node directories relative to dir
+func importTo(r io.Reader, dir string) error {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+
+	if err := extractArchive(gr, dir); err != nil {
+		return err
+	}
+
+	m, err := ReadManifest(dir)
+	if err != nil {
+		return err
+	}
+
+	var missing []string
+	for _, spec := range m.Nodes {
+		if _, ok := GetPlugin(spec.Type); !ok && !containsString(missing, spec.Type) {
+			missing = append(missing, spec.Type)
+		}
+	}
+
+	if len(missing) != 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("testbed requires plugins which are not loaded: %s", strings.Join(missing, ", "))
+	}
+
+	missing = nil
+	for _, spec := range m.Nodes {
+		if bin, ok := spec.Attrs["binary"]; ok && !findBinary(bin) && !containsString(missing, bin) {
+			missing = append(missing, bin)
+		}
+	}
+
+	if len(missing) != 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("testbed requires binaries which are not found: %s", strings.Join(missing, ", "))
+	}
+
+	// Node directories and path attributes recorded relative to the testbed
+	// are already within it, absolute ones from older manifests are found by
+	// name
+	for i, spec := range m.Nodes {
+		if !withinDir(dir, spec.Dir) {
+			spec.Dir = filepath.Join(dir, filepath.Base(spec.Dir))
+		}
+
+		if _, err := os.Stat(spec.Dir); err != nil {
+			return fmt.Errorf("node[%d]: directory %s is missing from the archive", i, filepath.Base(spec.Dir))
+		}
+
+		for _, attr := range pathAttrs {
+			v, ok := spec.Attrs[attr]
+			if !ok || withinDir(dir, v) {
+				continue
+			}
+
+			if moved := filepath.Join(dir, filepath.Base(v)); fileExists(moved) {
+				spec.Attrs[attr] = moved
+			}
+		}
+	}
+
+	return WriteManifest(dir, m)
+}
+
+// findBinary reports whether the binary bin, a path or a name looked up in
+// the PATH, exists
+func findBinary(bin string) bool {
+	if strings.ContainsRune(bin, os.PathSeparator) {
+		return fileExists(bin)
+	}
+
+	_, err := exec.LookPath(bin)
+	return err == nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
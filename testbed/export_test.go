@@ -0,0 +1,212 @@
+package testbed
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExportImport(t *testing.T) {
+	dir := tempTestbedDir(t)
+	defer os.RemoveAll(dir)
+
+	specs, err := BuildSpecs(dir, 2, "counting", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SetupPrivateNetwork(dir, specs); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteNodeSpecs(dir, specs); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, spec := range specs {
+		if err := os.MkdirAll(filepath.Join(spec.Dir, "blocks"), 0775); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(filepath.Join(spec.Dir, "config"), []byte("{}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tb := NewTestbed(dir)
+	if err := tb.CreateSnapshot(context.Background(), "s", false); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := tb.Export(context.Background(), &buf, ExportOptions{ExcludeDatastore: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	root := tempTestbedDir(t)
+	defer os.RemoveAll(root)
+
+	imported := filepath.Join(root, "testbeds", "copy")
+
+	m, err := Import(&buf, imported)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m.Nodes) != 2 {
+		t.Fatalf("expected 2 nodes, got %d", len(m.Nodes))
+	}
+
+	for i, spec := range m.Nodes {
+		if spec.Dir != filepath.Join(imported, filepath.Base(specs[i].Dir)) {
+			t.Fatalf("node[%d]: expected dir under %s, got %s", i, imported, spec.Dir)
+		}
+
+		if _, err := os.Stat(filepath.Join(spec.Dir, "config")); err != nil {
+			t.Fatalf("node[%d]: expected config to be imported: %s", i, err)
+		}
+
+		if _, err := os.Stat(filepath.Join(spec.Dir, "blocks")); !os.IsNotExist(err) {
+			t.Fatalf("node[%d]: expected blocks to be left out", i)
+		}
+
+		if key := filepath.Join(imported, SwarmKeyFile); spec.Attrs["swarmkey"] != key {
+			t.Fatalf("node[%d]: expected swarmkey %s, got %s", i, key, spec.Attrs["swarmkey"])
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(imported, SnapshotDir)); !os.IsNotExist(err) {
+		t.Fatal("expected snapshots to be left out")
+	}
+}
+
+func TestImportMissingRequirements(t *testing.T) {
+	cases := map[string]func(spec *NodeSpec){
+		"unregistered": func(spec *NodeSpec) {
+			spec.Type = "unregistered"
+		},
+		"missing-ipfs": func(spec *NodeSpec) {
+			spec.SetAttr("binary", "/nonexistent/missing-ipfs")
+		},
+	}
+
+	for missing, change := range cases {
+		dir := tempTestbedDir(t)
+		defer os.RemoveAll(dir)
+
+		specs, err := BuildSpecs(dir, 1, "counting", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		change(specs[0])
+		if err := WriteNodeSpecs(dir, specs); err != nil {
+			t.Fatal(err)
+		}
+
+		// Export loads the nodes, so the archive is written directly
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		if err := writeArchive(gw, dir, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := gw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		root := tempTestbedDir(t)
+		defer os.RemoveAll(root)
+
+		imported := filepath.Join(root, "copy")
+
+		_, err = Import(&buf, imported)
+		if err == nil || !strings.Contains(err.Error(), missing) {
+			t.Fatalf("expected %s to be reported, got %v", missing, err)
+		}
+
+		if _, err := os.Stat(imported); !os.IsNotExist(err) {
+			t.Fatal("expected no testbed to be left after a failed import")
+		}
+
+		files, err := ioutil.ReadDir(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(files) != 0 {
+			t.Fatalf("expected temporary files to be removed, found %d", len(files))
+		}
+	}
+}
+
+func TestExtractArchiveSymlinks(t *testing.T) {
+	outside := tempTestbedDir(t)
+	defer os.RemoveAll(outside)
+
+	cases := map[string][]tar.Header{
+		"absolute target": {
+			{Name: "evil", Typeflag: tar.TypeSymlink, Linkname: outside},
+		},
+		"relative target outside": {
+			{Name: "0/evil", Typeflag: tar.TypeSymlink, Linkname: "../../x"},
+		},
+		"write through symlink": {
+			{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "0"},
+			{Name: "link/pwned", Typeflag: tar.TypeReg, Mode: 0644},
+		},
+	}
+
+	for name, hdrs := range cases {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for i := range hdrs {
+			if err := tw.WriteHeader(&hdrs[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := tw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		dir := tempTestbedDir(t)
+		if err := os.Mkdir(filepath.Join(dir, "0"), 0775); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := extractArchive(&buf, dir); err == nil {
+			t.Errorf("%s: expected extraction to fail", name)
+		}
+
+		if _, err := os.Stat(filepath.Join(dir, "0", "pwned")); !os.IsNotExist(err) {
+			t.Errorf("%s: expected nothing written through the symlink", name)
+		}
+
+		os.RemoveAll(dir)
+	}
+
+	// Links within the testbed are kept
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{Name: "0/link", Typeflag: tar.TypeSymlink, Linkname: "../swarm.key"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := tempTestbedDir(t)
+	defer os.RemoveAll(dir)
+
+	if err := extractArchive(&buf, dir); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,105 @@
+package testbedi
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LinkSettings are the network conditions of a link, zero values are not shaped
+type LinkSettings struct {
+	Latency time.Duration `json:",omitempty"`
+	Jitter  time.Duration `json:",omitempty"`
+	// Loss is the packet loss percentage
+	Loss float64 `json:",omitempty"`
+	// Bandwidth is in Mbps
+	Bandwidth float64 `json:",omitempty"`
+}
+
+// LinkPeer is a node whose traffic to a LinkShaper is shaped
+type LinkPeer struct {
+	// IP is the address the traffic of the peer comes from
+	IP string
+	LinkSettings
+}
+
+// LinkShaper is implemented by nodes which can shape the traffic they receive
+// from each of their peers, as set with `iptb link`
+type LinkShaper interface {
+	Core
+	// ShapeLinks shapes the traffic received from each of peers, on top of
+	// the network emulation settings of the node itself. Without peers only
+	// the settings of the node are left.
+	ShapeLinks(ctx context.Context, peers []LinkPeer) error
+}
+
+// Set parses val and sets the condition key, one of latency, jitter, loss or
+// bandwidth. A value of 0 removes the condition.
+func (ls *LinkSettings) Set(key, val string) error {
+	var err error
+
+	switch key {
+	case "latency", "jitter":
+		var dur time.Duration
+		if val != "0" {
+			dur, err = time.ParseDuration(val)
+		}
+
+		if err == nil && dur < 0 {
+			err = fmt.Errorf("must not be negative")
+		}
+
+		if key == "latency" {
+			ls.Latency = dur
+		} else {
+			ls.Jitter = dur
+		}
+	case "loss":
+		ls.Loss, err = strconv.ParseFloat(strings.TrimSuffix(val, "%"), 64)
+		if err == nil && (ls.Loss < 0 || ls.Loss > 100) {
+			err = fmt.Errorf("must be a percentage")
+		}
+	case "bandwidth":
+		ls.Bandwidth, err = strconv.ParseFloat(val, 64)
+		if err == nil && ls.Bandwidth < 0 {
+			err = fmt.Errorf("must not be negative")
+		}
+	default:
+		return fmt.Errorf("unknown link condition %s", key)
+	}
+
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %s", key, val, err)
+	}
+
+	return nil
+}
+
+// IsZero reports whether no condition is set
+func (ls LinkSettings) IsZero() bool {
+	return ls == LinkSettings{}
+}
+
+func (ls LinkSettings) String() string {
+	var parts []string
+
+	if ls.Latency != 0 {
+		parts = append(parts, fmt.Sprintf("latency=%s", ls.Latency))
+	}
+
+	if ls.Jitter != 0 {
+		parts = append(parts, fmt.Sprintf("jitter=%s", ls.Jitter))
+	}
+
+	if ls.Loss != 0 {
+		parts = append(parts, fmt.Sprintf("loss=%g", ls.Loss))
+	}
+
+	if ls.Bandwidth != 0 {
+		parts = append(parts, fmt.Sprintf("bandwidth=%g", ls.Bandwidth))
+	}
+
+	return strings.Join(parts, " ")
+}
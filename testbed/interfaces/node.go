@@ -23,6 +23,11 @@ type GetAttrListFunc func() []string
 // GetAttrDescFunc returns the description of the attribute `attr`
 type GetAttrDescFunc func(attr string) (string, error)
 
+// HostPortsFunc reports whether the node built from attrs listens on the ports
+// of the host, such as a process running on it. Fixed ports are only assigned
+// to and checked for these nodes. Plugins without it are assumed not to.
+type HostPortsFunc func(attrs map[string]string) bool
+
 type Libp2p interface {
 	// PeerID returns the peer id
 	PeerID() (string, error)
@@ -32,6 +37,62 @@ type Libp2p interface {
 	SwarmAddrs() ([]string, error)
 }
 
+// ConnectOptions selects which addresses of a remote node are dialed
+type ConnectOptions struct {
+	// Transport restricts dialing to addresses using the transport (tcp, ws, quic, ...)
+	Transport string
+	// AddrFilter restricts dialing to addresses matching the regular expression
+	AddrFilter string
+}
+
+// Dialer is implemented by nodes which can select the address used to connect
+// to another node
+type Dialer interface {
+	Core
+	// ConnectWith connects the node to n, trying each address of n matching opts
+	// in turn until one succeeds. The output reports every attempt made.
+	ConnectWith(ctx context.Context, n Core, opts ConnectOptions) (Output, error)
+}
+
+// Destroyer is implemented by nodes which hold resources outside of their
+// directory, such as containers or networks
+type Destroyer interface {
+	Core
+	// Destroy stops the node and releases the resources it holds. It is called
+	// for every node, in order, when the testbed is deleted.
+	Destroy(ctx context.Context) error
+}
+
+// Faults which can be injected into a node implementing Faulter
+const (
+	// FaultPause suspends the node without letting it know
+	FaultPause = "pause"
+	// FaultResume undoes FaultPause and FaultFreezeDisk
+	FaultResume = "resume"
+	// FaultKill ends the node abruptly, without a chance to shut down cleanly
+	FaultKill = "kill"
+	// FaultFreezeDisk blocks the reads and writes of the node to its disk
+	FaultFreezeDisk = "freeze-disk"
+)
+
+// States reported by Faulter
+const (
+	StatusRunning    = "running"
+	StatusStopped    = "stopped"
+	StatusPaused     = "paused"
+	StatusDiskFrozen = "disk-frozen"
+)
+
+// Faulter is implemented by nodes which can be made to fail on demand, to
+// test how the other nodes react to hung or killed peers
+type Faulter interface {
+	Core
+	// Fault injects fault into the running node
+	Fault(ctx context.Context, fault string) error
+	// Status returns the state of the node
+	Status(ctx context.Context) (string, error)
+}
+
 type Config interface {
 	Core
 	// Config returns the configuration of the node
@@ -0,0 +1,66 @@
+package testbed
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/ipfs/iptb/testbed/interfaces"
+)
+
+// LinksFile is the name of the file recording the conditions set on the links
+// between nodes with `iptb link`
+const LinksFile = "links.json"
+
+// Link records the conditions of the traffic sent from node From to node To
+type Link struct {
+	From int
+	To   int
+	testbedi.LinkSettings
+}
+
+// ReadLinks returns the links recorded for the testbed at dir, ordered by
+// source and destination
+func ReadLinks(dir string) ([]Link, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, LinksFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var links []Link
+	if err := json.Unmarshal(data, &links); err != nil {
+		return nil, err
+	}
+
+	return links, nil
+}
+
+// WriteLinks records the links of the testbed at dir, links without
+// conditions are dropped
+func WriteLinks(dir string, links []Link) error {
+	var out []Link
+	for _, l := range links {
+		if !l.IsZero() {
+			out = append(out, l)
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].From != out[j].From {
+			return out[i].From < out[j].From
+		}
+
+		return out[i].To < out[j].To
+	})
+
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filepath.Join(dir, LinksFile), data, 0664)
+}
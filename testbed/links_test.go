@@ -0,0 +1,74 @@
+package testbed
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ipfs/iptb/testbed/interfaces"
+)
+
+func TestLinkSettingsSet(t *testing.T) {
+	var ls testbedi.LinkSettings
+
+	for k, v := range map[string]string{"latency": "80ms", "jitter": "5ms", "loss": "1.5%", "bandwidth": "10"} {
+		if err := ls.Set(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := testbedi.LinkSettings{Latency: 80 * time.Millisecond, Jitter: 5 * time.Millisecond, Loss: 1.5, Bandwidth: 10}
+	if ls != expected {
+		t.Fatalf("expected %+v, got %+v", expected, ls)
+	}
+
+	if s := ls.String(); s != "latency=80ms jitter=5ms loss=1.5 bandwidth=10" {
+		t.Fatalf("unexpected string %q", s)
+	}
+
+	for k, v := range map[string]string{"latency": "80", "loss": "101", "bandwidth": "-1", "mtu": "1500"} {
+		if err := ls.Set(k, v); err == nil {
+			t.Errorf("%s=%s: expected an error", k, v)
+		}
+	}
+
+	if err := ls.Set("latency", "0"); err != nil || ls.Latency != 0 {
+		t.Fatalf("expected latency to be removed: %v", err)
+	}
+}
+
+func TestReadWriteLinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "iptb-links")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	links, err := ReadLinks(dir)
+	if err != nil || len(links) != 0 {
+		t.Fatalf("expected no links, got %v (%v)", links, err)
+	}
+
+	links = []Link{
+		{From: 2, To: 0, LinkSettings: testbedi.LinkSettings{Loss: 1}},
+		{From: 0, To: 1},
+		{From: 0, To: 2, LinkSettings: testbedi.LinkSettings{Latency: time.Second}},
+	}
+
+	if err := WriteLinks(dir, links); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := ReadLinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Links without conditions are dropped, the others sorted
+	expected := []Link{links[2], links[0]}
+	if !reflect.DeepEqual(read, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, read)
+	}
+}
@@ -0,0 +1,132 @@
+package testbed
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LockedError is returned by Lock when another process holds the lock of the
+// testbed for longer than the caller is willing to wait
+type LockedError struct {
+	Dir string
+	// PID of the process holding the lock, 0 if unknown
+	PID int
+}
+
+func (e *LockedError) Error() string {
+	holder := "another process"
+	if e.PID != 0 {
+		holder = fmt.Sprintf("process %d", e.PID)
+	}
+
+	return fmt.Sprintf("testbed %s is being modified by %s, try again later or wait for it with --lock-wait", e.Dir, holder)
+}
+
+// TestbedLock is an advisory lock on a testbed, held by processes changing
+// the testbed, such as its specs
+type TestbedLock struct {
+	f *os.File
+}
+
+// Lock takes the lock of the testbed, waiting up to wait for another process
+// to release it. The lock file sits next to the testbed directory, so the
+// lock survives the directory being removed and created again.
+func (tb *BasicTestbed) Lock(wait time.Duration) (*TestbedLock, error) {
+	path := filepath.Clean(tb.dir) + ".lock"
+
+	if err := os.MkdirAll(filepath.Dir(path), 0775); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0664)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(wait)
+	for {
+		err := lockFile(f)
+		if err == nil {
+			break
+		}
+
+		if !isLockHeld(err) {
+			f.Close()
+			return nil, err
+		}
+
+		if time.Now().After(deadline) {
+			f.Close()
+			return nil, &LockedError{Dir: tb.dir, PID: lockHolder(path)}
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// The pid of the holder is only informative
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())), 0)
+	}
+
+	return &TestbedLock{f: f}, nil
+}
+
+// Unlock releases the lock
+func (l *TestbedLock) Unlock() error {
+	if err := unlockFile(l.f); err != nil {
+		l.f.Close()
+		return err
+	}
+
+	return l.f.Close()
+}
+
+func lockHolder(path string) int {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return pid
+}
+
+// writeFileAtomic writes data to a temporary file next to path, then renames
+// it over path, so readers never see a partially written file
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+
+	tmp := f.Name()
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		err = os.Chmod(tmp, perm)
+	}
+
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package testbed
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("testbeds are not locked on windows")
+	}
+
+	dir, err := ioutil.TempDir("", "iptb-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tb := NewTestbed(filepath.Join(dir, "testbeds", "default"))
+
+	lock, err := tb.Lock(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The lock is held per open file, a second testbed contends with the first
+	other := NewTestbed(tb.Dir())
+	_, err = other.Lock(200 * time.Millisecond)
+
+	lerr, ok := err.(*LockedError)
+	if !ok {
+		t.Fatalf("expected a LockedError, got %v", err)
+	}
+
+	if lerr.PID != os.Getpid() {
+		t.Fatalf("expected the lock to be held by %d, got %d", os.Getpid(), lerr.PID)
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		lock.Unlock()
+	}()
+
+	second, err := other.Lock(5 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := second.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriteNodeSpecsAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "iptb-specs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	specs := []*NodeSpec{{Type: "localipfs", Dir: filepath.Join(dir, "0")}}
+	if err := WriteNodeSpecs(dir, specs); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := ReadNodeSpecs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(read) != 1 || read[0].Type != "localipfs" {
+		t.Fatalf("unexpected specs %v", read)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 1 {
+		t.Fatalf("expected only the specs in the directory, got %d files", len(files))
+	}
+}
+
+func TestWriteSeedsAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "iptb-seeds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	seeds := map[int][]Seed{1: {{Cid: "Qm", Size: 1024, Seed: 7}}}
+	if err := WriteSeeds(dir, seeds); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := ReadSeeds(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(read[1]) != 1 || read[1][0].Cid != "Qm" {
+		t.Fatalf("unexpected seeds %v", read)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 1 {
+		t.Fatalf("expected only the seeds in the directory, got %d files", len(files))
+	}
+}
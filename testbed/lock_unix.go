@@ -0,0 +1,20 @@
+// +build !windows
+
+package testbed
+
+import (
+	"os"
+	"syscall"
+)
+
+func lockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
+
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
+
+func isLockHeld(err error) bool {
+	return err == syscall.EWOULDBLOCK
+}
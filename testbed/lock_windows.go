@@ -0,0 +1,21 @@
+// +build windows
+
+package testbed
+
+import (
+	"os"
+)
+
+// Testbeds are not locked on windows, concurrent changes are not detected
+
+func lockFile(f *os.File) error {
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
+
+func isLockHeld(err error) bool {
+	return false
+}
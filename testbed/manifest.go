@@ -0,0 +1,239 @@
+package testbed
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ManifestFile is the name of the file describing a testbed
+const ManifestFile = "nodespec.json"
+
+// ManifestVersion is the version of the manifest written by this iptb.
+// Version 0 is a bare array of node specs with absolute node directories,
+// version 1 is the Manifest with node directories relative to the testbed.
+const ManifestVersion = 1
+
+// pathAttrs are the attributes holding the path of a file, recorded relative
+// to the testbed like node directories when the file is within it
+var pathAttrs = []string{"swarmkey"}
+
+// Manifest describes a testbed and its nodes
+type Manifest struct {
+	Version int
+	// Metadata are free form properties of the testbed
+	Metadata map[string]string `json:",omitempty"`
+	Created  CreationInfo
+	Nodes    []*NodeSpec
+}
+
+// CreationInfo records where and when a testbed was created
+type CreationInfo struct {
+	Time    time.Time
+	User    string `json:",omitempty"`
+	Host    string `json:",omitempty"`
+	Command string `json:",omitempty"`
+}
+
+// NewCreationInfo describes the creation of a testbed by the current process
+func NewCreationInfo() CreationInfo {
+	info := CreationInfo{
+		Time:    time.Now().UTC(),
+		Command: strings.Join(os.Args, " "),
+	}
+
+	if u, err := user.Current(); err == nil {
+		info.User = u.Username
+	}
+
+	if host, err := os.Hostname(); err == nil {
+		info.Host = host
+	}
+
+	return info
+}
+
+// ReadManifest reads the manifest of the testbed in dir. Manifests of older
+// versions are migrated, Version keeps the version read until the manifest
+// is written again. The node directories and the path attributes of the specs
+// are absolute.
+func ReadManifest(dir string) (*Manifest, error) {
+	path := filepath.Join(dir, ManifestFile)
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var m Manifest
+
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		if err := migrateV0(path, data, &m); err != nil {
+			return nil, fmt.Errorf("migrating %s: %s", path, err)
+		}
+	} else if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("parsing %s: %s", path, err)
+	}
+
+	if m.Version > ManifestVersion {
+		return nil, fmt.Errorf("%s has version %d, this iptb reads up to version %d", path, m.Version, ManifestVersion)
+	}
+
+	for _, spec := range m.Nodes {
+		if !filepath.IsAbs(spec.Dir) {
+			spec.Dir = filepath.Join(dir, spec.Dir)
+		}
+
+		for _, attr := range pathAttrs {
+			if v, ok := spec.Attrs[attr]; ok {
+				spec.Attrs[attr] = resolvePath(dir, v)
+			}
+		}
+	}
+
+	return &m, nil
+}
+
+// WriteManifest writes the manifest of the testbed in dir, node directories
+// and path attributes within the testbed directory are recorded relative to
+// it so the testbed can be moved
+func WriteManifest(dir string, m *Manifest) error {
+	if err := os.MkdirAll(dir, 0775); err != nil {
+		return err
+	}
+
+	out := *m
+	out.Version = ManifestVersion
+	out.Nodes = make([]*NodeSpec, len(m.Nodes))
+
+	for i, spec := range m.Nodes {
+		rel := *spec
+		rel.Dir = relativePath(dir, spec.Dir)
+
+		// The attributes are copied, the spec given keeps absolute paths
+		if spec.Attrs != nil {
+			rel.Attrs = make(map[string]string, len(spec.Attrs))
+			for k, v := range spec.Attrs {
+				rel.Attrs[k] = v
+			}
+		}
+
+		for _, attr := range pathAttrs {
+			if v, ok := rel.Attrs[attr]; ok {
+				rel.Attrs[attr] = relativePath(dir, v)
+			}
+		}
+
+		out.Nodes[i] = &rel
+	}
+
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filepath.Join(dir, ManifestFile), append(data, '\n'), 0664)
+}
+
+// relativePath returns path relative to dir when it is within dir
+func relativePath(dir, path string) string {
+	if r, err := filepath.Rel(dir, path); err == nil && withinDir(dir, path) {
+		return r
+	}
+
+	return path
+}
+
+// resolvePath returns the absolute path of a path recorded by relativePath.
+// Absolute paths which do not exist anymore because the testbed moved are
+// found again within dir.
+func resolvePath(dir, path string) string {
+	if !filepath.IsAbs(path) {
+		return filepath.Join(dir, path)
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		return path
+	}
+
+	moved := filepath.Join(dir, filepath.Base(path))
+	if _, err := os.Stat(moved); err == nil {
+		return moved
+	}
+
+	return path
+}
+
+// migrateV0 reads a bare array of specs. Their node directories are absolute;
+// those which do not exist anymore because the testbed moved are found again
+// within the testbed directory.
+func migrateV0(path string, data []byte, m *Manifest) error {
+	var specs []*NodeSpec
+	if err := json.Unmarshal(data, &specs); err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	for _, spec := range specs {
+		spec.Dir = resolvePath(dir, spec.Dir)
+	}
+
+	m.Version = 0
+	m.Nodes = specs
+
+	if fi, err := os.Stat(path); err == nil {
+		m.Created.Time = fi.ModTime().UTC()
+	}
+
+	return nil
+}
+
+// Validate checks that the plugin of every node is registered, that the
+// plugin recognizes the attributes of the spec and that the node directory
+// exists. Every problem found is returned.
+func (m *Manifest) Validate() []error {
+	var errs []error
+
+	for i, spec := range m.Nodes {
+		if _, err := os.Stat(spec.Dir); err != nil {
+			errs = append(errs, fmt.Errorf("node[%d]: directory %s: %s", i, spec.Dir, err))
+		}
+
+		plg, ok := GetPlugin(spec.Type)
+		if !ok {
+			errs = append(errs, fmt.Errorf("node[%d]: plugin %s is not loaded", i, spec.Type))
+			continue
+		}
+
+		// Plugins without attributes can not tell which ones they recognize
+		if plg.GetAttrList == nil {
+			continue
+		}
+
+		known := make(map[string]bool)
+		for _, attr := range plg.GetAttrList() {
+			known[attr] = true
+		}
+
+		var unknown []string
+		for attr := range spec.Attrs {
+			if !known[attr] {
+				unknown = append(unknown, attr)
+			}
+		}
+
+		sort.Strings(unknown)
+		for _, attr := range unknown {
+			errs = append(errs, fmt.Errorf("node[%d]: attribute %s is not recognized by plugin %s", i, attr, spec.Type))
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,189 @@
+package testbed
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/iptb/testbed/interfaces"
+)
+
+func init() {
+	RegisterPlugin(IptbPlugin{
+		PluginName: "attributed",
+		NewNode: func(dir string, attrs map[string]string) (testbedi.Core, error) {
+			return &countingNode{}, nil
+		},
+		GetAttrList: func() []string {
+			return []string{"binary"}
+		},
+	}, false)
+}
+
+func tempTestbedDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "iptb-manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+func TestManifestRoundTrip(t *testing.T) {
+	dir := tempTestbedDir(t)
+	defer os.RemoveAll(dir)
+
+	specs, err := BuildSpecs(dir, 2, "counting", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SetupPrivateNetwork(dir, specs); err != nil {
+		t.Fatal(err)
+	}
+
+	m := &Manifest{
+		Metadata: map[string]string{"purpose": "test"},
+		Created:  NewCreationInfo(),
+		Nodes:    specs,
+	}
+
+	if err := WriteManifest(dir, m); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(data), dir) {
+		t.Fatalf("expected paths relative to the testbed, got %s", data)
+	}
+
+	read, err := ReadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if read.Version != ManifestVersion {
+		t.Fatalf("expected version %d, got %d", ManifestVersion, read.Version)
+	}
+
+	if read.Metadata["purpose"] != "test" {
+		t.Fatalf("expected metadata to be kept, got %v", read.Metadata)
+	}
+
+	for i, spec := range read.Nodes {
+		if spec.Dir != specs[i].Dir {
+			t.Fatalf("node[%d]: expected dir %s, got %s", i, specs[i].Dir, spec.Dir)
+		}
+
+		if key := filepath.Join(dir, SwarmKeyFile); spec.Attrs["swarmkey"] != key {
+			t.Fatalf("node[%d]: expected swarmkey %s, got %s", i, key, spec.Attrs["swarmkey"])
+		}
+	}
+
+	// The specs written keep their absolute paths
+	if !filepath.IsAbs(specs[0].Attrs["swarmkey"]) {
+		t.Fatalf("expected the written spec to be left untouched, got %s", specs[0].Attrs["swarmkey"])
+	}
+}
+
+func TestManifestMigrateV0(t *testing.T) {
+	dir := tempTestbedDir(t)
+	defer os.RemoveAll(dir)
+
+	if err := os.Mkdir(filepath.Join(dir, "0"), 0775); err != nil {
+		t.Fatal(err)
+	}
+
+	// The testbed was moved, the recorded directory does not exist anymore
+	specs := []*NodeSpec{{Type: "counting", Dir: "/nonexistent/testbed/0"}}
+
+	data, err := json.Marshal(specs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, ManifestFile), data, 0664); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := ReadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Version != 0 {
+		t.Fatalf("expected version 0, got %d", m.Version)
+	}
+
+	if m.Nodes[0].Dir != filepath.Join(dir, "0") {
+		t.Fatalf("expected moved dir to be found, got %s", m.Nodes[0].Dir)
+	}
+
+	if m.Created.Time.IsZero() {
+		t.Fatal("expected creation time from the manifest file")
+	}
+
+	if err := WriteManifest(dir, m); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err = ReadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Version != ManifestVersion {
+		t.Fatalf("expected version %d after writing, got %d", ManifestVersion, m.Version)
+	}
+}
+
+func TestManifestNewerVersion(t *testing.T) {
+	dir := tempTestbedDir(t)
+	defer os.RemoveAll(dir)
+
+	data := []byte(`{"Version": 99, "Nodes": []}`)
+	if err := ioutil.WriteFile(filepath.Join(dir, ManifestFile), data, 0664); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ReadManifest(dir); err == nil {
+		t.Fatal("expected reading a newer manifest to fail")
+	}
+}
+
+func TestManifestValidate(t *testing.T) {
+	dir := tempTestbedDir(t)
+	defer os.RemoveAll(dir)
+
+	specs, err := BuildSpecs(dir, 3, "attributed", map[string]string{"binary": "ipfs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &Manifest{Nodes: specs}
+	if errs := m.Validate(); len(errs) != 0 {
+		t.Fatalf("expected no problems, got %v", errs)
+	}
+
+	specs[0].Attrs["bogus"] = "1"
+	specs[1].Type = "missing"
+	os.RemoveAll(specs[2].Dir)
+
+	errs := m.Validate()
+	if len(errs) != 3 {
+		t.Fatalf("expected 3 problems, got %v", errs)
+	}
+
+	for i, want := range []string{"bogus", "missing", "directory"} {
+		if !strings.Contains(errs[i].Error(), want) {
+			t.Fatalf("expected problem %d to mention %s, got %s", i, want, errs[i])
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package testbed
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/ipfs/iptb/util"
+)
+
+const (
+	// DefaultPortStride is the number of ports reserved for each node
+	DefaultPortStride = 10
+
+	portOffsetAPI     = 0
+	portOffsetSwarm   = 1
+	portOffsetGateway = 2
+)
+
+// PortAllocator assigns deterministic, non-overlapping port ranges to nodes.
+// Node n is assigned the range [Base + n*Stride, Base + (n+1)*Stride)
+type PortAllocator struct {
+	Host    string
+	Base    int
+	Stride  int
+	Gateway bool
+}
+
+// NewPortAllocator returns a PortAllocator starting at base, bound to localhost
+func NewPortAllocator(base int, gateway bool) *PortAllocator {
+	return &PortAllocator{
+		Host:    "127.0.0.1",
+		Base:    base,
+		Stride:  DefaultPortStride,
+		Gateway: gateway,
+	}
+}
+
+// Ports returns the api, swarm and gateway ports for node n
+func (pa *PortAllocator) Ports(n int) (api, swarm, gateway int) {
+	start := pa.Base + n*pa.Stride
+	return start + portOffsetAPI, start + portOffsetSwarm, start + portOffsetGateway
+}
+
+// Assign records the address assignments of each node in its spec. The
+// `apiaddr`, `swarmaddr` and, if enabled, `gatewayaddr` attributes are set.
+func (pa *PortAllocator) Assign(specs []*NodeSpec) error {
+	if pa.Stride <= portOffsetGateway {
+		return fmt.Errorf("port stride must be greater than %d", portOffsetGateway)
+	}
+
+	if pa.Base <= 0 {
+		return fmt.Errorf("port base must be a positive number")
+	}
+
+	if _, _, max := pa.Ports(len(specs) - 1); max > 65535 {
+		return fmt.Errorf("not enough ports to allocate %d nodes starting at %d", len(specs), pa.Base)
+	}
+
+	for i, spec := range specs {
+		if !spec.hostPorts() {
+			return fmt.Errorf("node[%d]: %s nodes do not listen on the ports of the host, ports can not be assigned to them", i, spec.Type)
+		}
+	}
+
+	for i, spec := range specs {
+		api, swarm, gateway := pa.Ports(i)
+
+		spec.SetAttr("apiaddr", tcpAddr(pa.Host, api))
+		spec.SetAttr("swarmaddr", tcpAddr(pa.Host, swarm))
+
+		if pa.Gateway {
+			spec.SetAttr("gatewayaddr", tcpAddr(pa.Host, gateway))
+		}
+	}
+
+	return nil
+}
+
+// CheckPorts verifies that the tcp ports assigned to the node through the
+// `apiaddr`, `swarmaddr` and `gatewayaddr` attributes are available. Only
+// /ip4 and /ip6 tcp addresses of nodes listening on the host are checked.
+func (ns *NodeSpec) CheckPorts() error {
+	if !ns.hostPorts() {
+		return nil
+	}
+
+	for _, attr := range []string{"apiaddr", "swarmaddr", "gatewayaddr"} {
+		maddr, ok := ns.Attrs[attr]
+		if !ok {
+			continue
+		}
+
+		// Other addresses, such as dns or udp ones, bind no tcp port which
+		// can be checked
+		if parts := strings.Split(maddr, "/"); len(parts) < 4 || parts[1] != "ip4" && parts[1] != "ip6" || parts[3] != "tcp" {
+			continue
+		}
+
+		_, host, port, err := iptbutil.ParseTCPAddr(maddr)
+		if err != nil {
+			return fmt.Errorf("%s: %s", attr, err)
+		}
+
+		// Port 0 is picked by the operating system
+		if port == "0" {
+			continue
+		}
+
+		ln, err := net.Listen("tcp", net.JoinHostPort(host, port))
+		if err != nil {
+			return fmt.Errorf("%s %s is not available: %s", attr, maddr, err)
+		}
+
+		ln.Close()
+	}
+
+	return nil
+}
+
+// hostPorts reports whether the node listens on the ports of the host, as
+// told by its plugin. Nodes of plugins which are not loaded are assumed to.
+func (ns *NodeSpec) hostPorts() bool {
+	plg, ok := GetPlugin(ns.Type)
+	if !ok {
+		return true
+	}
+
+	return plg.HostPorts != nil && plg.HostPorts(ns.Attrs)
+}
+
+func tcpAddr(host string, port int) string {
+	return fmt.Sprintf("/ip4/%s/tcp/%d", host, port)
+}
@@ -0,0 +1,107 @@
+package testbed
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestPortAllocatorAssign(t *testing.T) {
+	specs := []*NodeSpec{
+		{Type: "localipfs", Attrs: map[string]string{}},
+		{Type: "localipfs"},
+	}
+
+	pa := NewPortAllocator(15000, true)
+	if err := pa.Assign(specs); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []map[string]string{
+		{
+			"apiaddr":     "/ip4/127.0.0.1/tcp/15000",
+			"swarmaddr":   "/ip4/127.0.0.1/tcp/15001",
+			"gatewayaddr": "/ip4/127.0.0.1/tcp/15002",
+		},
+		{
+			"apiaddr":     "/ip4/127.0.0.1/tcp/15010",
+			"swarmaddr":   "/ip4/127.0.0.1/tcp/15011",
+			"gatewayaddr": "/ip4/127.0.0.1/tcp/15012",
+		},
+	}
+
+	for i, spec := range specs {
+		for k, v := range expected[i] {
+			if spec.Attrs[k] != v {
+				t.Errorf("node[%d] %s: expected %s, got %s", i, k, v, spec.Attrs[k])
+			}
+		}
+	}
+}
+
+func TestPortAllocatorOutOfRange(t *testing.T) {
+	specs := make([]*NodeSpec, 10)
+	for i := range specs {
+		specs[i] = &NodeSpec{}
+	}
+
+	if err := NewPortAllocator(65500, false).Assign(specs); err == nil {
+		t.Fatal("expected error allocating past port 65535")
+	}
+}
+
+func TestCheckPorts(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	taken := &NodeSpec{Attrs: map[string]string{"apiaddr": tcpAddr("127.0.0.1", port)}}
+	if err := taken.CheckPorts(); err == nil || !strings.Contains(err.Error(), "apiaddr") {
+		t.Fatalf("expected apiaddr to be unavailable, got: %v", err)
+	}
+
+	free := &NodeSpec{Attrs: map[string]string{"swarmaddr": "/ip4/127.0.0.1/tcp/0"}}
+	if err := free.CheckPorts(); err != nil {
+		t.Fatal(err)
+	}
+
+	other := &NodeSpec{Attrs: map[string]string{
+		"apiaddr":   "/dns4/localhost/tcp/5001",
+		"swarmaddr": "/ip4/127.0.0.1/udp/4001/quic",
+	}}
+	if err := other.CheckPorts(); err != nil {
+		t.Fatalf("expected addresses other than ip and tcp to be skipped, got: %v", err)
+	}
+
+	for _, maddr := range []string{"/ip4/127.0.0.1/tcp/99999", "/ip4/127.0.0.1/tcp/api", "/ip4/127.0.0.1/tcp"} {
+		invalid := &NodeSpec{Attrs: map[string]string{"apiaddr": maddr}}
+		if err := invalid.CheckPorts(); err == nil {
+			t.Fatalf("expected %s to be reported", maddr)
+		}
+	}
+}
+
+func TestPortsNotOnHost(t *testing.T) {
+	// faulting nodes do not tell they listen on the host
+	specs := []*NodeSpec{{Type: "faulting"}}
+	if err := NewPortAllocator(15000, false).Assign(specs); err == nil {
+		t.Fatal("expected ports not to be assigned to nodes which do not listen on the host")
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	spec := &NodeSpec{Type: "faulting", Attrs: map[string]string{"apiaddr": tcpAddr("127.0.0.1", port)}}
+	if err := spec.CheckPorts(); err != nil {
+		t.Fatalf("expected the ports of nodes which do not listen on the host to be skipped, got: %v", err)
+	}
+}
@@ -0,0 +1,41 @@
+package testbed
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+)
+
+// SwarmKeyFile is the name of the pre-shared key stored in the testbed directory
+const SwarmKeyFile = "swarm.key"
+
+// GenerateSwarmKey writes a new libp2p pre-shared key to path
+func GenerateSwarmKey(path string) error {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+
+	data := fmt.Sprintf("/key/swarm/psk/1.0.0/\n/base16/\n%s\n", hex.EncodeToString(key))
+
+	return ioutil.WriteFile(path, []byte(data), 0600)
+}
+
+// SetupPrivateNetwork generates a pre-shared key for the testbed at dir and
+// points every spec at it through the `swarmkey` attribute. Each testbed gets
+// its own key, so nodes of different testbeds can not connect to each other.
+func SetupPrivateNetwork(dir string, specs []*NodeSpec) error {
+	path := filepath.Join(dir, SwarmKeyFile)
+
+	if err := GenerateSwarmKey(path); err != nil {
+		return err
+	}
+
+	for _, spec := range specs {
+		spec.SetAttr("swarmkey", path)
+	}
+
+	return nil
+}
@@ -0,0 +1,49 @@
+package testbed
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// SeedsFile is the name of the file recording content added with `iptb seed`
+const SeedsFile = "seeds.json"
+
+// Seed records a piece of generated content added to a node
+type Seed struct {
+	Cid     string
+	Size    int64
+	Seed    int64
+	Chunker string `json:",omitempty"`
+}
+
+// ReadSeeds returns the content recorded for each node of the testbed at dir,
+// indexed by node
+func ReadSeeds(dir string) (map[int][]Seed, error) {
+	seeds := make(map[int][]Seed)
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, SeedsFile))
+	if os.IsNotExist(err) {
+		return seeds, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &seeds); err != nil {
+		return nil, err
+	}
+
+	return seeds, nil
+}
+
+// WriteSeeds records the content added to each node of the testbed at dir.
+// Callers updating the seeds hold the lock of the testbed.
+func WriteSeeds(dir string, seeds map[int][]Seed) error {
+	data, err := json.MarshalIndent(seeds, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filepath.Join(dir, SeedsFile), append(data, '\n'), 0664)
+}
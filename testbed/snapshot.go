@@ -0,0 +1,291 @@
+package testbed
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ipfs/iptb/testbed/interfaces"
+)
+
+// SnapshotDir is the directory of a testbed holding its snapshots
+const SnapshotDir = "snapshots"
+
+const snapshotExt = ".tar"
+
+// Snapshot describes a snapshot of a testbed
+type Snapshot struct {
+	Name    string
+	Created time.Time
+	Size    int64
+}
+
+// RunningError is returned when a testbed operation needs a node to be
+// stopped first
+type RunningError struct {
+	Node   int
+	Status string
+}
+
+func (e *RunningError) Error() string {
+	return fmt.Sprintf("node[%d] is %s, stop it first", e.Node, e.Status)
+}
+
+// CreateSnapshot archives the testbed directory, the manifest and node
+// directories, as snapshot name. Nodes implementing testbedi.Faulter must be
+// stopped, the others are assumed to be. An existing snapshot of the same
+// name is only replaced with force.
+func (tb *BasicTestbed) CreateSnapshot(ctx context.Context, name string, force bool) error {
+	if err := checkSnapshotName(name); err != nil {
+		return err
+	}
+
+	path := tb.snapshotPath(name)
+	if _, err := os.Stat(path); err == nil && !force {
+		return fmt.Errorf("snapshot %s already exists", name)
+	}
+
+	specs, err := tb.Specs()
+	if err != nil {
+		return err
+	}
+
+	for i, spec := range specs {
+		if !withinDir(tb.dir, spec.Dir) {
+			return fmt.Errorf("node[%d]: directory %s is outside of the testbed", i, spec.Dir)
+		}
+	}
+
+	if err := tb.checkStopped(ctx); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0775); err != nil {
+		return err
+	}
+
+	// The archive is written next to the snapshot, so a failure leaves an
+	// existing snapshot in place
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+name)
+	if err != nil {
+		return err
+	}
+
+	tmp := f.Name()
+
+	err = writeArchive(f, tb.dir, func(rel string, fi os.FileInfo) bool {
+		return rel == SnapshotDir
+	})
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
+
+// Snapshots lists the snapshots of the testbed, by name
+func (tb *BasicTestbed) Snapshots() ([]Snapshot, error) {
+	files, err := ioutil.ReadDir(filepath.Join(tb.dir, SnapshotDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var out []Snapshot
+	for _, fi := range files {
+		name := fi.Name()
+		if !fi.Mode().IsRegular() || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, snapshotExt) {
+			continue
+		}
+
+		out = append(out, Snapshot{
+			Name:    strings.TrimSuffix(name, snapshotExt),
+			Created: fi.ModTime(),
+			Size:    fi.Size(),
+		})
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Name < out[j].Name
+	})
+
+	return out, nil
+}
+
+// RestoreSnapshot replaces the contents of the testbed directory, other than
+// its snapshots, with snapshot name. Nodes implementing testbedi.Faulter must
+// be stopped. On failure the testbed is left untouched.
+func (tb *BasicTestbed) RestoreSnapshot(ctx context.Context, name string) error {
+	if err := checkSnapshotName(name); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(tb.snapshotPath(name)); os.IsNotExist(err) {
+		return fmt.Errorf("snapshot %s does not exist", name)
+	}
+
+	// A testbed without a manifest has no nodes to be running
+	if _, err := os.Stat(filepath.Join(tb.dir, ManifestFile)); err == nil {
+		if err := tb.checkStopped(ctx); err != nil {
+			return err
+		}
+	}
+
+	// The snapshot is extracted next to the testbed, then swapped in once it
+	// extracted completely
+	dir := filepath.Clean(tb.dir)
+	tmp, err := ioutil.TempDir(filepath.Dir(dir), "."+filepath.Base(dir))
+	if err != nil {
+		return err
+	}
+
+	// TempDir creates the directory for the owner only
+	err = os.Chmod(tmp, 0775)
+	if err == nil {
+		err = extractSnapshot(tb.snapshotPath(name), tmp)
+	}
+
+	if err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+
+	old := tmp + ".old"
+	if err := os.Rename(dir, old); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, dir); err != nil {
+		os.RemoveAll(tmp)
+		if rerr := os.Rename(old, dir); rerr != nil {
+			return fmt.Errorf("%s, the testbed was left in %s: %s", err, old, rerr)
+		}
+
+		return err
+	}
+
+	tb.reset()
+
+	// The snapshots are not part of the snapshot, they move with the testbed
+	snapshots := filepath.Join(old, SnapshotDir)
+	if err := os.Rename(snapshots, filepath.Join(dir, SnapshotDir)); err != nil {
+		return fmt.Errorf("moving the snapshots back from %s: %s", snapshots, err)
+	}
+
+	return os.RemoveAll(old)
+}
+
+// extractSnapshot extracts the snapshot at path into dir
+func extractSnapshot(path, dir string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return extractArchive(f, dir)
+}
+
+// DeleteSnapshot removes snapshot name
+func (tb *BasicTestbed) DeleteSnapshot(name string) error {
+	if err := checkSnapshotName(name); err != nil {
+		return err
+	}
+
+	err := os.Remove(tb.snapshotPath(name))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("snapshot %s does not exist", name)
+	}
+
+	return err
+}
+
+func (tb *BasicTestbed) snapshotPath(name string) string {
+	return filepath.Join(tb.dir, SnapshotDir, name+snapshotExt)
+}
+
+// checkStopped returns a RunningError for the first node implementing
+// testbedi.Faulter which is not stopped
+func (tb *BasicTestbed) checkStopped(ctx context.Context) error {
+	return tb.eachRunning(ctx, func(n int, node testbedi.Core, status string) error {
+		return &RunningError{Node: n, Status: status}
+	})
+}
+
+// StopRunning stops the nodes implementing testbedi.Faulter which are not
+// stopped
+func (tb *BasicTestbed) StopRunning(ctx context.Context) error {
+	return tb.eachRunning(ctx, func(n int, node testbedi.Core, status string) error {
+		if err := node.Stop(ctx); err != nil {
+			return fmt.Errorf("node[%d]: %s", n, err)
+		}
+
+		return nil
+	})
+}
+
+// eachRunning calls fn with the nodes implementing testbedi.Faulter which
+// are not stopped, stopping at the first error
+func (tb *BasicTestbed) eachRunning(ctx context.Context, fn func(n int, node testbedi.Core, status string) error) error {
+	nodes, err := tb.NodesContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i, n := range nodes {
+		f, ok := n.(testbedi.Faulter)
+		if !ok {
+			continue
+		}
+
+		status, err := f.Status(ctx)
+		if err != nil {
+			return fmt.Errorf("node[%d]: %s", i, err)
+		}
+
+		if status == testbedi.StatusStopped {
+			continue
+		}
+
+		if err := fn(i, n, status); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func checkSnapshotName(name string) error {
+	if name == "" || strings.HasPrefix(name, ".") || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid snapshot name %q", name)
+	}
+
+	return nil
+}
+
+func withinDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
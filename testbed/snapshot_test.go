@@ -0,0 +1,232 @@
+package testbed
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/iptb/testbed/interfaces"
+)
+
+// faultingNode reports running while its directory holds a running file
+type faultingNode struct {
+	testbedi.Core
+	dir string
+}
+
+func (n *faultingNode) Status(ctx context.Context) (string, error) {
+	if _, err := os.Stat(filepath.Join(n.dir, "running")); err == nil {
+		return testbedi.StatusRunning, nil
+	}
+
+	return testbedi.StatusStopped, nil
+}
+
+func (n *faultingNode) Fault(ctx context.Context, fault string) error {
+	return nil
+}
+
+func (n *faultingNode) Stop(ctx context.Context) error {
+	return os.Remove(filepath.Join(n.dir, "running"))
+}
+
+func init() {
+	RegisterPlugin(IptbPlugin{
+		PluginName: "faulting",
+		NewNode: func(dir string, attrs map[string]string) (testbedi.Core, error) {
+			return &faultingNode{dir: dir}, nil
+		},
+	}, false)
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	dir := tempTestbedDir(t)
+	defer os.RemoveAll(dir)
+
+	specs, err := BuildSpecs(dir, 2, "faulting", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteNodeSpecs(dir, specs); err != nil {
+		t.Fatal(err)
+	}
+
+	key := filepath.Join(specs[0].Dir, "key")
+	if err := ioutil.WriteFile(key, []byte("original"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink("key", filepath.Join(specs[0].Dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	tb := NewTestbed(dir)
+	ctx := context.Background()
+
+	if err := tb.CreateSnapshot(ctx, "clean", false); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tb.CreateSnapshot(ctx, "clean", false); err == nil {
+		t.Fatal("expected existing snapshot not to be replaced")
+	}
+
+	if err := ioutil.WriteFile(key, []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	extra := filepath.Join(specs[1].Dir, "extra")
+	if err := ioutil.WriteFile(extra, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tb.RestoreSnapshot(ctx, "clean"); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "original" {
+		t.Fatalf("expected restored contents, got %q", data)
+	}
+
+	fi, err := os.Stat(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fi.Mode().Perm() != 0600 {
+		t.Fatalf("expected restored mode 0600, got %o", fi.Mode().Perm())
+	}
+
+	if target, err := os.Readlink(filepath.Join(specs[0].Dir, "link")); err != nil || target != "key" {
+		t.Fatalf("expected restored symlink to key, got %q (%v)", target, err)
+	}
+
+	if _, err := os.Stat(extra); !os.IsNotExist(err) {
+		t.Fatal("expected files added after the snapshot to be removed")
+	}
+
+	snapshots, err := tb.Snapshots()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(snapshots) != 1 || snapshots[0].Name != "clean" {
+		t.Fatalf("expected snapshot clean, got %v", snapshots)
+	}
+
+	if err := tb.DeleteSnapshot("clean"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tb.DeleteSnapshot("clean"); err == nil {
+		t.Fatal("expected deleting a missing snapshot to fail")
+	}
+}
+
+func TestSnapshotRestoreCorrupt(t *testing.T) {
+	root := tempTestbedDir(t)
+	defer os.RemoveAll(root)
+
+	dir := filepath.Join(root, "default")
+
+	specs, err := BuildSpecs(dir, 1, "faulting", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteNodeSpecs(dir, specs); err != nil {
+		t.Fatal(err)
+	}
+
+	tb := NewTestbed(dir)
+	ctx := context.Background()
+
+	if err := tb.CreateSnapshot(ctx, "clean", false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Truncate the snapshot halfway
+	path := tb.snapshotPath("clean")
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Truncate(path, fi.Size()/2); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tb.RestoreSnapshot(ctx, "clean"); err == nil {
+		t.Fatal("expected restoring a truncated snapshot to fail")
+	}
+
+	if _, err := tb.Specs(); err != nil {
+		t.Fatalf("expected the testbed to be left untouched, got %s", err)
+	}
+
+	if snapshots, err := tb.Snapshots(); err != nil || len(snapshots) != 1 {
+		t.Fatalf("expected the snapshot to be kept, got %v (%v)", snapshots, err)
+	}
+
+	files, err := ioutil.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fi := range files {
+		if strings.HasPrefix(fi.Name(), ".") {
+			t.Fatalf("expected the extraction to be cleaned up, found %s", fi.Name())
+		}
+	}
+}
+
+func TestSnapshotRunningNodes(t *testing.T) {
+	dir := tempTestbedDir(t)
+	defer os.RemoveAll(dir)
+
+	specs, err := BuildSpecs(dir, 2, "faulting", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteNodeSpecs(dir, specs); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(specs[1].Dir, "running"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tb := NewTestbed(dir)
+	ctx := context.Background()
+
+	err = tb.CreateSnapshot(ctx, "s", false)
+	if re, ok := err.(*RunningError); !ok || re.Node != 1 {
+		t.Fatalf("expected node 1 to be reported running, got %v", err)
+	}
+
+	if err := tb.StopRunning(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tb.CreateSnapshot(ctx, "s", false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSnapshotName(t *testing.T) {
+	for _, name := range []string{"", ".hidden", "a/b", `a\b`} {
+		if err := checkSnapshotName(name); err == nil {
+			t.Fatalf("expected name %q to be rejected", name)
+		}
+	}
+}
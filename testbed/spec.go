@@ -12,6 +12,8 @@ type NodeSpec struct {
 	Type  string
 	Dir   string
 	Attrs map[string]string
+	// Labels group nodes for selectors, such as role=bootstrap
+	Labels map[string]string `json:",omitempty"`
 }
 
 // IptbPlugin contains exported symbols from loaded plugins
@@ -20,6 +22,7 @@ type IptbPlugin struct {
 	NewNode     testbedi.NewNodeFunc
 	GetAttrList testbedi.GetAttrListFunc
 	GetAttrDesc testbedi.GetAttrDescFunc
+	HostPorts   testbedi.HostPortsFunc
 	PluginName  string
 	BuiltIn     bool
 }
@@ -132,6 +135,15 @@ func loadPlugin(path string) (*IptbPlugin, error) {
 		return nil, err
 	}
 
+	if HostPortsSym, err := pl.Lookup("HostPorts"); err == nil {
+		HostPorts, ok := HostPortsSym.(*testbedi.HostPortsFunc)
+		if !ok {
+			return nil, fmt.Errorf("Error: could not cast `HostPorts` of %s", pl)
+		}
+
+		plg.HostPorts = *HostPorts
+	}
+
 	return &plg, nil
 }
 
@@ -149,9 +161,41 @@ func (ns *NodeSpec) Load() (testbedi.Core, error) {
 
 // SetAttr sets an attribute on the NodeSpec
 func (ns *NodeSpec) SetAttr(attr string, val string) {
+	if ns.Attrs == nil {
+		ns.Attrs = make(map[string]string)
+	}
+
 	ns.Attrs[attr] = val
 }
 
+// LabelType is the label of every node holding the type of the node
+const LabelType = "type"
+
+// SetLabel sets a label on the NodeSpec, the type label can not be set
+func (ns *NodeSpec) SetLabel(key string, val string) error {
+	if key == LabelType {
+		return fmt.Errorf("label %s is the type of the node", LabelType)
+	}
+
+	if ns.Labels == nil {
+		ns.Labels = make(map[string]string)
+	}
+
+	ns.Labels[key] = val
+	return nil
+}
+
+// Label returns the value of a label of the NodeSpec, including the type
+// label
+func (ns *NodeSpec) Label(key string) (string, bool) {
+	if key == LabelType {
+		return ns.Type, true
+	}
+
+	v, ok := ns.Labels[key]
+	return v, ok
+}
+
 // GetAttr gets an attribute from the NodeSpec
 func (ns *NodeSpec) GetAttr(attr string) (string, error) {
 	if v, ok := ns.Attrs[attr]; ok {
@@ -1,12 +1,13 @@
 package testbed
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
+	"sync"
 
 	"github.com/ipfs/iptb/testbed/interfaces"
 	"github.com/ipfs/iptb/util"
@@ -37,14 +38,24 @@ type Testbed interface {
 }
 
 type BasicTestbed struct {
-	dir   string
+	dir string
+
+	// loaded is shared by the copies of the testbed, so specs are read and
+	// nodes constructed once
+	loaded *loaded
+}
+
+type loaded struct {
+	lk    sync.Mutex
 	specs []*NodeSpec
+	// nodes holds the nodes constructed so far, by index
 	nodes []testbedi.Core
 }
 
 func NewTestbed(dir string) BasicTestbed {
 	return BasicTestbed{
-		dir: dir,
+		dir:    dir,
+		loaded: &loaded{},
 	}
 }
 
@@ -57,17 +68,87 @@ func (tb BasicTestbed) Name() string {
 }
 
 func AlreadyInitCheck(dir string, force bool) error {
-	if _, err := os.Stat(filepath.Join(dir, "nodespec.json")); !os.IsNotExist(err) {
+	if _, err := os.Stat(filepath.Join(dir, ManifestFile)); !os.IsNotExist(err) {
 		if !force && !iptbutil.YesNoPrompt("testbed nodes already exist, overwrite? [y/n]") {
 			return nil
 		}
 
-		return os.RemoveAll(dir)
+		tb := NewTestbed(dir)
+		err := tb.Destroy(context.TODO())
+		if warnings, ok := err.(DestroyWarnings); ok {
+			fmt.Fprintf(os.Stderr, "%s\n", warnings)
+			return nil
+		}
+
+		return err
 	}
 
 	return nil
 }
 
+// DestroyWarnings are the failures of nodes to release their resources while
+// their testbed was destroyed. The testbed directory is removed regardless.
+type DestroyWarnings []error
+
+func (w DestroyWarnings) Error() string {
+	var msgs []string
+	for _, err := range w {
+		msgs = append(msgs, "warning: "+err.Error())
+	}
+
+	return strings.Join(msgs, "\n")
+}
+
+// Destroy releases the resources held outside of the testbed directory by
+// each node implementing testbedi.Destroyer, then removes the directory.
+// Nodes which fail to load or to release their resources do not keep the
+// directory from being removed, they are returned as DestroyWarnings.
+func (tb *BasicTestbed) Destroy(ctx context.Context) error {
+	var warnings DestroyWarnings
+
+	if specs, err := tb.Specs(); err == nil {
+		for i := range specs {
+			n, err := tb.NodeContext(ctx, i)
+			if err != nil {
+				warnings = append(warnings, fmt.Errorf("node[%d]: %s", i, err))
+				continue
+			}
+
+			d, ok := n.(testbedi.Destroyer)
+			if !ok {
+				continue
+			}
+
+			if err := d.Destroy(ctx); err != nil {
+				warnings = append(warnings, fmt.Errorf("node[%d]: %s", i, err))
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		warnings = append(warnings, err)
+	}
+
+	tb.reset()
+
+	if err := os.RemoveAll(tb.dir); err != nil {
+		return err
+	}
+
+	if len(warnings) != 0 {
+		return warnings
+	}
+
+	return nil
+}
+
+// reset drops the specs and nodes loaded so far, after the testbed directory
+// changed underneath them
+func (tb *BasicTestbed) reset() {
+	tb.loaded.lk.Lock()
+	tb.loaded.specs = nil
+	tb.loaded.nodes = nil
+	tb.loaded.lk.Unlock()
+}
+
 func BuildSpecs(base string, count int, typ string, attrs map[string]string) ([]*NodeSpec, error) {
 	var specs []*NodeSpec
 
@@ -78,10 +159,17 @@ func BuildSpecs(base string, count int, typ string, attrs map[string]string) ([]
 			return nil, err
 		}
 
+		// Each node gets its own copy of attrs, so per node assignments
+		// (such as ports) do not leak into the other specs
+		nattrs := make(map[string]string, len(attrs))
+		for k, v := range attrs {
+			nattrs[k] = v
+		}
+
 		spec := &NodeSpec{
 			Type:  typ,
 			Dir:   dir,
-			Attrs: attrs,
+			Attrs: nattrs,
 		}
 
 		specs = append(specs, spec)
@@ -97,59 +185,127 @@ func (tb *BasicTestbed) Spec(n int) (*NodeSpec, error) {
 		return nil, err
 	}
 
-	if n >= len(specs) {
+	if n < 0 || n >= len(specs) {
 		return nil, fmt.Errorf("Spec index out of range")
 	}
 
 	return specs[n], err
 }
 
+// Specs returns the specs of the nodes, read once from the testbed directory
 func (tb *BasicTestbed) Specs() ([]*NodeSpec, error) {
-	if tb.specs != nil {
-		return tb.specs, nil
-	}
+	tb.loaded.lk.Lock()
+	defer tb.loaded.lk.Unlock()
 
 	return tb.loadSpecs()
 }
 
 func (tb *BasicTestbed) Node(n int) (testbedi.Core, error) {
-	nodes, err := tb.Nodes()
+	return tb.NodeContext(context.Background(), n)
+}
+
+func (tb *BasicTestbed) Nodes() ([]testbedi.Core, error) {
+	return tb.NodesContext(context.Background())
+}
 
+// NodeContext returns node n, only node n is constructed
+func (tb *BasicTestbed) NodeContext(ctx context.Context, n int) (testbedi.Core, error) {
+	tb.loaded.lk.Lock()
+	defer tb.loaded.lk.Unlock()
+
+	specs, err := tb.loadSpecs()
 	if err != nil {
 		return nil, err
 	}
 
-	if n >= len(nodes) {
+	if n < 0 || n >= len(specs) {
 		return nil, fmt.Errorf("Node index out of range")
 	}
 
-	return nodes[n], err
+	return tb.loadNode(ctx, n)
 }
 
-func (tb *BasicTestbed) Nodes() ([]testbedi.Core, error) {
-	if tb.nodes != nil {
-		return tb.nodes, nil
+// NodesContext returns all the nodes, loading stops when ctx is done
+func (tb *BasicTestbed) NodesContext(ctx context.Context) ([]testbedi.Core, error) {
+	tb.loaded.lk.Lock()
+	defer tb.loaded.lk.Unlock()
+
+	specs, err := tb.loadSpecs()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make([]testbedi.Core, len(specs))
+	for n := range specs {
+		if nodes[n], err = tb.loadNode(ctx, n); err != nil {
+			return nil, err
+		}
 	}
 
-	return tb.loadNodes()
+	return nodes, nil
 }
 
+// Select returns a slice indexed like the testbed in which only the nodes in
+// list are loaded, the other entries are nil. Indexes outside of the testbed
+// are an error.
+func (tb *BasicTestbed) Select(ctx context.Context, list []int) ([]testbedi.Core, error) {
+	tb.loaded.lk.Lock()
+	defer tb.loaded.lk.Unlock()
+
+	specs, err := tb.loadSpecs()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make([]testbedi.Core, len(specs))
+	for _, n := range list {
+		if n < 0 || n >= len(specs) {
+			return nil, fmt.Errorf("Node range contains value (%d) outside of valid range [0-%d]", n, len(specs)-1)
+		}
+
+		if nodes[n], err = tb.loadNode(ctx, n); err != nil {
+			return nil, err
+		}
+	}
+
+	return nodes, nil
+}
+
+// loadSpecs reads the specs unless they already are, tb.loaded.lk is held
 func (tb *BasicTestbed) loadSpecs() ([]*NodeSpec, error) {
+	if tb.loaded.specs != nil {
+		return tb.loaded.specs, nil
+	}
+
 	specs, err := ReadNodeSpecs(tb.dir)
 	if err != nil {
 		return nil, err
 	}
 
+	tb.loaded.specs = specs
+	tb.loaded.nodes = make([]testbedi.Core, len(specs))
+
 	return specs, nil
 }
 
-func (tb *BasicTestbed) loadNodes() ([]testbedi.Core, error) {
-	specs, err := tb.Specs()
+// loadNode constructs node n unless it already is, the specs are loaded and
+// tb.loaded.lk is held
+func (tb *BasicTestbed) loadNode(ctx context.Context, n int) (testbedi.Core, error) {
+	if nd := tb.loaded.nodes[n]; nd != nil {
+		return nd, nil
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	nd, err := tb.loaded.specs[n].Load()
 	if err != nil {
 		return nil, err
 	}
 
-	return NodesFromSpecs(specs)
+	tb.loaded.nodes[n] = nd
+	return nd, nil
 }
 
 func NodesFromSpecs(specs []*NodeSpec) ([]testbedi.Core, error) {
@@ -164,32 +320,28 @@ func NodesFromSpecs(specs []*NodeSpec) ([]testbedi.Core, error) {
 	return out, nil
 }
 
+// ReadNodeSpecs reads the specs of the testbed in dir
 func ReadNodeSpecs(dir string) ([]*NodeSpec, error) {
-	data, err := ioutil.ReadFile(filepath.Join(dir, "nodespec.json"))
-	if err != nil {
-		return nil, err
-	}
-
-	var specs []*NodeSpec
-	err = json.Unmarshal(data, &specs)
+	m, err := ReadManifest(dir)
 	if err != nil {
 		return nil, err
 	}
 
-	return specs, nil
+	return m.Nodes, nil
 }
 
+// WriteNodeSpecs replaces the specs of the testbed in dir, keeping the rest
+// of its manifest. The file is replaced at once, callers changing existing
+// specs should hold the lock of the testbed.
 func WriteNodeSpecs(dir string, specs []*NodeSpec) error {
-	err := os.MkdirAll(dir, 0775)
-	if err != nil {
+	m, err := ReadManifest(dir)
+	if os.IsNotExist(err) {
+		m = &Manifest{Created: NewCreationInfo()}
+	} else if err != nil {
 		return err
 	}
 
-	fi, err := os.Create(filepath.Join(dir, "nodespec.json"))
-	if err != nil {
-		return err
-	}
+	m.Nodes = specs
 
-	defer fi.Close()
-	return json.NewEncoder(fi).Encode(specs)
+	return WriteManifest(dir, m)
 }
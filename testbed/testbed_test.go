@@ -0,0 +1,132 @@
+package testbed
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/ipfs/iptb/testbed/interfaces"
+)
+
+var constructed = map[string]int{}
+
+type countingNode struct {
+	testbedi.Core
+}
+
+func init() {
+	RegisterPlugin(IptbPlugin{
+		PluginName: "counting",
+		NewNode: func(dir string, attrs map[string]string) (testbedi.Core, error) {
+			constructed[dir]++
+			return &countingNode{}, nil
+		},
+	}, false)
+}
+
+func newCountingTestbed(t *testing.T, count int) (BasicTestbed, func()) {
+	dir, err := ioutil.TempDir("", "iptb-testbed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	specs, err := BuildSpecs(dir, count, "counting", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteNodeSpecs(dir, specs); err != nil {
+		t.Fatal(err)
+	}
+
+	return NewTestbed(dir), func() { os.RemoveAll(dir) }
+}
+
+func TestNodesLoadedLazilyOnce(t *testing.T) {
+	tb, done := newCountingTestbed(t, 4)
+	defer done()
+
+	for k := range constructed {
+		delete(constructed, k)
+	}
+
+	if _, err := tb.Node(3); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(constructed) != 1 {
+		t.Fatalf("expected only node 3 to be constructed, got %v", constructed)
+	}
+
+	nodes, err := tb.Select(context.Background(), []int{1, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(nodes) != 4 || len(constructed) != 2 {
+		t.Fatalf("expected nodes 1 and 3 to be constructed, got %v", constructed)
+	}
+
+	// Copies of the testbed share the loaded nodes
+	cp := tb
+	if _, err := cp.Nodes(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tb.Nodes(); err != nil {
+		t.Fatal(err)
+	}
+
+	for dir, n := range constructed {
+		if n != 1 {
+			t.Fatalf("node %s constructed %d times", dir, n)
+		}
+	}
+
+	if len(constructed) != 4 {
+		t.Fatalf("expected 4 nodes constructed, got %v", constructed)
+	}
+
+	if _, err := tb.Select(context.Background(), []int{4}); err == nil {
+		t.Fatal("expected an error for a node outside of the testbed")
+	}
+}
+
+func TestNodesContextCancelled(t *testing.T) {
+	tb, done := newCountingTestbed(t, 2)
+	defer done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := tb.NodesContext(ctx); err != context.Canceled {
+		t.Fatalf("expected loading to be cancelled, got %v", err)
+	}
+}
+
+func TestDestroyUnloadableNodes(t *testing.T) {
+	tb, done := newCountingTestbed(t, 2)
+	defer done()
+
+	specs, err := tb.Specs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	specs[1].Type = "unregistered"
+	if err := WriteNodeSpecs(tb.Dir(), specs); err != nil {
+		t.Fatal(err)
+	}
+
+	tb = NewTestbed(tb.Dir())
+
+	err = tb.Destroy(context.Background())
+	if warnings, ok := err.(DestroyWarnings); !ok || len(warnings) != 1 {
+		t.Fatalf("expected node 1 to be reported, got %v", err)
+	}
+
+	if _, err := os.Stat(tb.Dir()); !os.IsNotExist(err) {
+		t.Fatal("expected testbed directory to be removed")
+	}
+}
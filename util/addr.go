@@ -0,0 +1,74 @@
+package iptbutil
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/ipfs/iptb/testbed/interfaces"
+)
+
+// ParseTCPAddr extracts the protocol, host and port from a multiaddr string
+// in the form of /<proto>/<host>/tcp/<port>, where proto is one of ip4, ip6,
+// dns, dns4 or dns6
+func ParseTCPAddr(maddr string) (string, string, string, error) {
+	parts := strings.Split(maddr, "/")
+	if len(parts) < 5 || parts[0] != "" {
+		return "", "", "", fmt.Errorf("could not parse address %s", maddr)
+	}
+
+	switch parts[1] {
+	case "ip4", "ip6", "dns", "dns4", "dns6":
+	default:
+		return "", "", "", fmt.Errorf("unsupported protocol %s in %s", parts[1], maddr)
+	}
+
+	if parts[3] != "tcp" {
+		return "", "", "", fmt.Errorf("address %s is not a tcp address", maddr)
+	}
+
+	if _, err := strconv.ParseUint(parts[4], 10, 16); err != nil {
+		return "", "", "", fmt.Errorf("invalid port in %s", maddr)
+	}
+
+	return parts[1], parts[2], parts[4], nil
+}
+
+// HostPort returns a `host:port` pair suitable for dialing the tcp multiaddr
+// maddr. ip4, ip6, dns, dns4 and dns6 addresses are understood. Unspecified
+// addresses (0.0.0.0, ::) are rewritten to their loopback equivalent.
+func HostPort(maddr string) (string, error) {
+	proto, host, port, err := ParseTCPAddr(maddr)
+	if err != nil {
+		return "", err
+	}
+
+	switch proto {
+	case "ip4":
+		if host == "0.0.0.0" {
+			host = "127.0.0.1"
+		}
+	case "ip6":
+		if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+			host = "::1"
+		}
+	}
+
+	return net.JoinHostPort(host, port), nil
+}
+
+// APIURL returns the http url for the api endpoint `endpoint` of the node
+func APIURL(l testbedi.Libp2p, endpoint string) (string, error) {
+	addrStr, err := l.APIAddr()
+	if err != nil {
+		return "", err
+	}
+
+	hostport, err := HostPort(strings.TrimSpace(addrStr))
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("http://%s/api/v0/%s", hostport, endpoint), nil
+}
@@ -0,0 +1,32 @@
+package iptbutil
+
+import (
+	"testing"
+)
+
+func TestHostPort(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected string
+		err      bool
+	}{
+		{"/ip4/127.0.0.1/tcp/5001", "127.0.0.1:5001", false},
+		{"/ip4/0.0.0.0/tcp/5001", "127.0.0.1:5001", false},
+		{"/ip6/::1/tcp/5001", "[::1]:5001", false},
+		{"/ip6/::/tcp/5001", "[::1]:5001", false},
+		{"/dns4/localhost/tcp/5001", "localhost:5001", false},
+		{"/ip4/127.0.0.1/udp/5001", "", true},
+		{"127.0.0.1:5001", "", true},
+	}
+
+	for _, c := range cases {
+		hostport, err := HostPort(c.input)
+		if (err != nil) != c.err {
+			t.Errorf("%s: unexpected error state: %v", c.input, err)
+		}
+
+		if hostport != c.expected {
+			t.Errorf("%s: expected %s, got %s", c.input, c.expected, hostport)
+		}
+	}
+}
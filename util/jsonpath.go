@@ -0,0 +1,133 @@
+package iptbutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ToMap converts v, usually a node configuration, into a generic map by
+// round tripping it through json
+func ToMap(v interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// ParseValue parses s as json, falling back to using s as a plain string
+func ParseValue(s string) interface{} {
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		return s
+	}
+
+	return v
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(path, ".")
+	if path == "" {
+		return nil
+	}
+
+	return strings.Split(path, ".")
+}
+
+// GetPath returns the value at the dot separated path (e.g. Swarm.ConnMgr.HighWater)
+func GetPath(m map[string]interface{}, path string) (interface{}, error) {
+	var cur interface{} = m
+
+	for _, key := range splitPath(path) {
+		obj, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s: %s is not an object", path, key)
+		}
+
+		cur, ok = obj[key]
+		if !ok {
+			return nil, fmt.Errorf("%s: key %s not found", path, key)
+		}
+	}
+
+	return cur, nil
+}
+
+// SetPath sets the value at the dot separated path, creating intermediate
+// objects as needed
+func SetPath(m map[string]interface{}, path string, value interface{}) error {
+	keys := splitPath(path)
+	if len(keys) == 0 {
+		return fmt.Errorf("can not set the root object")
+	}
+
+	obj, err := objectAt(m, path, keys[:len(keys)-1])
+	if err != nil {
+		return err
+	}
+
+	obj[keys[len(keys)-1]] = value
+
+	return nil
+}
+
+// MergePath merges patch into the object at the dot separated path. Nested
+// objects are merged recursively, any other value replaces the existing one.
+func MergePath(m map[string]interface{}, path string, patch map[string]interface{}) error {
+	obj, err := objectAt(m, path, splitPath(path))
+	if err != nil {
+		return err
+	}
+
+	merge(obj, patch)
+
+	return nil
+}
+
+func objectAt(m map[string]interface{}, path string, keys []string) (map[string]interface{}, error) {
+	obj := m
+
+	for _, key := range keys {
+		next, ok := obj[key]
+		if !ok || next == nil {
+			child := make(map[string]interface{})
+			obj[key] = child
+			obj = child
+			continue
+		}
+
+		child, ok := next.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s: %s is not an object", path, key)
+		}
+
+		obj = child
+	}
+
+	return obj, nil
+}
+
+func merge(dst, src map[string]interface{}) {
+	for k, v := range src {
+		srcObj, ok := v.(map[string]interface{})
+		if !ok {
+			dst[k] = v
+			continue
+		}
+
+		dstObj, ok := dst[k].(map[string]interface{})
+		if !ok {
+			dst[k] = srcObj
+			continue
+		}
+
+		merge(dstObj, srcObj)
+	}
+}
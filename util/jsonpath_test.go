@@ -0,0 +1,103 @@
+package iptbutil
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSetGetPath(t *testing.T) {
+	m := map[string]interface{}{
+		"Swarm": map[string]interface{}{
+			"ConnMgr": map[string]interface{}{
+				"HighWater": 900.0,
+			},
+		},
+	}
+
+	if err := SetPath(m, "Swarm.ConnMgr.HighWater", ParseValue("100")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SetPath(m, "Experimental.QUIC", ParseValue("true")); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := GetPath(m, "Swarm.ConnMgr.HighWater")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v != 100.0 {
+		t.Errorf("expected 100, got %v", v)
+	}
+
+	v, err = GetPath(m, "Experimental.QUIC")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v != true {
+		t.Errorf("expected true, got %v", v)
+	}
+
+	if _, err := GetPath(m, "Swarm.ConnMgr.HighWater.Foo"); err == nil {
+		t.Error("expected error indexing into a number")
+	}
+
+	if _, err := GetPath(m, "Missing"); err == nil {
+		t.Error("expected error for missing key")
+	}
+}
+
+func TestMergePath(t *testing.T) {
+	m := map[string]interface{}{
+		"Swarm": map[string]interface{}{
+			"ConnMgr": map[string]interface{}{
+				"Type":      "basic",
+				"HighWater": 900.0,
+			},
+		},
+	}
+
+	patch := map[string]interface{}{
+		"ConnMgr": map[string]interface{}{
+			"HighWater": 50.0,
+		},
+		"DisableNatPortMap": true,
+	}
+
+	if err := MergePath(m, "Swarm", patch); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"Swarm": map[string]interface{}{
+			"ConnMgr": map[string]interface{}{
+				"Type":      "basic",
+				"HighWater": 50.0,
+			},
+			"DisableNatPortMap": true,
+		},
+	}
+
+	if !reflect.DeepEqual(m, expected) {
+		t.Errorf("expected %v, got %v", expected, m)
+	}
+}
+
+func TestParseValue(t *testing.T) {
+	cases := map[string]interface{}{
+		"10":         10.0,
+		"true":       true,
+		`"quoted"`:   "quoted",
+		"plain":      "plain",
+		"/ip4/1.2.3": "/ip4/1.2.3",
+		`["a","b"]`:  []interface{}{"a", "b"},
+	}
+
+	for input, expected := range cases {
+		if v := ParseValue(input); !reflect.DeepEqual(v, expected) {
+			t.Errorf("%s: expected %v, got %v", input, expected, v)
+		}
+	}
+}
@@ -2,6 +2,8 @@ package iptbutil
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 )
 
 func YesNoPrompt(prompt string) bool {
@@ -18,3 +20,42 @@ func YesNoPrompt(prompt string) bool {
 		fmt.Println("Please press either 'y' or 'n'")
 	}
 }
+
+// ParseBytes parses a size such as 512m, 100MB or 1GiB. Plain numbers are
+// bytes, KB/MB/GB are powers of 1000, KiB/MiB/GiB and the docker style k/m/g
+// powers of 1024.
+func ParseBytes(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		mult   int64
+	}{
+		{"KiB", 1 << 10},
+		{"MiB", 1 << 20},
+		{"GiB", 1 << 30},
+		{"KB", 1000},
+		{"MB", 1000 * 1000},
+		{"GB", 1000 * 1000 * 1000},
+		{"K", 1 << 10},
+		{"M", 1 << 20},
+		{"G", 1 << 30},
+		{"B", 1},
+	}
+
+	orig := s
+	s = strings.TrimSpace(s)
+	mult := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(strings.ToUpper(s), strings.ToUpper(u.suffix)) {
+			s = strings.TrimSpace(s[:len(s)-len(u.suffix)])
+			mult = u.mult
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", orig)
+	}
+
+	return int64(n * float64(mult)), nil
+}
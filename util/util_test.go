@@ -0,0 +1,37 @@
+package iptbutil
+
+import (
+	"testing"
+)
+
+func TestParseBytes(t *testing.T) {
+	cases := map[string]int64{
+		"1024":   1024,
+		"512m":   512 << 20,
+		"1g":     1 << 30,
+		"1GiB":   1 << 30,
+		"100MB":  100 * 1000 * 1000,
+		"1.5KiB": 1536,
+		"100B":   100,
+		"512KB":  512000,
+		"1.5MB":  1500000,
+		"100mb":  100000000,
+	}
+
+	for input, expected := range cases {
+		n, err := ParseBytes(input)
+		if err != nil {
+			t.Errorf("%s: %s", input, err)
+		}
+
+		if n != expected {
+			t.Errorf("%s: expected %d, got %d", input, expected, n)
+		}
+	}
+
+	for _, input := range []string{"", "m", "MB", "-1g", "-1KB", "lots"} {
+		if _, err := ParseBytes(input); err == nil {
+			t.Errorf("%s: expected an error", input)
+		}
+	}
+}